@@ -27,6 +27,8 @@ func main() {
 	var keyFile string
 	var enableTLS bool
 	var logLevel string
+	var httpChallengeAddr string
+	var tlsALPNChallengeAddr string
 	flag.BoolVar(&allowRoot, "allow-root", false, "./gocron-node -allow-root")
 	flag.StringVar(&serverAddr, "s", "0.0.0.0:5921", "./gocron-node -s ip:port")
 	flag.BoolVar(&version, "v", false, "./gocron-node -v")
@@ -35,6 +37,8 @@ func main() {
 	flag.StringVar(&certFile, "cert-file", "", "./gocron-node -cert-file path")
 	flag.StringVar(&keyFile, "key-file", "", "./gocron-node -key-file path")
 	flag.StringVar(&logLevel, "log-level", "info", "-log-level error")
+	flag.StringVar(&httpChallengeAddr, "http-challenge-addr", "", "./gocron-node -http-challenge-addr 0.0.0.0:5922")
+	flag.StringVar(&tlsALPNChallengeAddr, "tls-alpn-challenge-addr", "", "./gocron-node -tls-alpn-challenge-addr 0.0.0.0:5923")
 	flag.Parse()
 	level, err := log.ParseLevel(logLevel)
 	if err != nil {
@@ -72,5 +76,12 @@ func main() {
 		return
 	}
 
+	if httpChallengeAddr != "" {
+		server.StartHTTPChallenge(httpChallengeAddr)
+	}
+	if tlsALPNChallengeAddr != "" {
+		server.StartTLSALPNChallenge(tlsALPNChallengeAddr)
+	}
+
 	server.Start(serverAddr, enableTLS, certificate)
 }
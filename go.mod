@@ -7,6 +7,7 @@ require (
 	github.com/Unknwon/com v0.0.0-20190321035513-0fed4efef755 // indirect
 	github.com/aliyun/alibaba-cloud-sdk-go v0.0.0-20190805122214-0f87a8a69ca7
 	github.com/cihub/seelog v0.0.0-20170130134532-f561c5e57575
+	github.com/cpu/goacmedns v0.0.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-acme/lego/v3 v3.0.0
 	github.com/go-gomail/gomail v0.0.0-20160411212932-81ebce5c23df
@@ -23,10 +24,13 @@ require (
 	github.com/klauspost/compress v1.5.0 // indirect
 	github.com/klauspost/cpuid v1.2.1 // indirect
 	github.com/lib/pq v1.1.1
+	github.com/oracle/oci-go-sdk v6.1.0+incompatible
 	github.com/ouqiang/goutil v1.1.1
+	github.com/pavel-v-chernykh/keystore-go v2.1.0+incompatible
 	github.com/rakyll/statik v0.1.6
 	github.com/sirupsen/logrus v1.4.2
 	github.com/urfave/cli v1.21.0
+	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
 	golang.org/x/net v0.0.0-20190522155817-f3200d17e092
 	google.golang.org/genproto v0.0.0-20190530194941-fb225487d101 // indirect
 	google.golang.org/grpc v1.21.0
@@ -34,4 +38,6 @@ require (
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df // indirect
 	gopkg.in/ini.v1 v1.44.0
 	gopkg.in/macaron.v1 v1.3.2
+	gopkg.in/square/go-jose.v2 v2.3.1
+	software.sslmate.com/src/go-pkcs12 v0.0.0-20190322163127-6e380ad96778
 )
@@ -1,19 +1,33 @@
 package models
 
 import (
-	"github.com/go-xorm/xorm"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/go-xorm/xorm"
 )
 
-// 阿里云的 AccessKey
+// 默认的服务商, 兼容之前只保存阿里云AccessKey的数据
+const DefaultCredentialProvider = "alidns"
+
+// 服务商访问凭证, 如: 阿里云的 AccessKey、cloudflare的API Token
 type AccessKey struct {
 	Id int `xorm:"pk autoincr notnull " json:"id"`
 
-	//  key
-	AccessKeyId string `xorm:"varchar(64) notnull" json:"access_key_id"`
+	// 服务商名称, 与DomainConfig.ProviderName一致, 如: alidns、cloudflare
+	ProviderName string `xorm:"varchar(32) notnull default 'alidns'" json:"provider_name"`
 
-	// secret
-	AccessKeySecret string `xorm:"varchar(128) notnull" json:"access_key_secret"`
+	//  key, 阿里云使用
+	AccessKeyId string `xorm:"varchar(64) notnull default ''" json:"access_key_id"`
+
+	// secret, 阿里云使用, 加密保存
+	AccessKeySecret Secret `xorm:"varchar(512) notnull default ''" json:"access_key_secret"`
+
+	// 其他凭证参数, JSON对象, key为服务商配置的字段名, 如: {"AuthToken": "xxx", "ZoneToken": "xxx"}
+	// 值按字段类型转换, 时间间隔使用 30s、2m 或秒数, 加密保存
+	Credentials Secret `xorm:"text" json:"credentials"`
 
 	// 备注
 	Remark string `xorm:"varchar(128) " json:"remark"`
@@ -33,8 +47,52 @@ func (c *AccessKey) Create() (insertId int, err error) {
 	return
 }
 
+// 服务商名称, 为空时是阿里云
+func (c *AccessKey) GetProviderName() string {
+	if c.ProviderName == "" {
+		return DefaultCredentialProvider
+	}
+
+	return c.ProviderName
+}
+
+// 解析凭证参数, JSON中的数字、布尔值转为字符串
+func (c *AccessKey) CredentialMap() (map[string]string, error) {
+	values := make(map[string]string)
+	if strings.TrimSpace(string(c.Credentials)) == "" {
+		return values, nil
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(c.Credentials), &data); err != nil {
+		return nil, err
+	}
+	for key, value := range data {
+		if value == nil {
+			continue
+		}
+		values[key] = fmt.Sprint(value)
+	}
+
+	return values, nil
+}
+
+// 设置凭证参数
+func (c *AccessKey) SetCredentialMap(values map[string]string) error {
+	if len(values) == 0 {
+		c.Credentials = ""
+		return nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	c.Credentials = Secret(data)
+
+	return nil
+}
+
 func (c *AccessKey) UpdateBean(id int16) (int64, error) {
-	return Db.ID(id).Cols("access_key_id,access_key_secret,remark").Update(c)
+	return Db.ID(id).Cols("provider_name,access_key_id,access_key_secret,credentials,remark").Update(c)
 }
 
 // 更新
@@ -53,9 +111,19 @@ func (c *AccessKey) Find(id int) error {
 	return err
 }
 
+// secret加密保存, 每次加密结果不同, 需要解密后比较
 func (c *AccessKey) KeyAndSecretExists(key, value string) (bool, error) {
-	count, err := Db.Where("access_key_id = ? and access_key_secret = ?", key, value).Count(c)
-	return count > 0, err
+	list := make([]AccessKey, 0)
+	if err := Db.Where("access_key_id = ?", key).Find(&list); err != nil {
+		return false, err
+	}
+	for _, item := range list {
+		if string(item.AccessKeySecret) == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 func (c *AccessKey) List(params CommonMap) ([]AccessKey, error) {
@@ -70,7 +138,7 @@ func (c *AccessKey) List(params CommonMap) ([]AccessKey, error) {
 
 func (c *AccessKey) AllList() ([]AccessKey, error) {
 	list := make([]AccessKey, 0)
-	err := Db.Cols("access_key_id,access_key_secret,remark").Desc("id").Find(&list)
+	err := Db.Cols("id,provider_name,access_key_id,remark").Desc("id").Find(&list)
 
 	return list, err
 }
@@ -90,12 +158,12 @@ func (c *AccessKey) parseWhere(session *xorm.Session, params CommonMap) {
 	if ok && id.(int) > 0 {
 		session.And("id = ?", id)
 	}
+	providerName, ok := params["ProviderName"]
+	if ok && providerName.(string) != "" {
+		session.And("provider_name = ?", providerName)
+	}
 	key, ok := params["AccessKeyId"]
 	if ok && key.(string) != "" {
 		session.And("access_key_id = ?", key)
 	}
-	secret, ok := params["AccessKeySecret"]
-	if ok && secret.(string) != "" {
-		session.And("access_key_secret = ?", secret)
-	}
 }
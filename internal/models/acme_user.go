@@ -10,19 +10,43 @@ import (
 	"time"
 )
 
+// 账号在CA的状态，参考 https://tools.ietf.org/html/rfc8555#section-7.1.2
+const (
+	AcmeUserStatusValid       = "valid"       // 有效
+	AcmeUserStatusDeactivated = "deactivated" // 已停用，不能再使用
+	AcmeUserStatusRevoked     = "revoked"     // 被CA注销
+)
+
 // implements acme.User
 type AcmeUser struct {
 	Id int `json:"id" xorm:"pk autoincr notnull "`
 
-	// 邮件地址
-	Email string `json:"email" xorm:"varchar(64) notnull unique"`
+	// 邮件地址，同一个CA下唯一
+	Email string `json:"email" xorm:"varchar(64) notnull unique(email_ca)"`
+
+	// CA的ACME目录地址，支持内置的预设名称，如：letsencrypt、letsencrypt-staging、zerossl；为空时使用letsencrypt正式环境
+	CaDirUrl string `json:"ca_dir_url" xorm:"varchar(256) notnull default '' unique(email_ca)"`
+
+	// 访问CA时额外信任的根证书(PEM格式)，用于内部CA(step-ca)或本地测试CA(Pebble)
+	CaCertificates string `json:"ca_certificates" xorm:"text"`
 
-	// 类似域名注册服务器的账号密码,
-	PrivateKey string `xorm:"varchar(2048) " json:"private_key"`
+	// EAB(External Account Binding)的Key ID，ZeroSSL、Google等CA注册账号时需要
+	EabKid string `json:"eab_kid" xorm:"varchar(128) notnull default ''"`
+
+	// EAB的HMAC Key，base64url编码
+	EabHmacKey Secret `json:"eab_hmac_key" xorm:"varchar(512) notnull default ''"`
+
+	// 类似域名注册服务器的账号密码, 加密保存
+	PrivateKey Secret `xorm:"text" json:"private_key"`
 
 	// 官方返回的账号信息，需要保存下来
 	Resource string `xorm:"varchar(2048)  " json:"resource"`
 
+	// 账号在CA的状态：valid、deactivated、revoked，为空时未查询
+	Status string `json:"status" xorm:"varchar(16) notnull default ''"`
+	// 最后一次查询账号状态的时间
+	StatusCheckedAt time.Time `json:"status_checked_at" xorm:"datetime"`
+
 	BaseModel `json:"-" xorm:"-"`
 
 	Created time.Time `json:"created" xorm:"datetime notnull created"`
@@ -39,7 +63,17 @@ func (c *AcmeUser) Create() (insertId int, err error) {
 }
 
 func (c *AcmeUser) UpdateBean(id int) (int64, error) {
-	return Db.ID(id).Cols("email,private_key,resource").Update(c)
+	return Db.ID(id).Cols("email,ca_dir_url,ca_certificates,eab_kid,eab_hmac_key,private_key,resource,status,status_checked_at").Update(c)
+}
+
+// 更新账号状态
+func (c *AcmeUser) UpdateStatus(id int) (int64, error) {
+	return Db.ID(id).Cols("status,status_checked_at").Update(c)
+}
+
+// 是否已停用，停用或被注销的账号不能再申请证书
+func (c *AcmeUser) IsDeactivated() bool {
+	return c.Status == AcmeUserStatusDeactivated || c.Status == AcmeUserStatusRevoked
 }
 
 // 更新
@@ -58,13 +92,13 @@ func (c *AcmeUser) Find(id int) error {
 	return err
 }
 
-func (c *AcmeUser) EmailExists(domain string, id int16) (bool, error) {
+func (c *AcmeUser) EmailExists(email, caDirURL string, id int) (bool, error) {
 	if id == 0 {
-		count, err := Db.Where("email = ?", domain).Count(c)
+		count, err := Db.Where("email = ? AND ca_dir_url = ?", email, caDirURL).Count(c)
 		return count > 0, err
 	}
 
-	count, err := Db.Where("email = ? AND id != ?", domain, id).Count(c)
+	count, err := Db.Where("email = ? AND ca_dir_url = ? AND id != ?", email, caDirURL, id).Count(c)
 	return count > 0, err
 }
 
@@ -80,7 +114,7 @@ func (c *AcmeUser) List(params CommonMap) ([]AcmeUser, error) {
 
 func (c *AcmeUser) AllList() ([]AcmeUser, error) {
 	list := make([]AcmeUser, 0)
-	err := Db.Cols("email,private_key,resource").Desc("id").Find(&list)
+	err := Db.Cols("id,email,ca_dir_url,eab_kid,status,status_checked_at").Desc("id").Find(&list)
 
 	return list, err
 }
@@ -91,10 +125,15 @@ func (c *AcmeUser) Total(params CommonMap) (int64, error) {
 	return session.Count(c)
 }
 
+// 是否配置了EAB
+func (c *AcmeUser) HasExternalAccountBinding() bool {
+	return c.EabKid != "" && c.EabHmacKey != ""
+}
+
 // 存储账号的用户名和密钥
 func (c *AcmeUser) SaveResourceAndPrivateKey(privateKey crypto.PrivateKey, resource *registration.Resource) (err error) {
 	pemKey := certcrypto.PEMBlock(privateKey)
-	c.PrivateKey = string(pem.EncodeToMemory(pemKey))
+	c.PrivateKey = Secret(pem.EncodeToMemory(pemKey))
 	var d []byte
 	if d, err = json.MarshalIndent(resource, "", "\t"); err != nil {
 		return
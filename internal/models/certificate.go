@@ -1,22 +1,100 @@
 package models
 
 import (
-	"github.com/go-xorm/xorm"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// 证书状态
+const (
+	CertificateStatusActive     = "active"     // 有效
+	CertificateStatusSuperseded = "superseded" // 已被新证书替换
+	CertificateStatusRevoked    = "revoked"    // 已注销
+	CertificateStatusExpired    = "expired"    // 已过期
 )
 
+// 证书来源
+const (
+	CertificateSourceAcme     = "acme"     // 通过ACME申请
+	CertificateSourceExternal = "external" // 导入的其他CA签发的证书，不能通过ACME续期
+)
+
+const certificateColumns = "status,source,domain_config_id,acme_user_id,key_type,domain,domains,cert_url,cert_stable_url,certificate,issuer_certificate,private_key,csr," +
+	"not_before,not_after,serial_number,issuer,fingerprint,renew_at,renew_window_start,renew_window_end,renew_attempt_at,renew_attempts," +
+	"revoked_at,revocation_reason,ocsp_status,ocsp_response,ocsp_this_update,ocsp_next_update,ocsp_checked_at"
+
+// 列表、到期检查查询的字段，不包含私钥，避免每次查询都解密全部私钥
+const certificateListColumns = "id,status,source,domain_config_id,acme_user_id,key_type,domain,domains,cert_url,cert_stable_url,certificate,issuer_certificate,csr," +
+	"not_before,not_after,serial_number,issuer,fingerprint,renew_at,renew_window_start,renew_window_end,renew_attempt_at,renew_attempts," +
+	"revoked_at,revocation_reason,ocsp_status,ocsp_response,ocsp_this_update,ocsp_next_update,ocsp_checked_at,created"
+
 type Certificate struct {
 	Id int `json:"id" xorm:"pk autoincr notnull "`
+	// 申请证书使用的域名配置
+	DomainConfigId int `xorm:"int notnull index default 0" json:"domain_config_id"`
+	// 申请证书使用的ACME账号
+	AcmeUserId int `xorm:"int notnull default 0" json:"acme_user_id"`
+	// 私钥类型，同一个域名配置可以同时有RSA和ECDSA两个证书
+	KeyType string `xorm:"varchar(16) notnull default ''" json:"key_type"`
 	// xxx.net.json
-	Domain        string `xorm:"varchar(128)  not null" json:"domain"`
+	Domain string `xorm:"varchar(128)  not null" json:"domain"`
+	// 证书包含的全部域名(SAN)，以空格隔开，续期时使用相同的域名
+	Domains       string `xorm:"text notnull" json:"domains"`
 	CertUrl       string `xorm:"varchar(256) " json:"cert_url"`
 	CertStableUrl string `xorm:"varchar(256) " json:"cert_stable_url"`
 	// xxx.net.crt
-	Certificate string `xorm:"varchar(5120) " json:"certificate"`
+	Certificate string `xorm:"text" json:"certificate"`
 	// xxx.net.issuer.crt
-	IssuerCertificate string `xorm:"varchar(5120) " json:"issuer_certificate"`
+	IssuerCertificate string `xorm:"text" json:"issuer_certificate"`
 	// xxx.net.key
-	PrivateKey string `xorm:"varchar(5120) " json:"private_key"`
+	// 加密保存
+	PrivateKey Secret `xorm:"text" json:"private_key"`
+	// 使用CSR申请的证书保存CSR，续期时使用同一个CSR，这类证书没有私钥
+	Csr string `xorm:"text" json:"csr"`
+
+	// 以下字段保存证书时从证书内容解析
+	NotBefore    time.Time `xorm:"datetime" json:"not_before"`
+	NotAfter     time.Time `xorm:"datetime index" json:"not_after"`
+	SerialNumber string    `xorm:"varchar(64) notnull default ''" json:"serial_number"`
+	Issuer       string    `xorm:"varchar(512) notnull default ''" json:"issuer"`
+	// 证书DER编码的SHA-256指纹
+	Fingerprint string `xorm:"varchar(64) notnull default ''" json:"fingerprint"`
+
+	// 计划续期时间，CA支持ARI时在CA建议的时间窗口内随机选择
+	RenewAt time.Time `xorm:"datetime" json:"renew_at"`
+	// CA通过ARI建议的续期时间窗口
+	RenewWindowStart time.Time `xorm:"datetime" json:"renew_window_start"`
+	RenewWindowEnd   time.Time `xorm:"datetime" json:"renew_window_end"`
+	// 最后一次运行续期任务的时间、到期后已运行续期任务的次数，保存新证书时清零
+	RenewAttemptAt time.Time `xorm:"datetime" json:"renew_attempt_at"`
+	RenewAttempts  int       `xorm:"int notnull default 0" json:"renew_attempts"`
+
+	// 状态：active、superseded、revoked、expired，只有active的证书可以部署
+	Status string `xorm:"varchar(16) notnull default 'active' index" json:"status"`
+	// 来源：acme、external，external的证书到续期时间时只发送告警
+	Source string `xorm:"varchar(16) notnull default 'acme'" json:"source"`
+	// 注销时间、注销原因(RFC 5280 CRLReason)
+	RevokedAt        time.Time `xorm:"datetime" json:"revoked_at"`
+	RevocationReason int       `xorm:"int notnull default 0" json:"revocation_reason"`
+
+	// OCSP状态：good、revoked、unknown，为空时未查询
+	OcspStatus string `xorm:"varchar(16) notnull default ''" json:"ocsp_status"`
+	// DER编码的OCSP响应，可用作nginx的ssl_stapling_file
+	OcspResponse   []byte    `xorm:"blob" json:"-"`
+	OcspThisUpdate time.Time `xorm:"datetime" json:"ocsp_this_update"`
+	OcspNextUpdate time.Time `xorm:"datetime" json:"ocsp_next_update"`
+	// 最后一次查询OCSP的时间
+	OcspCheckedAt time.Time `xorm:"datetime" json:"ocsp_checked_at"`
 
 	BaseModel `json:"-" xorm:"-"`
 
@@ -25,6 +103,15 @@ type Certificate struct {
 
 // 新增
 func (c *Certificate) Create() (insertId int, err error) {
+	if err = c.ParseMetadata(); err != nil {
+		return
+	}
+	if c.Status == "" {
+		c.Status = CertificateStatusActive
+	}
+	if c.Source == "" {
+		c.Source = CertificateSourceAcme
+	}
 	_, err = Db.Insert(c)
 	if err == nil {
 		insertId = c.Id
@@ -33,8 +120,134 @@ func (c *Certificate) Create() (insertId int, err error) {
 	return
 }
 
-func (c *Certificate) UpdateBean(id int16) (int64, error) {
-	return Db.ID(id).Cols("domain,cert_url,cert_stable_url,certificate,issuer_certificate,private_key").Update(c)
+func (c *Certificate) UpdateBean(id int) (int64, error) {
+	if err := c.ParseMetadata(); err != nil {
+		return 0, err
+	}
+	if c.Status == "" {
+		c.Status = CertificateStatusActive
+	}
+	if c.Source == "" {
+		c.Source = CertificateSourceAcme
+	}
+
+	return Db.ID(id).Cols(certificateColumns).Update(c)
+}
+
+// 更新续期时间
+func (c *Certificate) UpdateRenewal(id int) (int64, error) {
+	return Db.ID(id).Cols("renew_at,renew_window_start,renew_window_end").Update(c)
+}
+
+// 更新续期任务的运行记录
+func (c *Certificate) UpdateRenewAttempt(id int) (int64, error) {
+	return Db.ID(id).Cols("renew_attempt_at,renew_attempts").Update(c)
+}
+
+// 更新状态，同时更新当前版本的状态
+func (c *Certificate) UpdateStatus(id int) (int64, error) {
+	affected, err := Db.ID(id).Cols("status,revoked_at,revocation_reason").Update(c)
+	if err != nil {
+		return affected, err
+	}
+	c.Id = id
+
+	return affected, c.syncCurrentVersionStatus()
+}
+
+// 是否可以部署，已注销、已替换、已过期的证书不能部署
+func (c *Certificate) IsDeployable() bool {
+	if c.Status != "" && c.Status != CertificateStatusActive {
+		return false
+	}
+
+	return c.NotAfter.IsZero() || time.Now().Before(c.NotAfter)
+}
+
+func (c *Certificate) IsRevoked() bool {
+	return c.Status == CertificateStatusRevoked
+}
+
+// 是否为导入的证书
+func (c *Certificate) IsExternal() bool {
+	return c.Source == CertificateSourceExternal
+}
+
+// 是否保存了私钥，使用CSR申请的证书私钥由用户保管
+func (c *Certificate) HasPrivateKey() bool {
+	return c.PrivateKey != ""
+}
+
+// 更新OCSP状态
+func (c *Certificate) UpdateOcsp(id int) (int64, error) {
+	return Db.ID(id).Cols("ocsp_status,ocsp_response,ocsp_this_update,ocsp_next_update,ocsp_checked_at").Update(c)
+}
+
+// 是否有未过期的OCSP响应
+func (c *Certificate) HasValidOcspResponse() bool {
+	return len(c.OcspResponse) > 0 && time.Now().Before(c.OcspNextUpdate)
+}
+
+// 解析证书内容，填充有效期、序列号、颁发者、域名(SAN)、私钥类型、指纹；证书内容为空时不处理
+// 域名已保存时不覆盖
+func (c *Certificate) ParseMetadata() error {
+	if strings.TrimSpace(c.Certificate) == "" {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(c.Certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("证书内容不是有效的PEM格式")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("证书解析失败：%s", err)
+	}
+
+	c.NotBefore = cert.NotBefore
+	c.NotAfter = cert.NotAfter
+	c.SerialNumber = fmt.Sprintf("%x", cert.SerialNumber)
+	c.Issuer = cert.Issuer.String()
+	sum := sha256.Sum256(cert.Raw)
+	c.Fingerprint = hex.EncodeToString(sum[:])
+	if keyType := publicKeyType(cert.PublicKey); keyType != "" {
+		c.KeyType = keyType
+	}
+	domains := make([]string, 0, len(cert.DNSNames)+1)
+	if cert.Subject.CommonName != "" {
+		domains = append(domains, cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		if name != cert.Subject.CommonName {
+			domains = append(domains, name)
+		}
+	}
+	// 申请时保存的域名顺序与续期时一致，只在没有保存域名时使用证书中的域名
+	if strings.TrimSpace(c.Domains) == "" && len(domains) > 0 {
+		c.Domains = strings.Join(domains, " ")
+	}
+
+	return nil
+}
+
+// 证书剩余有效天数，未解析有效期时返回-1
+func (c *Certificate) DaysLeft() int {
+	if c.NotAfter.IsZero() {
+		return -1
+	}
+
+	return int(time.Until(c.NotAfter).Hours() / 24)
+}
+
+// 公钥类型，与DomainConfig的私钥类型一致，如：RSA2048、EC256
+func publicKeyType(publicKey interface{}) string {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("EC%d", key.Curve.Params().BitSize)
+	}
+
+	return ""
 }
 
 // 更新
@@ -44,6 +257,13 @@ func (c *Certificate) Update(id int, data CommonMap) (int64, error) {
 
 // 删除
 func (c *Certificate) Delete(id int) (int64, error) {
+	if _, err := Db.Where("certificate_id = ?", id).Delete(new(CertificateVersion)); err != nil {
+		return 0, err
+	}
+	if err := new(CertificateDeployTarget).RemoveByCertificate(id); err != nil {
+		return 0, err
+	}
+
 	return Db.Id(id).Delete(new(Certificate))
 }
 
@@ -53,6 +273,13 @@ func (c *Certificate) Find(id int) error {
 	return err
 }
 
+// 查询域名配置下指定私钥类型的证书
+func (c *Certificate) FindByDomainConfig(domainConfigId int, keyType string) error {
+	_, err := Db.Where("domain_config_id = ? AND key_type = ?", domainConfigId, keyType).Desc("id").Get(c)
+
+	return err
+}
+
 func (c *Certificate) DomainExists(domain string, id int16) (bool, error) {
 	if id == 0 {
 		count, err := Db.Where("domain = ?", domain).Count(c)
@@ -66,7 +293,7 @@ func (c *Certificate) DomainExists(domain string, id int16) (bool, error) {
 func (c *Certificate) List(params CommonMap) ([]Certificate, error) {
 	c.parsePageAndPageSize(params)
 	list := make([]Certificate, 0)
-	session := Db.Desc("id")
+	session := Db.Cols(certificateListColumns).Desc("id")
 	c.parseWhere(session, params)
 	err := session.Limit(c.PageSize, c.pageLimitOffset()).Find(&list)
 
@@ -75,7 +302,15 @@ func (c *Certificate) List(params CommonMap) ([]Certificate, error) {
 
 func (c *Certificate) AllList() ([]Certificate, error) {
 	list := make([]Certificate, 0)
-	err := Db.Cols("domain,cert_url,cert_stable_url,certificate,issuer_certificate,private_key").Desc("id").Find(&list)
+	err := Db.Cols(certificateListColumns).Desc("id").Find(&list)
+
+	return list, err
+}
+
+// 已签发的有效证书，用于到期检查，不包含私钥
+func (c *Certificate) IssuedList() ([]Certificate, error) {
+	list := make([]Certificate, 0)
+	err := Db.Cols(certificateListColumns).Where("certificate != '' AND status = ?", CertificateStatusActive).Asc("id").Find(&list)
 
 	return list, err
 }
@@ -99,4 +334,8 @@ func (c *Certificate) parseWhere(session *xorm.Session, params CommonMap) {
 	if ok && name.(string) != "" {
 		session.And("domain = ?", name)
 	}
+	status, ok := params["Status"]
+	if ok && status.(string) != "" {
+		session.And("status = ?", status)
+	}
 }
@@ -0,0 +1,350 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// 证书版本，每次签发保存一个不可修改的版本，同一个证书只有一个当前版本
+// Certificate保存当前版本的内容，部署、续期、到期检查都使用Certificate
+type CertificateVersion struct {
+	Id int `json:"id" xorm:"pk autoincr notnull "`
+	// 所属证书
+	CertificateId int `xorm:"int notnull index default 0" json:"certificate_id"`
+	// 申请证书使用的域名配置
+	DomainConfigId int `xorm:"int notnull index default 0" json:"domain_config_id"`
+	// 版本号，同一个证书从1开始递增
+	Version int `xorm:"int notnull default 0" json:"version"`
+	// 是否为当前版本
+	IsCurrent Bool `xorm:"tinyint notnull default 0 " json:"is_current"`
+
+	AcmeUserId        int    `xorm:"int notnull default 0" json:"acme_user_id"`
+	KeyType           string `xorm:"varchar(16) notnull default ''" json:"key_type"`
+	Domain            string `xorm:"varchar(128)  not null" json:"domain"`
+	Domains           string `xorm:"text notnull" json:"domains"`
+	CertUrl           string `xorm:"varchar(256) " json:"cert_url"`
+	CertStableUrl     string `xorm:"varchar(256) " json:"cert_stable_url"`
+	Certificate       string `xorm:"text" json:"certificate"`
+	IssuerCertificate string `xorm:"text" json:"issuer_certificate"`
+	PrivateKey        Secret `xorm:"text" json:"private_key"`
+	Csr               string `xorm:"text" json:"csr"`
+
+	NotBefore    time.Time `xorm:"datetime" json:"not_before"`
+	NotAfter     time.Time `xorm:"datetime" json:"not_after"`
+	SerialNumber string    `xorm:"varchar(64) notnull default ''" json:"serial_number"`
+	Issuer       string    `xorm:"varchar(512) notnull default ''" json:"issuer"`
+	Fingerprint  string    `xorm:"varchar(64) notnull default ''" json:"fingerprint"`
+
+	// 状态：active、superseded、revoked、expired
+	Status           string    `xorm:"varchar(16) notnull default 'active'" json:"status"`
+	RevokedAt        time.Time `xorm:"datetime" json:"revoked_at"`
+	RevocationReason int       `xorm:"int notnull default 0" json:"revocation_reason"`
+
+	BaseModel `json:"-" xorm:"-"`
+
+	Created time.Time `json:"created" xorm:"datetime notnull created"`
+}
+
+// 版本元数据差异
+type CertificateVersionDiff struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+func newCertificateVersion(c *Certificate) *CertificateVersion {
+	return &CertificateVersion{
+		CertificateId:     c.Id,
+		DomainConfigId:    c.DomainConfigId,
+		AcmeUserId:        c.AcmeUserId,
+		KeyType:           c.KeyType,
+		Domain:            c.Domain,
+		Domains:           c.Domains,
+		CertUrl:           c.CertUrl,
+		CertStableUrl:     c.CertStableUrl,
+		Certificate:       c.Certificate,
+		IssuerCertificate: c.IssuerCertificate,
+		PrivateKey:        c.PrivateKey,
+		Csr:               c.Csr,
+		NotBefore:         c.NotBefore,
+		NotAfter:          c.NotAfter,
+		SerialNumber:      c.SerialNumber,
+		Issuer:            c.Issuer,
+		Fingerprint:       c.Fingerprint,
+		Status:            c.Status,
+		RevokedAt:         c.RevokedAt,
+		RevocationReason:  c.RevocationReason,
+	}
+}
+
+// 版本内容复制到证书，续期时间、OCSP响应属于旧证书，需要重新计算、查询
+func (v *CertificateVersion) applyTo(c *Certificate) {
+	*c = Certificate{
+		Id:                c.Id,
+		DomainConfigId:    v.DomainConfigId,
+		AcmeUserId:        v.AcmeUserId,
+		KeyType:           v.KeyType,
+		Domain:            v.Domain,
+		Domains:           v.Domains,
+		CertUrl:           v.CertUrl,
+		CertStableUrl:     v.CertStableUrl,
+		Certificate:       v.Certificate,
+		IssuerCertificate: v.IssuerCertificate,
+		PrivateKey:        v.PrivateKey,
+		Csr:               v.Csr,
+		NotBefore:         v.NotBefore,
+		NotAfter:          v.NotAfter,
+		SerialNumber:      v.SerialNumber,
+		Issuer:            v.Issuer,
+		Fingerprint:       v.Fingerprint,
+		Status:            CertificateStatusActive,
+		Source:            c.Source,
+		Created:           c.Created,
+	}
+}
+
+// 保存证书并新增一个当前版本，id大于0时更新已有的证书，之前的版本标记为superseded
+// 升级前保存的证书没有版本，更新前先把原内容保存为第一个版本
+func (c *Certificate) SaveVersion(id int) (err error) {
+	if err = c.ParseMetadata(); err != nil {
+		return
+	}
+	c.Status = CertificateStatusActive
+	if c.Source == "" {
+		c.Source = CertificateSourceAcme
+	}
+	c.RevokedAt = time.Time{}
+	c.RevocationReason = 0
+
+	session := Db.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = session.Rollback()
+		}
+	}()
+
+	if id > 0 {
+		old := new(Certificate)
+		if _, err = session.ID(id).Get(old); err != nil {
+			return
+		}
+		if old.Id == 0 {
+			return fmt.Errorf("证书不存在#ID-%d", id)
+		}
+		if err = backfillCertificateVersion(session, old); err != nil {
+			return
+		}
+		c.Id = id
+		if _, err = session.ID(id).Cols(certificateColumns).Update(c); err != nil {
+			return
+		}
+	} else if _, err = session.Insert(c); err != nil {
+		return
+	}
+
+	if err = supersedeCertificateVersions(session, c.Id); err != nil {
+		return
+	}
+	version := newCertificateVersion(c)
+	if version.Version, err = nextCertificateVersion(session, c.Id); err != nil {
+		return
+	}
+	version.IsCurrent = True
+	if _, err = session.Insert(version); err != nil {
+		return
+	}
+
+	return session.Commit()
+}
+
+// 回滚到指定版本，版本内容复制到证书并标记为当前版本，已注销、已过期的版本不能回滚
+func (c *Certificate) RollbackVersion(versionId int) (err error) {
+	version := new(CertificateVersion)
+	if err = version.Find(versionId); err != nil {
+		return
+	}
+	if version.Id == 0 || version.CertificateId != c.Id {
+		return fmt.Errorf("证书版本不存在#证书ID-%d#版本ID-%d", c.Id, versionId)
+	}
+	if version.IsCurrent == True {
+		return errors.New("已经是当前版本")
+	}
+	if version.Status == CertificateStatusRevoked {
+		return errors.New("证书版本已注销，不能回滚")
+	}
+	if !version.NotAfter.IsZero() && time.Now().After(version.NotAfter) {
+		return errors.New("证书版本已过期，不能回滚")
+	}
+
+	session := Db.NewSession()
+	defer session.Close()
+	if err = session.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = session.Rollback()
+		}
+	}()
+
+	if err = supersedeCertificateVersions(session, c.Id); err != nil {
+		return
+	}
+	version.IsCurrent = True
+	version.Status = CertificateStatusActive
+	if _, err = session.ID(version.Id).Cols("is_current,status").Update(version); err != nil {
+		return
+	}
+	version.applyTo(c)
+	if _, err = session.ID(c.Id).Cols(certificateColumns).Update(c); err != nil {
+		return
+	}
+
+	return session.Commit()
+}
+
+// 证书状态变化时同步到当前版本
+func (c *Certificate) syncCurrentVersionStatus() error {
+	version := &CertificateVersion{Status: c.Status, RevokedAt: c.RevokedAt, RevocationReason: c.RevocationReason}
+	_, err := Db.Where("certificate_id = ? AND is_current = ?", c.Id, True).
+		Cols("status,revoked_at,revocation_reason").Update(version)
+
+	return err
+}
+
+// 之前的当前版本标记为superseded，已注销、已过期的版本保留原状态
+func supersedeCertificateVersions(session *xorm.Session, certificateId int) error {
+	_, err := session.Table(new(CertificateVersion)).
+		Where("certificate_id = ? AND is_current = ?", certificateId, True).
+		Update(CommonMap{"is_current": False})
+	if err != nil {
+		return err
+	}
+	_, err = session.Table(new(CertificateVersion)).
+		Where("certificate_id = ? AND status = ?", certificateId, CertificateStatusActive).
+		Update(CommonMap{"status": CertificateStatusSuperseded})
+
+	return err
+}
+
+func nextCertificateVersion(session *xorm.Session, certificateId int) (int, error) {
+	version := new(CertificateVersion)
+	has, err := session.Where("certificate_id = ?", certificateId).Desc("version").Get(version)
+	if err != nil || !has {
+		return 1, err
+	}
+
+	return version.Version + 1, nil
+}
+
+func backfillCertificateVersion(session *xorm.Session, c *Certificate) error {
+	if c.Certificate == "" {
+		return nil
+	}
+	count, err := session.Where("certificate_id = ?", c.Id).Count(new(CertificateVersion))
+	if err != nil || count > 0 {
+		return err
+	}
+	version := newCertificateVersion(c)
+	version.Version = 1
+	version.IsCurrent = True
+	if version.Status == "" {
+		version.Status = CertificateStatusActive
+	}
+	_, err = session.Insert(version)
+
+	return err
+}
+
+func (v *CertificateVersion) Find(id int) error {
+	_, err := Db.Id(id).Get(v)
+
+	return err
+}
+
+// 指纹为fingerprint的版本之前最近的一个未注销的版本，用于部署回滚
+func (v *CertificateVersion) FindPrevious(certificateId int, fingerprint string) error {
+	current := new(CertificateVersion)
+	if _, err := Db.Where("certificate_id = ? AND fingerprint = ?", certificateId, fingerprint).Desc("version").Get(current); err != nil {
+		return err
+	}
+	session := Db.Where("certificate_id = ? AND fingerprint != ? AND status != ?", certificateId, fingerprint, CertificateStatusRevoked)
+	if current.Id > 0 {
+		session.And("version < ?", current.Version)
+	}
+	_, err := session.Desc("version").Get(v)
+
+	return err
+}
+
+// 指纹为fingerprint的最新版本，用于恢复部署前使用的证书
+func (v *CertificateVersion) FindByFingerprint(fingerprint string) error {
+	_, err := Db.Where("fingerprint = ?", fingerprint).Desc("id").Get(v)
+
+	return err
+}
+
+// 证书的全部版本，不包含证书内容和私钥
+func (v *CertificateVersion) ListByCertificate(certificateId int) ([]CertificateVersion, error) {
+	list := make([]CertificateVersion, 0)
+	err := Db.Where("certificate_id = ?", certificateId).
+		Omit("certificate", "issuer_certificate", "private_key", "csr").Desc("version").Find(&list)
+
+	return list, err
+}
+
+// 与另一个版本的元数据差异
+func (v *CertificateVersion) Diff(other *CertificateVersion) []CertificateVersionDiff {
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"version", fmt.Sprint(v.Version), fmt.Sprint(other.Version)},
+		{"key_type", v.KeyType, other.KeyType},
+		{"domains", v.Domains, other.Domains},
+		{"issuer", v.Issuer, other.Issuer},
+		{"serial_number", v.SerialNumber, other.SerialNumber},
+		{"fingerprint", v.Fingerprint, other.Fingerprint},
+		{"not_before", formatVersionTime(v.NotBefore), formatVersionTime(other.NotBefore)},
+		{"not_after", formatVersionTime(v.NotAfter), formatVersionTime(other.NotAfter)},
+		{"status", v.Status, other.Status},
+		{"issuer_fingerprint", pemFingerprint(v.IssuerCertificate), pemFingerprint(other.IssuerCertificate)},
+	}
+	diffs := make([]CertificateVersionDiff, 0)
+	for _, field := range fields {
+		if field.from != field.to {
+			diffs = append(diffs, CertificateVersionDiff{Field: field.name, From: field.from, To: field.to})
+		}
+	}
+
+	return diffs
+}
+
+// PEM中第一个证书的SHA-256指纹，用于比较证书链是否变化
+func pemFingerprint(data string) string {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func formatVersionTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(DefaultTimeFormat)
+}
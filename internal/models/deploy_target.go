@@ -0,0 +1,205 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// 部署结果
+const (
+	DeployStatusSuccess    = "success"     // 部署成功
+	DeployStatusFailed     = "failed"      // 部署失败
+	DeployStatusRolledBack = "rolled_back" // 验证失败，已回滚
+)
+
+// 证书部署目标，如：阿里云SLB、gocron-node主机，不同类型的配置以json格式保存在config字段
+// 需要云服务商凭证的类型使用access_key_id关联AccessKey，config中不保存secret
+type DeployTarget struct {
+	Id   int    `json:"id" xorm:"pk autoincr notnull "`
+	Name string `xorm:"varchar(64) notnull" json:"name"`
+	// 部署类型，如：aliyun_slb
+	Type string `xorm:"varchar(32) notnull index" json:"type"`
+	// 部署类型的配置，json格式
+	Config      string `xorm:"text" json:"config"`
+	AccessKeyId int    `xorm:"int notnull default 0" json:"access_key_id"`
+	Status      Status `xorm:"tinyint notnull default 1 " json:"status"` // 状态 1:启用 0:禁用
+	Remark      string `xorm:"varchar(100) notnull default '' " json:"remark"`
+
+	BaseModel `json:"-" xorm:"-"`
+
+	Created time.Time `json:"created" xorm:"datetime notnull created"`
+}
+
+// 证书与部署目标的关联，多对多；记录最近一次部署的结果和回滚需要的数据
+type CertificateDeployTarget struct {
+	Id             int `json:"id" xorm:"int pk autoincr"`
+	CertificateId  int `json:"certificate_id" xorm:"int notnull index"`
+	DeployTargetId int `json:"deploy_target_id" xorm:"int notnull index"`
+
+	// 最近一次部署的证书指纹、状态、结果
+	Fingerprint string    `json:"fingerprint" xorm:"varchar(64) notnull default ''"`
+	Status      string    `json:"status" xorm:"varchar(16) notnull default ''"`
+	Result      string    `json:"result" xorm:"text"`
+	DeployedAt  time.Time `json:"deployed_at" xorm:"datetime"`
+	// 部署前的状态，由部署类型定义，回滚时使用
+	RollbackState string `json:"-" xorm:"text"`
+}
+
+// 新增
+func (t *DeployTarget) Create() (insertId int, err error) {
+	_, err = Db.Insert(t)
+	if err == nil {
+		insertId = t.Id
+	}
+
+	return
+}
+
+func (t *DeployTarget) UpdateBean(id int) (int64, error) {
+	return Db.ID(id).Cols("name,type,config,access_key_id,status,remark").Update(t)
+}
+
+// 删除部署目标及与证书的关联
+func (t *DeployTarget) Delete(id int) (int64, error) {
+	_, err := Db.Where("deploy_target_id = ?", id).Delete(new(CertificateDeployTarget))
+	if err != nil {
+		return 0, err
+	}
+
+	return Db.Id(id).Delete(new(DeployTarget))
+}
+
+func (t *DeployTarget) Find(id int) error {
+	_, err := Db.Id(id).Get(t)
+
+	return err
+}
+
+func (t *DeployTarget) IsEnabled() bool {
+	return t.Status == Enabled
+}
+
+// 解析配置
+func (t *DeployTarget) DecodeConfig(v interface{}) error {
+	if strings.TrimSpace(t.Config) == "" {
+		return errors.New("部署配置不能为空")
+	}
+
+	return json.Unmarshal([]byte(t.Config), v)
+}
+
+func (t *DeployTarget) NameExists(name string, id int) (bool, error) {
+	if id == 0 {
+		count, err := Db.Where("name = ?", name).Count(t)
+		return count > 0, err
+	}
+
+	count, err := Db.Where("name = ? AND id != ?", name, id).Count(t)
+	return count > 0, err
+}
+
+func (t *DeployTarget) List(params CommonMap) ([]DeployTarget, error) {
+	t.parsePageAndPageSize(params)
+	list := make([]DeployTarget, 0)
+	session := Db.Desc("id")
+	t.parseWhere(session, params)
+	err := session.Limit(t.PageSize, t.pageLimitOffset()).Find(&list)
+
+	return list, err
+}
+
+func (t *DeployTarget) Total(params CommonMap) (int64, error) {
+	session := Db.NewSession()
+	t.parseWhere(session, params)
+	return session.Count(t)
+}
+
+// 证书关联的部署目标
+func (t *DeployTarget) ListByCertificate(certificateId int) ([]DeployTarget, error) {
+	list := make([]DeployTarget, 0)
+	err := Db.Where("id IN (SELECT deploy_target_id FROM "+TablePrefix+"certificate_deploy_target WHERE certificate_id = ?)", certificateId).
+		Asc("id").Find(&list)
+
+	return list, err
+}
+
+// 解析where
+func (t *DeployTarget) parseWhere(session *xorm.Session, params CommonMap) {
+	if len(params) == 0 {
+		return
+	}
+	id, ok := params["Id"]
+	if ok && id.(int) > 0 {
+		session.And("id = ?", id)
+	}
+	targetType, ok := params["Type"]
+	if ok && targetType.(string) != "" {
+		session.And("type = ?", targetType)
+	}
+	name, ok := params["Name"]
+	if ok && name.(string) != "" {
+		session.And("name LIKE ?", "%"+name.(string)+"%")
+	}
+}
+
+// 设置证书关联的部署目标，保留已有关联的部署记录
+func (ct *CertificateDeployTarget) Bind(certificateId int, targetIds []int) error {
+	list, err := ct.ListByCertificate(certificateId)
+	if err != nil {
+		return err
+	}
+	existing := make(map[int]bool, len(list))
+	for _, item := range list {
+		existing[item.DeployTargetId] = true
+	}
+	keep := make(map[int]bool, len(targetIds))
+	for _, targetId := range targetIds {
+		keep[targetId] = true
+		if existing[targetId] {
+			continue
+		}
+		existing[targetId] = true
+		if _, err = Db.Insert(&CertificateDeployTarget{CertificateId: certificateId, DeployTargetId: targetId}); err != nil {
+			return err
+		}
+	}
+	for _, item := range list {
+		if keep[item.DeployTargetId] {
+			continue
+		}
+		if _, err = Db.Id(item.Id).Delete(new(CertificateDeployTarget)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ct *CertificateDeployTarget) ListByCertificate(certificateId int) ([]CertificateDeployTarget, error) {
+	list := make([]CertificateDeployTarget, 0)
+	err := Db.Where("certificate_id = ?", certificateId).Asc("id").Find(&list)
+
+	return list, err
+}
+
+func (ct *CertificateDeployTarget) Find(certificateId, targetId int) error {
+	_, err := Db.Where("certificate_id = ? AND deploy_target_id = ?", certificateId, targetId).Get(ct)
+
+	return err
+}
+
+// 更新部署结果
+func (ct *CertificateDeployTarget) UpdateResult(id int) (int64, error) {
+	return Db.ID(id).Cols("fingerprint,status,result,deployed_at,rollback_state").Update(ct)
+}
+
+// 删除证书的全部关联
+func (ct *CertificateDeployTarget) RemoveByCertificate(certificateId int) error {
+	_, err := Db.Where("certificate_id = ?", certificateId).Delete(new(CertificateDeployTarget))
+
+	return err
+}
@@ -1,8 +1,11 @@
 package models
 
 import (
-	"github.com/go-xorm/xorm"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/go-xorm/xorm"
 )
 
 type Bool int8
@@ -12,24 +15,77 @@ const (
 	True  Bool = iota + 1 // true
 )
 
+// 域名验证方式
+const (
+	ChallengeDNS01     = "dns-01"
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// 证书私钥类型
+const (
+	KeyTypeRSA2048 = "RSA2048"
+	KeyTypeRSA3072 = "RSA3072"
+	KeyTypeRSA4096 = "RSA4096"
+	KeyTypeEC256   = "EC256"
+	KeyTypeEC384   = "EC384"
+)
+
 // Domains Struct
 type DomainConfig struct {
 	Id int `json:"id" xorm:"pk autoincr notnull "`
 
 	// 域名，支持多个，以空格隔开；如：  *.a.com  *.c.com bb.cn
-	Domain string `xorm:"varchar(100) not null" json:"domain"`
+	Domain string `xorm:"text not null" json:"domain"`
+
+	// 域名验证方式：dns-01、http-01、tls-alpn-01，为空时使用dns-01
+	ChallengeType string `xorm:"varchar(16) notnull default 'dns-01'" json:"challenge_type"`
 
-	// Provider 名称，目前只支持dns-01：如：alidns
+	// Provider 名称，dns-01使用：如：alidns
 	ProviderName string `xorm:"varchar(32)  not null" json:"provider_name"`
 
+	// dns-01等待记录生效的超时时间，单位秒，0使用服务商默认值
+	DnsPropagationTimeout int `xorm:"int notnull default 0" json:"dns_propagation_timeout"`
+
+	// dns-01检查记录是否生效的间隔，单位秒，0使用服务商默认值
+	DnsPollingInterval int `xorm:"int notnull default 0" json:"dns_polling_interval"`
+
+	// dns-01验证记录的TTL，单位秒，0使用服务商默认值
+	DnsTtl int `xorm:"int notnull default 0" json:"dns_ttl"`
+
+	// dns-01检查记录使用的递归DNS服务器，多个以逗号隔开，如：8.8.8.8:53,1.1.1.1；为空时使用系统配置
+	DnsResolvers string `xorm:"varchar(256) notnull default ''" json:"dns_resolvers"`
+
+	// dns-01只要求一个权威DNS服务器上记录生效，不检查全部权威DNS服务器
+	DnsSkipFullPropagation Bool `xorm:"tinyint notnull default 0 " json:"dns_skip_full_propagation"`
+
+	// http-01、tls-alpn-01提供验证内容的gocron-node主机ID，多个ID逗号分隔
+	HostIds string `xorm:"varchar(256) notnull default ''" json:"host_ids"`
+
+	// tls-alpn-01未选择主机时，调度器内置tls服务的监听地址，如：0.0.0.0:5001，443端口需要转发到此地址
+	ChallengeAddr string `xorm:"varchar(64) notnull default ''" json:"challenge_addr"`
+
+	// http-01验证文件写入的网站根目录，为空时由gocron-node内置的http服务提供验证内容
+	Webroot string `xorm:"varchar(256) notnull default ''" json:"webroot"`
+
 	// 默认的续期时间。 如 小于多少天就续期
 	DefaultRenewDay int `xorm:"default 90" json:"default_renew_day"`
 
+	// 私钥类型，RSA2048、RSA3072、RSA4096、EC256、EC384
+	KeyType string `xorm:"varchar(16) notnull default 'RSA2048'" json:"key_type"`
+
+	// 同时申请RSA和ECDSA两个证书
+	DualKey Bool `xorm:"tinyint notnull default 0 " json:"dual_key"`
+
 	// 实现重用现有的私钥
 	ReuseKey   Bool `xorm:"tinyint notnull default 0 " json:"reuse_key"`
 	Bundle     Bool `xorm:"tinyint notnull default 0 " json:"bundle"`
 	MustStaple Bool `xorm:"tinyint notnull default 0 " json:"must_staple"`
 
+	// PEM格式的CSR，设置后使用CSR申请证书，私钥由用户保管，不生成、不保存私钥
+	// CSR中的域名必须包含在Domain中，私钥类型、双证书、重用私钥、MustStaple配置不生效
+	Csr string `xorm:"text" json:"csr"`
+
 	BaseModel `json:"-" xorm:"-"`
 
 	Created time.Time `json:"created" xorm:"datetime notnull created"`
@@ -57,8 +113,80 @@ func (d *DomainConfig) HasMustStaple() bool {
 	return d.MustStaple == True
 }
 
+// 是否使用CSR申请证书
+func (d *DomainConfig) HasCsr() bool {
+	return strings.TrimSpace(d.Csr) != ""
+}
+
+// 续期天数，未设置时为30天
+func (d *DomainConfig) GetRenewDay() int {
+	if d.DefaultRenewDay == 0 {
+		return 30
+	}
+
+	return d.DefaultRenewDay
+}
+
+// 域名验证方式，默认dns-01
+func (d *DomainConfig) GetChallengeType() string {
+	if d.ChallengeType == "" {
+		return ChallengeDNS01
+	}
+
+	return d.ChallengeType
+}
+
+// dns-01使用的递归DNS服务器
+func (d *DomainConfig) DnsResolverList() []string {
+	resolvers := make([]string, 0)
+	for _, v := range strings.Split(d.DnsResolvers, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			resolvers = append(resolvers, v)
+		}
+	}
+
+	return resolvers
+}
+
+func (d *DomainConfig) HasDnsSkipFullPropagation() bool {
+	return d.DnsSkipFullPropagation == True
+}
+
+// http-01、tls-alpn-01提供验证内容的主机ID
+func (d *DomainConfig) HostIdList() []int {
+	ids := make([]int, 0)
+	for _, v := range strings.Split(d.HostIds, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (d *DomainConfig) HasDualKey() bool {
+	return d.DualKey == True
+}
+
+// 需要申请的证书私钥类型，第一个为主证书；同时申请RSA和ECDSA时，另一种算法使用默认长度
+func (d *DomainConfig) KeyTypes() []string {
+	keyType := d.KeyType
+	if keyType == "" {
+		keyType = KeyTypeRSA2048
+	}
+	if !d.HasDualKey() {
+		return []string{keyType}
+	}
+	if keyType == KeyTypeEC256 || keyType == KeyTypeEC384 {
+		return []string{keyType, KeyTypeRSA2048}
+	}
+
+	return []string{keyType, KeyTypeEC256}
+}
+
 func (d *DomainConfig) UpdateBean(id int16) (int64, error) {
-	return Db.ID(id).Cols("domain,provider_name,default_renew_day,reuse_key,bundle,must_staple").Update(d)
+	return Db.ID(id).Cols("domain,challenge_type,provider_name,dns_propagation_timeout,dns_polling_interval,dns_ttl,dns_resolvers,dns_skip_full_propagation,host_ids,challenge_addr,webroot,default_renew_day,key_type,dual_key,reuse_key,bundle,must_staple,csr").Update(d)
 }
 
 // 更新
@@ -99,7 +227,7 @@ func (d *DomainConfig) List(params CommonMap) ([]DomainConfig, error) {
 
 func (d *DomainConfig) AllList() ([]DomainConfig, error) {
 	list := make([]DomainConfig, 0)
-	err := Db.Cols("domain,provider_name,default_renew_day,reuse_key,bundle,must_staple").Desc("id").Find(&list)
+	err := Db.Cols("domain,challenge_type,provider_name,dns_propagation_timeout,dns_polling_interval,dns_ttl,dns_resolvers,dns_skip_full_propagation,host_ids,challenge_addr,webroot,default_renew_day,key_type,dual_key,reuse_key,bundle,must_staple,csr").Desc("id").Find(&list)
 
 	return list, err
 }
@@ -72,6 +72,21 @@ func (host *Host) AllList() ([]Host, error) {
 	return list, err
 }
 
+// 根据ID获取主机列表
+func (host *Host) ListByIds(ids []int) ([]Host, error) {
+	list := make([]Host, 0)
+	if len(ids) == 0 {
+		return list, nil
+	}
+	hostIds := make([]interface{}, len(ids))
+	for i, id := range ids {
+		hostIds[i] = id
+	}
+	err := Db.In("id", hostIds...).Find(&list)
+
+	return list, err
+}
+
 func (host *Host) Total(params CommonMap) (int64, error) {
 	session := Db.NewSession()
 	host.parseWhere(session, params)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/go-xorm/core"
 	"github.com/go-xorm/xorm"
 	"github.com/ouqiang/gocron/internal/modules/logger"
 )
@@ -18,6 +19,7 @@ func (migration *Migration) Install(dbName string) error {
 	tables := []interface{}{
 		&User{}, task, &TaskLog{}, &Host{}, setting, &LoginLog{}, &TaskHost{},
 	}
+	tables = append(tables, certificateTables()...)
 	for _, table := range tables {
 		exist, err := Db.IsTableExist(table)
 		if exist {
@@ -43,13 +45,14 @@ func (migration *Migration) Upgrade(oldVersionId int) {
 		return
 	}
 
-	versionIds := []int{110, 122, 130, 140, 150}
+	versionIds := []int{110, 122, 130, 140, 150, 160}
 	upgradeFuncs := []func(*xorm.Session) error{
 		migration.upgradeFor110,
 		migration.upgradeFor122,
 		migration.upgradeFor130,
 		migration.upgradeFor140,
 		migration.upgradeFor150,
+		migration.upgradeFor160,
 	}
 
 	startIndex := -1
@@ -235,3 +238,71 @@ func (m *Migration) upgradeFor150(session *xorm.Session) error {
 
 	return nil
 }
+
+// 证书管理相关的数据表
+func certificateTables() []interface{} {
+	return []interface{}{
+		&AcmeUser{}, &AccessKey{}, &AliyunSLB{}, &Certificate{}, &CertificateVersion{}, &DomainConfig{},
+		&DeployTarget{}, &CertificateDeployTarget{},
+	}
+}
+
+// 升级到v1.6版本
+func (m *Migration) upgradeFor160(session *xorm.Session) error {
+	logger.Info("开始升级到v1.6")
+
+	// 账号改为同一CA下邮件地址唯一，删除原来email字段的唯一索引
+	acmeUserExist, err := session.IsTableExist(new(AcmeUser))
+	if err != nil {
+		return err
+	}
+	if acmeUserExist {
+		err = dropEmailUniqueIndex(session, TablePrefix+"acme_user")
+		if err != nil {
+			return err
+		}
+	}
+
+	// 创建证书管理相关的表, 表已存在时同步新增字段
+	// 证书内容包含完整证书链、域名可能很多，certificate、issuer_certificate、domains字段改为text类型
+	// 域名配置支持多个域名和通配符域名，domain_config的domain字段改为text类型
+	err = session.Sync2(certificateTables()...)
+	if err != nil {
+		return err
+	}
+	if acmeUserExist {
+		// 原有账号都是在letsencrypt测试环境注册的，ca_dir_url为空时会使用正式环境
+		_, err = session.Exec(fmt.Sprintf("UPDATE %s SET ca_dir_url = 'letsencrypt-staging' WHERE ca_dir_url = ''", TablePrefix+"acme_user"))
+		if err != nil {
+			return err
+		}
+	}
+	// 证书任务的参数保存在command字段，改为text类型
+	err = session.Sync2(new(Task), new(TaskLog))
+	if err != nil {
+		return err
+	}
+
+	logger.Info("已升级到v1.6\n")
+
+	return nil
+}
+
+// 删除只包含email字段的唯一索引
+func dropEmailUniqueIndex(session *xorm.Session, tableName string) error {
+	indexes, err := Db.Dialect().GetIndexes(tableName)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Type != core.UniqueType || len(index.Cols) != 1 || index.Cols[0] != "email" {
+			continue
+		}
+		_, err = session.Exec(Db.Dialect().DropIndexSql(tableName, index))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
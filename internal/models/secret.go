@@ -0,0 +1,318 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ouqiang/gocron/internal/modules/app"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+)
+
+// 加密后的格式：enc:v1:<主密钥ID>:<base64(加密后的数据密钥)>:<base64(加密后的内容)>
+// 每个值使用随机生成的数据密钥(AES-256-GCM)加密，数据密钥使用主密钥(AES-256-GCM)加密
+const (
+	secretPrefix  = "enc:v1:"
+	secretKeySize = 32
+	// 未配置主密钥时使用的密钥文件，首次使用时自动生成
+	defaultSecretKeyFile = "secret.key"
+)
+
+// 敏感数据：私钥、云服务商的secret等，保存到数据库时加密，读取时解密
+// 不会序列化到JSON，需要明文时使用string(secret)，只能在管理员导出时使用
+type Secret string
+
+// 主密钥，key为主密钥ID
+type secretKeyring struct {
+	keys      map[string][]byte
+	currentId string
+}
+
+var (
+	secretKeys     *secretKeyring
+	secretKeysLock sync.Mutex
+)
+
+// 保存到数据库时加密，实现xorm的core.Conversion
+func (s Secret) ToDB() ([]byte, error) {
+	if s == "" {
+		return []byte{}, nil
+	}
+	keyring, err := loadSecretKeyring()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := keyring.encrypt([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(encrypted), nil
+}
+
+// 从数据库读取时解密，升级前保存的明文原样返回
+func (s *Secret) FromDB(data []byte) error {
+	value := string(data)
+	if !strings.HasPrefix(value, secretPrefix) {
+		*s = Secret(value)
+		return nil
+	}
+	keyring, err := loadSecretKeyring()
+	if err != nil {
+		return err
+	}
+	plaintext, err := keyring.decrypt(value)
+	if err != nil {
+		return err
+	}
+	*s = Secret(plaintext)
+
+	return nil
+}
+
+// JSON中不返回内容
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`""`), nil
+}
+
+// 日志中不输出内容
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return "******"
+}
+
+func (k *secretKeyring) encrypt(plaintext []byte) (string, error) {
+	dataKey := make([]byte, secretKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	encryptedKey, err := sealAESGCM(k.keys[k.currentId], dataKey, []byte(k.currentId))
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := sealAESGCM(dataKey, plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return secretPrefix + k.currentId + ":" +
+		base64.StdEncoding.EncodeToString(encryptedKey) + ":" +
+		base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+func (k *secretKeyring) decrypt(value string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return nil, errors.New("加密数据格式错误")
+	}
+	masterKey, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("主密钥不存在#ID-%s", parts[0])
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openAESGCM(masterKey, encryptedKey, []byte(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("数据密钥解密失败#主密钥ID-%s#%s", parts[0], err)
+	}
+
+	return openAESGCM(dataKey, encrypted, nil)
+}
+
+// 加密结果为nonce+密文
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key, data, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("加密数据长度错误")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// 加载主密钥，优先使用app.ini中的secret.keys，其次是secret.key_file指定的密钥文件
+// 都未配置时使用conf/secret.key，文件不存在时自动生成
+// 密钥格式：<ID>:<base64编码的32字节密钥>，多个密钥以逗号或换行隔开，secret.key_id指定加密使用的密钥，为空时使用最后一个
+// 轮换密钥：追加新密钥并修改secret.key_id，重启后调用RotateSecrets使用新密钥重新加密，旧密钥在重新加密完成前不能删除
+func loadSecretKeyring() (*secretKeyring, error) {
+	secretKeysLock.Lock()
+	defer secretKeysLock.Unlock()
+	if secretKeys != nil {
+		return secretKeys, nil
+	}
+
+	var config, currentId string
+	if app.Setting != nil {
+		config = app.Setting.SecretKeys
+		currentId = app.Setting.SecretKeyId
+	}
+	if config == "" {
+		data, err := readSecretKeyFile()
+		if err != nil {
+			return nil, err
+		}
+		config = string(data)
+	}
+	keyring, err := parseSecretKeyring(config, currentId)
+	if err != nil {
+		return nil, err
+	}
+	secretKeys = keyring
+
+	return secretKeys, nil
+}
+
+func parseSecretKeyring(config, currentId string) (*secretKeyring, error) {
+	keyring := &secretKeyring{keys: make(map[string][]byte)}
+	fields := strings.FieldsFunc(config, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("主密钥格式错误，应为<ID>:<base64编码的密钥>")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil || len(key) != secretKeySize {
+			return nil, fmt.Errorf("主密钥必须是base64编码的%d字节密钥#ID-%s", secretKeySize, parts[0])
+		}
+		keyring.keys[parts[0]] = key
+		keyring.currentId = parts[0]
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("未配置主密钥")
+	}
+	if currentId != "" {
+		if _, ok := keyring.keys[currentId]; !ok {
+			return nil, fmt.Errorf("secret.key_id指定的主密钥不存在#ID-%s", currentId)
+		}
+		keyring.currentId = currentId
+	}
+
+	return keyring, nil
+}
+
+func readSecretKeyFile() ([]byte, error) {
+	filename := ""
+	if app.Setting != nil {
+		filename = app.Setting.SecretKeyFile
+	}
+	if filename != "" {
+		return ioutil.ReadFile(filename)
+	}
+
+	filename = filepath.Join(app.ConfDir, defaultSecretKeyFile)
+	data, err := ioutil.ReadFile(filename)
+	if err == nil || !os.IsNotExist(err) {
+		return data, err
+	}
+	key := make([]byte, secretKeySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	data = []byte("1:" + base64.StdEncoding.EncodeToString(key) + "\n")
+	if err = ioutil.WriteFile(filename, data, 0600); err != nil {
+		return nil, fmt.Errorf("生成主密钥文件失败#%s#%s", filename, err)
+	}
+	logger.Warnf("未配置主密钥，已生成主密钥文件%s，请妥善备份，丢失后无法解密私钥等敏感数据", filename)
+
+	return data, nil
+}
+
+// 使用当前主密钥重新加密全部敏感数据，升级前保存的明文也会加密，返回更新的记录数
+func RotateSecrets() (total int64, err error) {
+	acmeUsers := make([]AcmeUser, 0)
+	if err = Db.Find(&acmeUsers); err != nil {
+		return
+	}
+	for i := range acmeUsers {
+		if err = rotateSecretColumns(acmeUsers[i].Id, &acmeUsers[i], "eab_hmac_key,private_key", &total); err != nil {
+			return
+		}
+	}
+	accessKeys := make([]AccessKey, 0)
+	if err = Db.Find(&accessKeys); err != nil {
+		return
+	}
+	for i := range accessKeys {
+		if err = rotateSecretColumns(accessKeys[i].Id, &accessKeys[i], "access_key_secret,credentials", &total); err != nil {
+			return
+		}
+	}
+	certificates := make([]Certificate, 0)
+	if err = Db.Find(&certificates); err != nil {
+		return
+	}
+	for i := range certificates {
+		if err = rotateSecretColumns(certificates[i].Id, &certificates[i], "private_key", &total); err != nil {
+			return
+		}
+	}
+	versions := make([]CertificateVersion, 0)
+	if err = Db.Find(&versions); err != nil {
+		return
+	}
+	for i := range versions {
+		if err = rotateSecretColumns(versions[i].Id, &versions[i], "private_key", &total); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func rotateSecretColumns(id int, bean interface{}, columns string, total *int64) error {
+	affected, err := Db.ID(id).Cols(columns).Update(bean)
+	if err != nil {
+		return fmt.Errorf("重新加密失败#ID-%d#%s", id, err)
+	}
+	*total += affected
+
+	return nil
+}
@@ -18,6 +18,14 @@ const (
 	TaskCertificateRevoke                         // 证书注销
 )
 
+// 证书任务，命令为json格式的参数
+func (p TaskProtocol) IsCertificate() bool {
+	return p == TaskCertificateObtain || p == TaskCertificateRenew || p == TaskCertificateRevoke
+}
+
+// HTTP、RPC任务的命令最大长度
+const TaskCommandMaxSize = 256
+
 type TaskLevel int8
 
 const (
@@ -51,7 +59,7 @@ type Task struct {
 	DependencyStatus TaskDependencyStatus `json:"dependency_status" xorm:"tinyint notnull default 1"`         // 依赖关系 1:强依赖 主任务执行成功, 依赖任务才会被执行 2:弱依赖
 	Spec             string               `json:"spec" xorm:"varchar(64) notnull"`                            // crontab
 	Protocol         TaskProtocol         `json:"protocol" xorm:"tinyint notnull index"`                      // 协议 1:http 2:系统命令
-	Command          string               `json:"command" xorm:"varchar(256) notnull"`                        // URL地址或shell命令或证书参数（json格式）
+	Command          string               `json:"command" xorm:"text notnull"`                                // URL地址或shell命令或证书参数（json格式）
 	HttpMethod       TaskHTTPMethod       `json:"http_method" xorm:"tinyint notnull default 1"`               // http请求方法
 	Timeout          int                  `json:"timeout" xorm:"mediumint notnull default 0"`                 // 任务执行超时时间(单位秒),0不限制
 	Multi            int8                 `json:"multi" xorm:"tinyint notnull default 1"`                     // 是否允许多实例运行
@@ -128,6 +136,14 @@ func (task *Task) ActiveList(page, pageSize int) ([]Task, error) {
 	return task.setHostsForTasks(list)
 }
 
+// 获取指定协议的所有激活任务
+func (task *Task) ActiveListByProtocol(protocol TaskProtocol) ([]Task, error) {
+	list := make([]Task, 0)
+	err := Db.Where("status = ? AND level = ? AND protocol = ?", Enabled, TaskLevelParent, protocol).Find(&list)
+
+	return list, err
+}
+
 // 获取某个主机下的所有激活任务
 func (task *Task) ActiveListByHostId(hostId int16) ([]Task, error) {
 	taskHostModel := new(TaskHost)
@@ -0,0 +1,632 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 阿里云ALB(应用型负载均衡)、NLB(网络型负载均衡)，证书需要先上传到证书管理服务
+const (
+	TypeAliyunALB = "aliyun_alb"
+	TypeAliyunNLB = "aliyun_nlb"
+)
+
+const (
+	// 证书管理服务的API
+	aliyunCASEndpoint = "cas.aliyuncs.com"
+	aliyunCASVersion  = "2020-04-07"
+	// 查询监听、监听证书每页数量
+	aliyunALBPageSize = 100
+	// 验证时等待异步任务完成的次数
+	aliyunALBVerifyTimes = 10
+)
+
+// 验证时每次等待的时间，测试时修改
+var aliyunALBVerifyInterval = 3 * time.Second
+
+func init() {
+	Register(TypeAliyunALB, &aliyunALBDeployer{product: aliyunALB})
+	Register(TypeAliyunNLB, &aliyunALBDeployer{product: aliyunNLB})
+}
+
+// 阿里云ALB、NLB的部署配置
+type AliyunALBConfig struct {
+	// regionId 区域，如：cn-hangzhou
+	RegionId string `json:"region_id"`
+	// ALB、NLB实例id
+	LoadBalancerId string `json:"load_balancer_id"`
+	// 监听端口，为空时部署到实例的全部HTTPS监听(NLB为TCPSSL监听)
+	ListenerPorts []int `json:"listener_ports"`
+	// 作为扩展证书(SNI)关联到监听，不替换监听的默认证书
+	Extension bool `json:"extension"`
+	// 部署成功后删除本系统上传的、不再被任何监听引用的同域名旧证书，部署前使用的证书保留用于回滚
+	Cleanup bool `json:"cleanup"`
+	// 证书管理服务的区域，中国站为cn-hangzhou，国际站为ap-southeast-1，默认cn-hangzhou
+	CasRegionId string `json:"cas_region_id"`
+	// API地址，为空时使用公网地址，如：https://alb.cn-hangzhou.aliyuncs.com
+	Endpoint    string `json:"endpoint"`
+	CasEndpoint string `json:"cas_endpoint"`
+}
+
+// ALB、NLB的API版本、监听协议、接口及参数名称
+type aliyunALBProduct struct {
+	name     string
+	endpoint string
+	version  string
+	protocol string
+	// 本系统上传到证书管理服务的证书名称前缀，ALB、NLB分开，清理时只需要检查同一产品的监听
+	certNamePrefix string
+
+	// 修改监听默认证书的参数名
+	defaultCertificateParam string
+	dissociateAction        string
+	// 关联、取消关联扩展证书的参数名
+	additionalCertificateParam string
+}
+
+var (
+	aliyunALB = &aliyunALBProduct{
+		name:                       "ALB",
+		endpoint:                   "alb.%s.aliyuncs.com",
+		version:                    "2020-06-16",
+		protocol:                   "HTTPS",
+		certNamePrefix:             "gocron-alb-",
+		defaultCertificateParam:    "Certificates.1.CertificateId",
+		dissociateAction:           "DissociateAdditionalCertificatesFromListener",
+		additionalCertificateParam: "Certificates.1.CertificateId",
+	}
+	aliyunNLB = &aliyunALBProduct{
+		name:                       "NLB",
+		endpoint:                   "nlb.%s.aliyuncs.com",
+		version:                    "2022-04-30",
+		protocol:                   "TCPSSL",
+		certNamePrefix:             "gocron-nlb-",
+		defaultCertificateParam:    "CertificateIds.1",
+		dissociateAction:           "DisassociateAdditionalCertificatesWithListener",
+		additionalCertificateParam: "AdditionalCertificateIds.1",
+	}
+)
+
+// 部署的证书，以及部署前每个监听使用的证书
+type aliyunALBState struct {
+	CertificateId string                   `json:"certificate_id"`
+	Extension     bool                     `json:"extension"`
+	Listeners     []aliyunALBListenerState `json:"listeners"`
+}
+
+type aliyunALBListenerState struct {
+	ListenerId string `json:"listener_id"`
+	Port       int    `json:"port"`
+	// 默认证书模式：部署前的默认证书
+	PreviousCertificateId string `json:"previous_certificate_id"`
+	// 扩展证书模式：部署时是否新关联了扩展证书，部署时取消关联的同域名旧扩展证书
+	Associated  bool     `json:"associated"`
+	Dissociated []string `json:"dissociated"`
+}
+
+type aliyunALBDeployer struct {
+	product *aliyunALBProduct
+}
+
+func (d *aliyunALBDeployer) config(target *Target) (*AliyunALBConfig, error) {
+	config := new(AliyunALBConfig)
+	if err := target.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+	if config.CasRegionId == "" {
+		config.CasRegionId = "cn-hangzhou"
+	}
+
+	return config, nil
+}
+
+func (d *aliyunALBDeployer) client(target *Target, config *AliyunALBConfig) (*aliyunALBClient, error) {
+	api, err := newAliyunAPI(target.AccessKey, config.RegionId, config.Endpoint, fmt.Sprintf(d.product.endpoint, config.RegionId), d.product.version)
+	if err != nil {
+		return nil, err
+	}
+	cas, err := newAliyunAPI(target.AccessKey, config.CasRegionId, config.CasEndpoint, aliyunCASEndpoint, aliyunCASVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aliyunALBClient{aliyunAPI: api, cas: cas, product: d.product, casRegionId: config.CasRegionId}, nil
+}
+
+func (d *aliyunALBDeployer) Validate(target *Target) error {
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	if config.RegionId == "" || config.LoadBalancerId == "" {
+		return errors.New("region_id、load_balancer_id不能为空")
+	}
+	ports := make(map[int]bool)
+	for _, port := range config.ListenerPorts {
+		if port < 1 || port > 65535 || ports[port] {
+			return fmt.Errorf("监听端口无效或重复：%d", port)
+		}
+		ports[port] = true
+	}
+	if target.AccessKey.Id == 0 {
+		return errors.New("需要选择AccessKey")
+	}
+	_, err = d.client(target, config)
+
+	return err
+}
+
+// 证书上传到证书管理服务后部署到每个监听，默认证书模式修改监听的默认证书，
+// 扩展证书模式关联为扩展证书并取消关联本系统上传的同域名旧扩展证书；失败时返回已部署部分的状态，可以手动回滚
+func (d *aliyunALBDeployer) Deploy(target *Target, certificate models.Certificate) (*Result, error) {
+	config, err := d.config(target)
+	if err != nil {
+		return nil, err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return nil, err
+	}
+	listeners, err := d.listeners(client, config)
+	if err != nil {
+		return nil, err
+	}
+	prefix := aliyunCertNamePrefix(d.product.certNamePrefix, certificate)
+	owned, err := client.listCASCertificates(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("查询证书管理服务的证书失败：%s", err)
+	}
+
+	output := make([]string, 0, len(listeners)+1)
+	state := aliyunALBState{Extension: config.Extension, Listeners: make([]aliyunALBListenerState, 0, len(listeners))}
+	certName := aliyunCertName(d.product.certNamePrefix, certificate)
+	for id, name := range owned {
+		if name == certName {
+			state.CertificateId = id
+			break
+		}
+	}
+	if state.CertificateId == "" {
+		if state.CertificateId, err = client.uploadCASCertificate(certName, certificate); err != nil {
+			return nil, fmt.Errorf("上传证书到证书管理服务失败：%s", err)
+		}
+		output = append(output, fmt.Sprintf("上传证书到证书管理服务成功#证书ID-%s", state.CertificateId))
+	}
+
+	result := new(Result)
+	finish := func(err error) (*Result, error) {
+		result.Output = strings.Join(output, "\n")
+		if encodeErr := result.EncodeState(state); encodeErr != nil {
+			return nil, encodeErr
+		}
+		return result, err
+	}
+	for _, listener := range listeners {
+		certs, err := client.listListenerCertificates(listener.ListenerId)
+		if err != nil {
+			return finish(err)
+		}
+		listenerState := aliyunALBListenerState{ListenerId: listener.ListenerId, Port: listener.ListenerPort}
+		if !config.Extension {
+			for _, c := range certs {
+				if c.IsDefault {
+					listenerState.PreviousCertificateId = c.CertificateId
+				}
+			}
+			state.Listeners = append(state.Listeners, listenerState)
+			if listenerState.PreviousCertificateId == state.CertificateId {
+				output = append(output, fmt.Sprintf("监听[%d]已使用该证书，跳过", listener.ListenerPort))
+				continue
+			}
+			if err = client.setDefaultCertificate(listener.ListenerId, state.CertificateId); err != nil {
+				return finish(fmt.Errorf("监听[%d]修改默认证书失败：%s", listener.ListenerPort, err))
+			}
+			output = append(output, fmt.Sprintf("监听[%d]默认证书部署成功#证书ID-%s", listener.ListenerPort, state.CertificateId))
+			continue
+		}
+
+		associated := false
+		for _, c := range certs {
+			if !c.IsDefault && c.CertificateId == state.CertificateId {
+				associated = true
+			}
+		}
+		if !associated {
+			if err = client.associateCertificate(listener.ListenerId, state.CertificateId); err != nil {
+				state.Listeners = append(state.Listeners, listenerState)
+				return finish(fmt.Errorf("监听[%d]关联扩展证书失败：%s", listener.ListenerPort, err))
+			}
+			listenerState.Associated = true
+		}
+		for _, c := range certs {
+			if _, ok := owned[c.CertificateId]; !ok || c.IsDefault || c.CertificateId == state.CertificateId {
+				continue
+			}
+			if err = client.dissociateCertificate(listener.ListenerId, c.CertificateId); err != nil {
+				state.Listeners = append(state.Listeners, listenerState)
+				return finish(fmt.Errorf("监听[%d]取消关联旧扩展证书%s失败：%s", listener.ListenerPort, c.CertificateId, err))
+			}
+			listenerState.Dissociated = append(listenerState.Dissociated, c.CertificateId)
+		}
+		state.Listeners = append(state.Listeners, listenerState)
+		if associated && len(listenerState.Dissociated) == 0 {
+			output = append(output, fmt.Sprintf("监听[%d]已关联该证书，跳过", listener.ListenerPort))
+			continue
+		}
+		output = append(output, fmt.Sprintf("监听[%d]扩展证书部署成功#证书ID-%s", listener.ListenerPort, state.CertificateId))
+	}
+
+	return finish(nil)
+}
+
+// 监听使用部署的证书，修改监听证书是异步任务，等待完成
+func (d *aliyunALBDeployer) Verify(target *Target, certificate models.Certificate, result *Result) error {
+	state := new(aliyunALBState)
+	if err := result.DecodeState(state); err != nil {
+		return err
+	}
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return err
+	}
+	for _, listener := range state.Listeners {
+		for i := 1; ; i++ {
+			err = d.verifyListener(client, state, listener)
+			if err == nil {
+				break
+			}
+			if i >= aliyunALBVerifyTimes {
+				return err
+			}
+			time.Sleep(aliyunALBVerifyInterval)
+		}
+	}
+
+	return nil
+}
+
+func (d *aliyunALBDeployer) verifyListener(client *aliyunALBClient, state *aliyunALBState, listener aliyunALBListenerState) error {
+	certs, err := client.listListenerCertificates(listener.ListenerId)
+	if err != nil {
+		return err
+	}
+	for _, c := range certs {
+		if c.CertificateId != state.CertificateId || c.IsDefault == state.Extension {
+			continue
+		}
+		if c.Status != "" && c.Status != "Associated" {
+			return fmt.Errorf("监听[%d]的证书%s状态为%s", listener.Port, c.CertificateId, c.Status)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("监听[%d]未使用部署的证书%s", listener.Port, state.CertificateId)
+}
+
+// 监听恢复使用部署前的证书：默认证书模式恢复默认证书，扩展证书模式取消关联部署的证书并重新关联取消关联的旧证书
+func (d *aliyunALBDeployer) Rollback(target *Target, result *Result) error {
+	state := new(aliyunALBState)
+	if err := result.DecodeState(state); err != nil {
+		return err
+	}
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, listener := range state.Listeners {
+		if !state.Extension {
+			previous := listener.PreviousCertificateId
+			if previous == "" || previous == state.CertificateId {
+				continue
+			}
+			if err = client.setDefaultCertificate(listener.ListenerId, previous); err != nil {
+				errs = append(errs, fmt.Sprintf("监听[%d]恢复默认证书失败：%s", listener.Port, err))
+			}
+			continue
+		}
+		for _, certificateId := range listener.Dissociated {
+			if err = client.associateCertificate(listener.ListenerId, certificateId); err != nil {
+				errs = append(errs, fmt.Sprintf("监听[%d]重新关联扩展证书%s失败：%s", listener.Port, certificateId, err))
+			}
+		}
+		if listener.Associated {
+			if err = client.dissociateCertificate(listener.ListenerId, state.CertificateId); err != nil {
+				errs = append(errs, fmt.Sprintf("监听[%d]取消关联扩展证书失败：%s", listener.Port, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "；"))
+	}
+
+	return nil
+}
+
+// 删除本系统上传的同域名旧证书，部署的证书、部署前使用的证书、仍被区域内任一监听引用的证书不删除
+func (d *aliyunALBDeployer) Cleanup(target *Target, certificate models.Certificate, result *Result) (string, error) {
+	config, err := d.config(target)
+	if err != nil || !config.Cleanup {
+		return "", err
+	}
+	state := new(aliyunALBState)
+	if err = result.DecodeState(state); err != nil {
+		return "", err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return "", err
+	}
+	keep := map[string]bool{state.CertificateId: true}
+	for _, listener := range state.Listeners {
+		keep[listener.PreviousCertificateId] = true
+		for _, certificateId := range listener.Dissociated {
+			keep[certificateId] = true
+		}
+	}
+	owned, err := client.listCASCertificates(aliyunCertNamePrefix(d.product.certNamePrefix, certificate))
+	if err != nil {
+		return "", err
+	}
+	candidates := make([]string, 0, len(owned))
+	for certificateId := range owned {
+		if !keep[certificateId] {
+			candidates = append(candidates, certificateId)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	referenced, err := client.referencedCertificates()
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(candidates)
+	output := make([]string, 0, len(candidates))
+	for _, certificateId := range candidates {
+		if referenced[certificateId] {
+			output = append(output, fmt.Sprintf("证书[%s-%s]仍被监听使用，保留", owned[certificateId], certificateId))
+			continue
+		}
+		if err = client.deleteCASCertificate(certificateId); err != nil {
+			return strings.Join(output, "\n"), err
+		}
+		output = append(output, fmt.Sprintf("删除不再使用的证书[%s-%s]", owned[certificateId], certificateId))
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+// 需要部署的监听，指定的监听端口不存在时返回错误
+func (d *aliyunALBDeployer) listeners(client *aliyunALBClient, config *AliyunALBConfig) ([]aliyunALBListener, error) {
+	list, err := client.listListeners(config.LoadBalancerId)
+	if err != nil {
+		return nil, fmt.Errorf("查询%s监听失败：%s", d.product.name, err)
+	}
+	if len(config.ListenerPorts) == 0 {
+		if len(list) == 0 {
+			return nil, fmt.Errorf("%s实例%s没有%s监听", d.product.name, config.LoadBalancerId, d.product.protocol)
+		}
+		return list, nil
+	}
+	listeners := make([]aliyunALBListener, 0, len(config.ListenerPorts))
+	for _, port := range config.ListenerPorts {
+		found := false
+		for _, listener := range list {
+			if listener.ListenerPort == port {
+				listeners = append(listeners, listener)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s实例%s的%s监听[%d]不存在", d.product.name, config.LoadBalancerId, d.product.protocol, port)
+		}
+	}
+
+	return listeners, nil
+}
+
+// 调用ALB、NLB和证书管理服务的API
+type aliyunALBClient struct {
+	*aliyunAPI
+	cas         *aliyunAPI
+	product     *aliyunALBProduct
+	casRegionId string
+}
+
+type aliyunALBListener struct {
+	ListenerId       string
+	ListenerPort     int
+	ListenerProtocol string
+	LoadBalancerId   string
+}
+
+type aliyunALBListenersResponse struct {
+	Listeners []aliyunALBListener
+	NextToken string
+}
+
+type aliyunALBCertificate struct {
+	CertificateId string
+	IsDefault     bool
+	Status        string
+}
+
+type aliyunALBCertificatesResponse struct {
+	Certificates []aliyunALBCertificate
+	NextToken    string
+}
+
+type aliyunCASCertificate struct {
+	CertificateId int64
+	Name          string
+}
+
+type aliyunCASCertificatesResponse struct {
+	CertificateOrderList []aliyunCASCertificate
+	TotalCount           int
+}
+
+// 实例的HTTPS(NLB为TCPSSL)监听，loadBalancerId为空时查询区域内全部实例的监听
+func (c *aliyunALBClient) listListeners(loadBalancerId string) ([]aliyunALBListener, error) {
+	listeners := make([]aliyunALBListener, 0)
+	params := map[string]string{
+		"ListenerProtocol": c.product.protocol,
+		"MaxResults":       strconv.Itoa(aliyunALBPageSize),
+	}
+	if loadBalancerId != "" {
+		params["LoadBalancerIds.1"] = loadBalancerId
+	}
+	for {
+		response := new(aliyunALBListenersResponse)
+		if err := c.call("ListListeners", params, nil, response); err != nil {
+			return nil, err
+		}
+		for _, listener := range response.Listeners {
+			if strings.EqualFold(listener.ListenerProtocol, c.product.protocol) {
+				listeners = append(listeners, listener)
+			}
+		}
+		if response.NextToken == "" {
+			return listeners, nil
+		}
+		params["NextToken"] = response.NextToken
+	}
+}
+
+// 监听的服务器证书，包含默认证书和扩展证书
+func (c *aliyunALBClient) listListenerCertificates(listenerId string) ([]aliyunALBCertificate, error) {
+	certs := make([]aliyunALBCertificate, 0)
+	params := map[string]string{
+		"ListenerId":      listenerId,
+		"CertificateType": "Server",
+		"MaxResults":      strconv.Itoa(aliyunALBPageSize),
+	}
+	for {
+		response := new(aliyunALBCertificatesResponse)
+		if err := c.call("ListListenerCertificates", params, nil, response); err != nil {
+			return nil, err
+		}
+		certs = append(certs, response.Certificates...)
+		if response.NextToken == "" {
+			return certs, nil
+		}
+		params["NextToken"] = response.NextToken
+	}
+}
+
+func (c *aliyunALBClient) setDefaultCertificate(listenerId, certificateId string) error {
+	params := map[string]string{
+		"ListenerId":                      listenerId,
+		c.product.defaultCertificateParam: certificateId,
+	}
+
+	return c.call("UpdateListenerAttribute", params, nil, nil)
+}
+
+func (c *aliyunALBClient) associateCertificate(listenerId, certificateId string) error {
+	params := map[string]string{
+		"ListenerId":                         listenerId,
+		c.product.additionalCertificateParam: certificateId,
+	}
+
+	return c.call("AssociateAdditionalCertificatesWithListener", params, nil, nil)
+}
+
+func (c *aliyunALBClient) dissociateCertificate(listenerId, certificateId string) error {
+	params := map[string]string{
+		"ListenerId":                         listenerId,
+		c.product.additionalCertificateParam: certificateId,
+	}
+
+	return c.call(c.product.dissociateAction, params, nil, nil)
+}
+
+// 区域内全部监听引用的证书
+func (c *aliyunALBClient) referencedCertificates() (map[string]bool, error) {
+	listeners, err := c.listListeners("")
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, listener := range listeners {
+		certs, err := c.listListenerCertificates(listener.ListenerId)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			referenced[cert.CertificateId] = true
+		}
+	}
+
+	return referenced, nil
+}
+
+// 监听使用的证书ID，格式为{证书管理服务的证书ID}-{证书管理服务的区域}
+func (c *aliyunALBClient) certificateId(casCertificateId int64) string {
+	return fmt.Sprintf("%d-%s", casCertificateId, c.casRegionId)
+}
+
+// 上传证书到证书管理服务，返回监听使用的证书ID
+func (c *aliyunALBClient) uploadCASCertificate(name string, certificate models.Certificate) (string, error) {
+	params := map[string]string{"Name": name}
+	form := map[string]string{
+		"Cert": certificate.Certificate,
+		"Key":  string(certificate.PrivateKey),
+	}
+	response := new(struct{ CertId int64 })
+	if err := c.cas.call("UploadUserCertificate", params, form, response); err != nil {
+		return "", err
+	}
+
+	return c.certificateId(response.CertId), nil
+}
+
+// 证书管理服务中名称以prefix开头的上传证书，key为监听使用的证书ID，value为证书名称
+func (c *aliyunALBClient) listCASCertificates(prefix string) (map[string]string, error) {
+	certs := make(map[string]string)
+	total := 0
+	for page := 1; ; page++ {
+		params := map[string]string{
+			"OrderType":   "UPLOAD",
+			"Keyword":     prefix,
+			"CurrentPage": strconv.Itoa(page),
+			"ShowSize":    strconv.Itoa(aliyunALBPageSize),
+		}
+		response := new(aliyunCASCertificatesResponse)
+		if err := c.cas.call("ListUserCertificateOrder", params, nil, response); err != nil {
+			return nil, err
+		}
+		for _, cert := range response.CertificateOrderList {
+			if strings.HasPrefix(cert.Name, prefix) {
+				certs[c.certificateId(cert.CertificateId)] = cert.Name
+			}
+		}
+		total += len(response.CertificateOrderList)
+		if len(response.CertificateOrderList) == 0 || total >= response.TotalCount {
+			return certs, nil
+		}
+	}
+}
+
+// 删除证书管理服务中的证书，certificateId为监听使用的证书ID
+func (c *aliyunALBClient) deleteCASCertificate(certificateId string) error {
+	casCertificateId := strings.TrimSuffix(certificateId, "-"+c.casRegionId)
+
+	return c.cas.call("DeleteUserCertificate", map[string]string{"CertId": casCertificateId}, nil, nil)
+}
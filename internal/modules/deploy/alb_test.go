@@ -0,0 +1,335 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 本地模拟的ALB、NLB和证书管理服务API
+type testAliyunALBServer struct {
+	product   *aliyunALBProduct
+	listeners []aliyunALBListener
+	// 监听的证书，key为监听ID
+	certs map[string][]aliyunALBCertificate
+	// 证书管理服务中上传的证书，key为证书ID
+	cas    map[int64]string
+	nextId int64
+	// 修改后的证书一直处于关联中，用于验证失败
+	stuck bool
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func (s *testAliyunALBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action := r.Form.Get("Action")
+	listenerId := r.Form.Get("ListenerId")
+	s.actions = append(s.actions, strings.TrimSpace(action+" "+listenerId))
+
+	var response interface{} = map[string]string{"RequestId": "test"}
+	switch action {
+	case "ListListeners":
+		resp := new(aliyunALBListenersResponse)
+		loadBalancerId := r.Form.Get("LoadBalancerIds.1")
+		for _, listener := range s.listeners {
+			if loadBalancerId == "" || listener.LoadBalancerId == loadBalancerId {
+				resp.Listeners = append(resp.Listeners, listener)
+			}
+		}
+		response = resp
+	case "ListListenerCertificates":
+		resp := new(aliyunALBCertificatesResponse)
+		resp.Certificates = append(resp.Certificates, s.certs[listenerId]...)
+		// 修改监听证书是异步任务，查询一次后完成
+		if !s.stuck {
+			for i := range s.certs[listenerId] {
+				s.certs[listenerId][i].Status = "Associated"
+			}
+		}
+		response = resp
+	case "UpdateListenerAttribute":
+		certs := make([]aliyunALBCertificate, 0)
+		for _, c := range s.certs[listenerId] {
+			if !c.IsDefault {
+				certs = append(certs, c)
+			}
+		}
+		certificateId := r.Form.Get(s.product.defaultCertificateParam)
+		s.certs[listenerId] = append(certs, aliyunALBCertificate{CertificateId: certificateId, IsDefault: true, Status: "Associating"})
+	case "AssociateAdditionalCertificatesWithListener":
+		certificateId := r.Form.Get(s.product.additionalCertificateParam)
+		s.certs[listenerId] = append(s.certs[listenerId], aliyunALBCertificate{CertificateId: certificateId, Status: "Associating"})
+	case s.product.dissociateAction:
+		certificateId := r.Form.Get(s.product.additionalCertificateParam)
+		certs := make([]aliyunALBCertificate, 0)
+		for _, c := range s.certs[listenerId] {
+			if c.IsDefault || c.CertificateId != certificateId {
+				certs = append(certs, c)
+			}
+		}
+		s.certs[listenerId] = certs
+	case "UploadUserCertificate":
+		if r.Form.Get("Cert") == "" || r.Form.Get("Key") == "" {
+			http.Error(w, `{"Code":"MissingParameter","Message":"Cert and Key are required"}`, http.StatusBadRequest)
+			return
+		}
+		s.nextId++
+		s.cas[s.nextId] = r.Form.Get("Name")
+		response = map[string]int64{"CertId": s.nextId}
+	case "ListUserCertificateOrder":
+		resp := new(aliyunCASCertificatesResponse)
+		keyword := r.Form.Get("Keyword")
+		for id, name := range s.cas {
+			if strings.Contains(name, keyword) {
+				resp.CertificateOrderList = append(resp.CertificateOrderList, aliyunCASCertificate{CertificateId: id, Name: name})
+			}
+		}
+		resp.TotalCount = len(resp.CertificateOrderList)
+		response = resp
+	case "DeleteUserCertificate":
+		id, _ := strconv.ParseInt(r.Form.Get("CertId"), 10, 64)
+		delete(s.cas, id)
+	default:
+		http.Error(w, `{"Code":"InvalidAction","Message":"unknown action"}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// 监听当前的证书ID，默认证书在前
+func (s *testAliyunALBServer) listenerCertificates(listenerId string) (defaultId string, extensions []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.certs[listenerId] {
+		if c.IsDefault {
+			defaultId = c.CertificateId
+		} else {
+			extensions = append(extensions, c.CertificateId)
+		}
+	}
+
+	return defaultId, extensions
+}
+
+func (s *testAliyunALBServer) hasAction(action string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.actions {
+		if strings.HasPrefix(item, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lb-1有两个加密监听和一个HTTP监听，lb-2的监听用于检查清理时是否仍被引用
+func newTestAliyunALBServer(product *aliyunALBProduct) *testAliyunALBServer {
+	return &testAliyunALBServer{
+		product: product,
+		listeners: []aliyunALBListener{
+			{ListenerId: "lsn-443", ListenerPort: 443, ListenerProtocol: product.protocol, LoadBalancerId: "lb-1"},
+			{ListenerId: "lsn-8443", ListenerPort: 8443, ListenerProtocol: product.protocol, LoadBalancerId: "lb-1"},
+			{ListenerId: "lsn-80", ListenerPort: 80, ListenerProtocol: "HTTP", LoadBalancerId: "lb-1"},
+			{ListenerId: "lsn-other", ListenerPort: 443, ListenerProtocol: product.protocol, LoadBalancerId: "lb-2"},
+		},
+		certs:  make(map[string][]aliyunALBCertificate),
+		cas:    make(map[int64]string),
+		nextId: 100,
+	}
+}
+
+func newTestAliyunALBTarget(t *testing.T, targetType string, server *httptest.Server, config AliyunALBConfig) *Target {
+	config.RegionId = "cn-hangzhou"
+	config.LoadBalancerId = "lb-1"
+	config.Endpoint = server.URL
+	config.CasEndpoint = server.URL
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &Target{}
+	target.Name = "alb"
+	target.Type = targetType
+	target.Config = string(data)
+	target.Status = models.Enabled
+	target.AccessKey.Id = 1
+	target.AccessKey.AccessKeyId = "test"
+	target.AccessKey.AccessKeySecret = "secret"
+
+	return target
+}
+
+// 缩短验证的等待时间，返回恢复原值的函数
+func setTestAliyunALBVerifyInterval() func() {
+	interval := aliyunALBVerifyInterval
+	aliyunALBVerifyInterval = time.Millisecond
+
+	return func() {
+		aliyunALBVerifyInterval = interval
+	}
+}
+
+// 默认证书模式：上传证书后替换监听的默认证书，清理本系统上传的不再使用的同域名旧证书
+func TestAliyunALBDeployDefault(t *testing.T) {
+	defer setTestAliyunALBVerifyInterval()()
+	for targetType, product := range map[string]*aliyunALBProduct{TypeAliyunALB: aliyunALB, TypeAliyunNLB: aliyunNLB} {
+		stub := newTestAliyunALBServer(product)
+		server := httptest.NewServer(stub)
+
+		certificate := newTestCertificate(t, "a.com", "*.a.com")
+		previous := newTestCertificate(t, "a.com")
+		stale := newTestCertificate(t, "a.com")
+		shared := newTestCertificate(t, "a.com")
+		stub.cas[1] = aliyunCertName(product.certNamePrefix, previous)
+		stub.cas[2] = aliyunCertName(product.certNamePrefix, stale)
+		stub.cas[3] = aliyunCertName(product.certNamePrefix, shared)
+		stub.cas[4] = "manual-a.com"
+		stub.certs["lsn-443"] = []aliyunALBCertificate{{CertificateId: "1-cn-hangzhou", IsDefault: true}}
+		stub.certs["lsn-other"] = []aliyunALBCertificate{{CertificateId: "3-cn-hangzhou", IsDefault: true}}
+
+		target := newTestAliyunALBTarget(t, targetType, server, AliyunALBConfig{Cleanup: true})
+		result, err := Run(target, certificate)
+		if err != nil {
+			t.Fatalf("%s: %s", targetType, err)
+		}
+		if stub.cas[101] != aliyunCertName(product.certNamePrefix, certificate) {
+			t.Fatalf("%s: 证书未上传到证书管理服务: %v", targetType, stub.cas)
+		}
+		for _, listenerId := range []string{"lsn-443", "lsn-8443"} {
+			if defaultId, _ := stub.listenerCertificates(listenerId); defaultId != "101-cn-hangzhou" {
+				t.Fatalf("%s: 监听[%s]的默认证书为%s", targetType, listenerId, defaultId)
+			}
+		}
+		if _, ok := stub.certs["lsn-80"]; ok {
+			t.Fatalf("%s: 不应部署到HTTP监听", targetType)
+		}
+
+		state := new(aliyunALBState)
+		if err = result.DecodeState(state); err != nil {
+			t.Fatal(err)
+		}
+		if state.CertificateId != "101-cn-hangzhou" || len(state.Listeners) != 2 || state.Listeners[0].PreviousCertificateId != "1-cn-hangzhou" {
+			t.Fatalf("%s: 部署前的状态错误: %+v", targetType, state)
+		}
+
+		// 部署前使用的证书保留用于回滚，仍被其他实例使用的证书、非本系统上传的证书不删除
+		if _, ok := stub.cas[2]; ok {
+			t.Fatalf("%s: 不再使用的旧证书未删除: %v", targetType, stub.cas)
+		}
+		for _, id := range []int64{1, 3, 4, 101} {
+			if _, ok := stub.cas[id]; !ok {
+				t.Fatalf("%s: 证书%d不应删除", targetType, id)
+			}
+		}
+		if !strings.Contains(result.Output, "仍被监听使用，保留") {
+			t.Fatalf("%s: 输出缺少保留的证书: %s", targetType, result.Output)
+		}
+
+		// 再次部署时使用已上传的证书，已使用该证书的监听跳过
+		stub.actions = nil
+		result, err = Run(newTestAliyunALBTarget(t, targetType, server, AliyunALBConfig{}), certificate)
+		if err != nil {
+			t.Fatalf("%s: %s", targetType, err)
+		}
+		if stub.hasAction("UploadUserCertificate") || stub.hasAction("UpdateListenerAttribute") {
+			t.Fatalf("%s: 不应重复上传、设置证书: %v", targetType, stub.actions)
+		}
+		if !strings.Contains(result.Output, "监听[443]已使用该证书，跳过") {
+			t.Fatalf("%s: 输出错误: %s", targetType, result.Output)
+		}
+		server.Close()
+	}
+}
+
+// 扩展证书模式：关联新证书并取消关联本系统上传的同域名旧扩展证书，回滚时恢复
+func TestAliyunALBDeployExtension(t *testing.T) {
+	defer setTestAliyunALBVerifyInterval()()
+	for targetType, product := range map[string]*aliyunALBProduct{TypeAliyunALB: aliyunALB, TypeAliyunNLB: aliyunNLB} {
+		stub := newTestAliyunALBServer(product)
+		server := httptest.NewServer(stub)
+
+		certificate := newTestCertificate(t, "a.com")
+		old := newTestCertificate(t, "a.com")
+		stub.cas[1] = aliyunCertName(product.certNamePrefix, old)
+		stub.certs["lsn-443"] = []aliyunALBCertificate{
+			{CertificateId: "9-cn-hangzhou", IsDefault: true},
+			{CertificateId: "1-cn-hangzhou"},
+			{CertificateId: "8-cn-hangzhou"},
+		}
+
+		target := newTestAliyunALBTarget(t, targetType, server, AliyunALBConfig{ListenerPorts: []int{443}, Extension: true})
+		result, err := Run(target, certificate)
+		if err != nil {
+			t.Fatalf("%s: %s", targetType, err)
+		}
+		defaultId, extensions := stub.listenerCertificates("lsn-443")
+		if defaultId != "9-cn-hangzhou" {
+			t.Fatalf("%s: 扩展证书模式不应修改默认证书: %s", targetType, defaultId)
+		}
+		if fmt.Sprint(extensions) != "[8-cn-hangzhou 101-cn-hangzhou]" {
+			t.Fatalf("%s: 扩展证书错误: %v", targetType, extensions)
+		}
+		if _, extensions = stub.listenerCertificates("lsn-8443"); len(extensions) > 0 {
+			t.Fatalf("%s: 不应部署到未指定的监听", targetType)
+		}
+
+		if err = Rollback(target, result.State); err != nil {
+			t.Fatalf("%s: %s", targetType, err)
+		}
+		defaultId, extensions = stub.listenerCertificates("lsn-443")
+		if defaultId != "9-cn-hangzhou" || fmt.Sprint(extensions) != "[8-cn-hangzhou 1-cn-hangzhou]" {
+			t.Fatalf("%s: 回滚后的证书错误: %s %v", targetType, defaultId, extensions)
+		}
+		server.Close()
+	}
+}
+
+// 修改后的证书一直未生效时验证失败，监听恢复部署前的默认证书
+func TestAliyunALBVerifyFailedRollback(t *testing.T) {
+	defer setTestAliyunALBVerifyInterval()()
+	stub := newTestAliyunALBServer(aliyunALB)
+	stub.stuck = true
+	stub.certs["lsn-443"] = []aliyunALBCertificate{{CertificateId: "9-cn-hangzhou", IsDefault: true, Status: "Associated"}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	target := newTestAliyunALBTarget(t, TypeAliyunALB, server, AliyunALBConfig{ListenerPorts: []int{443}})
+	_, err := Run(target, newTestCertificate(t, "a.com"))
+	if _, ok := err.(*RolledBackError); !ok {
+		t.Fatalf("验证失败时应回滚, 实际%v", err)
+	}
+	if defaultId, _ := stub.listenerCertificates("lsn-443"); defaultId != "9-cn-hangzhou" {
+		t.Fatalf("回滚后的默认证书为%s", defaultId)
+	}
+}
+
+func TestAliyunALBListenerPortNotFound(t *testing.T) {
+	stub := newTestAliyunALBServer(aliyunNLB)
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	target := newTestAliyunALBTarget(t, TypeAliyunNLB, server, AliyunALBConfig{ListenerPorts: []int{80}})
+	if _, err := Run(target, newTestCertificate(t, "a.com")); err == nil || !strings.Contains(err.Error(), "监听[80]不存在") {
+		t.Fatalf("指定的监听不存在时应返回错误, 实际%v", err)
+	}
+	if stub.hasAction("UploadUserCertificate") {
+		t.Fatal("监听不存在时不应上传证书")
+	}
+}
@@ -0,0 +1,80 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 阿里云RPC风格的API，SDK没有对应产品或接口的使用通用请求调用，如：CDN、ALB、NLB、证书服务
+type aliyunAPI struct {
+	client  *sdk.Client
+	scheme  string
+	domain  string
+	version string
+}
+
+// endpoint为空时使用domain，不为空时需要包含协议，如：https://cdn.aliyuncs.com，用于VPC地址或本地测试
+func newAliyunAPI(ak models.AccessKey, regionId, endpoint, domain, version string) (*aliyunAPI, error) {
+	api := &aliyunAPI{scheme: "https", domain: domain, version: version}
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("API地址无效：%s", endpoint)
+		}
+		api.scheme, api.domain = u.Scheme, u.Host
+	}
+	client, err := sdk.NewClientWithAccessKey(regionId, ak.AccessKeyId, string(ak.AccessKeySecret))
+	if err != nil {
+		return nil, err
+	}
+	api.client = client
+
+	return api, nil
+}
+
+// 调用接口，返回的json解析到v，v为nil时不解析；form中的参数放在请求体中，用于证书、私钥等较长的内容
+func (api *aliyunAPI) call(action string, params, form map[string]string, v interface{}) error {
+	request := requests.NewCommonRequest()
+	request.Method = requests.POST
+	request.Scheme = api.scheme
+	request.Domain = api.domain
+	request.Version = api.version
+	request.ApiName = action
+	for key, value := range params {
+		request.QueryParams[key] = value
+	}
+	for key, value := range form {
+		request.FormParams[key] = value
+	}
+
+	response, err := api.client.ProcessCommonRequest(request)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(response.GetHttpContentBytes(), v)
+}
+
+// 本系统上传的证书名称，包含域名和指纹，同一证书重复部署时不重复上传
+func aliyunCertName(prefix string, certificate models.Certificate) string {
+	fingerprint := certificate.Fingerprint
+	if len(fingerprint) > 12 {
+		fingerprint = fingerprint[:12]
+	}
+
+	return aliyunCertNamePrefix(prefix, certificate) + fingerprint
+}
+
+// 本系统上传的同域名证书的名称前缀，清理旧证书时使用
+func aliyunCertNamePrefix(prefix string, certificate models.Certificate) string {
+	return prefix + strings.Replace(normalizeDomain(certificate.Domain), "*", "wildcard", 1) + "-"
+}
@@ -0,0 +1,470 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 阿里云CDN、DCDN(全站加速)
+const (
+	TypeAliyunCDN  = "aliyun_cdn"
+	TypeAliyunDCDN = "aliyun_dcdn"
+)
+
+// 查询加速域名列表每页数量，接口允许的最大值
+const aliyunCDNPageSize = 500
+
+func init() {
+	Register(TypeAliyunCDN, &aliyunCDNDeployer{product: aliyunCDN})
+	Register(TypeAliyunDCDN, &aliyunCDNDeployer{product: aliyunDCDN})
+}
+
+// 阿里云CDN、DCDN的部署配置
+type AliyunCDNConfig struct {
+	// 加速域名，为空时部署到与证书域名(SAN)匹配的全部加速域名，泛域名证书匹配一级子域名
+	Domains []string `json:"domains"`
+	// API地址，为空时使用公网地址，如：https://cdn.aliyuncs.com
+	Endpoint string `json:"endpoint"`
+}
+
+// CDN、DCDN的API版本、接口名称、参数名称
+type aliyunCDNProduct struct {
+	name     string
+	endpoint string
+	version  string
+
+	describeDomainsAction     string
+	describeCertificateAction string
+	setCertificateAction      string
+	// 设置证书接口的HTTPS开关、证书、私钥参数名
+	statusParam      string
+	certificateParam string
+	privateKeyParam  string
+	// 证书名称已存在时覆盖
+	forceSet bool
+	// 本系统上传的证书名称前缀
+	certNamePrefix string
+}
+
+var (
+	aliyunCDN = &aliyunCDNProduct{
+		name:                      "CDN",
+		endpoint:                  "cdn.aliyuncs.com",
+		version:                   "2018-05-10",
+		describeDomainsAction:     "DescribeUserDomains",
+		describeCertificateAction: "DescribeDomainCertificateInfo",
+		setCertificateAction:      "SetDomainServerCertificate",
+		statusParam:               "ServerCertificateStatus",
+		certificateParam:          "ServerCertificate",
+		privateKeyParam:           "PrivateKey",
+		forceSet:                  true,
+		certNamePrefix:            "gocron-cdn-",
+	}
+	aliyunDCDN = &aliyunCDNProduct{
+		name:                      "DCDN",
+		endpoint:                  "dcdn.aliyuncs.com",
+		version:                   "2018-01-15",
+		describeDomainsAction:     "DescribeDcdnUserDomains",
+		describeCertificateAction: "DescribeDcdnDomainCertificateInfo",
+		setCertificateAction:      "SetDcdnDomainCertificate",
+		statusParam:               "SSLProtocol",
+		certificateParam:          "SSLPub",
+		privateKeyParam:           "SSLPri",
+		certNamePrefix:            "gocron-dcdn-",
+	}
+)
+
+// 部署的证书指纹，以及部署前每个加速域名使用的证书指纹，部署前未开启HTTPS时为空
+type aliyunCDNState struct {
+	Fingerprint string            `json:"fingerprint"`
+	Domains     map[string]string `json:"domains"`
+}
+
+type aliyunCDNDeployer struct {
+	product *aliyunCDNProduct
+}
+
+func (d *aliyunCDNDeployer) config(target *Target) (*AliyunCDNConfig, error) {
+	config := new(AliyunCDNConfig)
+	if err := target.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+	domains := make([]string, 0, len(config.Domains))
+	for _, domain := range config.Domains {
+		if domain = normalizeDomain(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	config.Domains = domains
+
+	return config, nil
+}
+
+func (d *aliyunCDNDeployer) client(target *Target, config *AliyunCDNConfig) (*aliyunCDNClient, error) {
+	// CDN、DCDN是全局服务，区域不影响请求
+	api, err := newAliyunAPI(target.AccessKey, "cn-hangzhou", config.Endpoint, d.product.endpoint, d.product.version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aliyunCDNClient{aliyunAPI: api, product: d.product}, nil
+}
+
+func (d *aliyunCDNDeployer) Validate(target *Target) error {
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	if target.AccessKey.Id == 0 {
+		return errors.New("需要选择AccessKey")
+	}
+	_, err = d.client(target, config)
+
+	return err
+}
+
+// 依次设置每个加速域名的证书，加速域名之间互不影响，任一域名失败时返回错误
+func (d *aliyunCDNDeployer) Deploy(target *Target, certificate models.Certificate) (*Result, error) {
+	config, err := d.config(target)
+	if err != nil {
+		return nil, err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return nil, err
+	}
+	domains, output, failed, err := d.matchDomains(client, config, certificateDomains(certificate))
+	if err != nil {
+		return nil, err
+	}
+	if len(domains) == 0 && failed == 0 {
+		return nil, fmt.Errorf("没有与证书域名匹配的%s加速域名", d.product.name)
+	}
+
+	state := aliyunCDNState{Fingerprint: certificate.Fingerprint, Domains: make(map[string]string, len(domains))}
+	certName := aliyunCertName(d.product.certNamePrefix, certificate)
+	for _, domain := range domains {
+		info, err := client.describeCertificate(domain)
+		if err != nil {
+			output = append(output, fmt.Sprintf("加速域名[%s]部署失败：查询证书失败：%s", domain, err))
+			failed++
+			continue
+		}
+		previous := info.fingerprint()
+		if previous == certificate.Fingerprint {
+			state.Domains[domain] = previous
+			output = append(output, fmt.Sprintf("加速域名[%s]已使用该证书，跳过", domain))
+			continue
+		}
+		if err = client.setCertificate(domain, certName, certificate.Certificate, string(certificate.PrivateKey)); err != nil {
+			output = append(output, fmt.Sprintf("加速域名[%s]部署失败：%s", domain, err))
+			failed++
+			continue
+		}
+		state.Domains[domain] = previous
+		output = append(output, fmt.Sprintf("加速域名[%s]部署成功#证书名称-%s", domain, certName))
+	}
+
+	result := &Result{Output: strings.Join(output, "\n")}
+	if err = result.EncodeState(state); err != nil {
+		return nil, err
+	}
+	if failed > 0 {
+		return result, fmt.Errorf("%d个%s加速域名部署失败", failed, d.product.name)
+	}
+
+	return result, nil
+}
+
+// 加速域名使用的证书与部署的证书一致
+func (d *aliyunCDNDeployer) Verify(target *Target, certificate models.Certificate, result *Result) error {
+	state := new(aliyunCDNState)
+	if err := result.DecodeState(state); err != nil {
+		return err
+	}
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, domain := range sortedDomains(state.Domains) {
+		info, err := client.describeCertificate(domain)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("加速域名[%s]查询证书失败：%s", domain, err))
+			continue
+		}
+		if fingerprint := info.fingerprint(); fingerprint != state.Fingerprint {
+			errs = append(errs, fmt.Sprintf("加速域名[%s]使用的证书%s与部署的证书%s不一致", domain, fingerprint, state.Fingerprint))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "；"))
+	}
+
+	return nil
+}
+
+// 加速域名恢复使用部署前的证书，部署前未开启HTTPS的关闭HTTPS
+// 部署前的证书需要是本系统保存的证书版本，CDN不返回私钥
+func (d *aliyunCDNDeployer) Rollback(target *Target, result *Result) error {
+	state := new(aliyunCDNState)
+	if err := result.DecodeState(state); err != nil {
+		return err
+	}
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return err
+	}
+	versions := make(map[string]*models.CertificateVersion)
+	var errs []string
+	for _, domain := range sortedDomains(state.Domains) {
+		previous := state.Domains[domain]
+		if previous == state.Fingerprint {
+			continue
+		}
+		if previous == "" {
+			if err = client.disableCertificate(domain); err != nil {
+				errs = append(errs, fmt.Sprintf("加速域名[%s]关闭HTTPS失败：%s", domain, err))
+			}
+			continue
+		}
+		version, ok := versions[previous]
+		if !ok {
+			version = new(models.CertificateVersion)
+			if err = version.FindByFingerprint(previous); err != nil {
+				return err
+			}
+			versions[previous] = version
+		}
+		if version.Id == 0 {
+			errs = append(errs, fmt.Sprintf("加速域名[%s]部署前的证书%s不是本系统保存的证书，不能恢复", domain, previous))
+			continue
+		}
+		certName := aliyunCertName(d.product.certNamePrefix, models.Certificate{Domain: version.Domain, Fingerprint: previous})
+		if err = client.setCertificate(domain, certName, version.Certificate, string(version.PrivateKey)); err != nil {
+			errs = append(errs, fmt.Sprintf("加速域名[%s]恢复证书失败：%s", domain, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "；"))
+	}
+
+	return nil
+}
+
+// 需要部署的加速域名，指定的加速域名不存在或与证书域名不匹配时记为失败
+func (d *aliyunCDNDeployer) matchDomains(client *aliyunCDNClient, config *AliyunCDNConfig, certDomains []string) (domains, output []string, failed int, err error) {
+	list, err := client.describeDomains()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("查询%s加速域名失败：%s", d.product.name, err)
+	}
+	statuses := make(map[string]string, len(list))
+	for _, item := range list {
+		statuses[normalizeDomain(item.DomainName)] = item.DomainStatus
+	}
+	candidates := config.Domains
+	if len(candidates) == 0 {
+		for _, item := range list {
+			if domain := normalizeDomain(item.DomainName); matchCertificateDomain(certDomains, domain) {
+				candidates = append(candidates, domain)
+			}
+		}
+	}
+	for _, domain := range candidates {
+		status, ok := statuses[domain]
+		switch {
+		case !ok:
+			output = append(output, fmt.Sprintf("加速域名[%s]部署失败：%s加速域名不存在", domain, d.product.name))
+			failed++
+		case !matchCertificateDomain(certDomains, domain):
+			output = append(output, fmt.Sprintf("加速域名[%s]部署失败：与证书域名不匹配", domain))
+			failed++
+		case status != "online" && status != "offline":
+			// 配置中、审核中等状态不能修改证书
+			output = append(output, fmt.Sprintf("加速域名[%s]状态为%s，跳过", domain, status))
+		default:
+			domains = append(domains, domain)
+		}
+	}
+
+	return domains, output, failed, nil
+}
+
+// 证书包含的全部域名
+func certificateDomains(certificate models.Certificate) []string {
+	domains := make([]string, 0)
+	for _, domain := range append(strings.Fields(certificate.Domains), certificate.Domain) {
+		if domain = normalizeDomain(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+
+	return domains
+}
+
+// 域名是否在证书域名中，泛域名只匹配一级子域名，*.a.com匹配b.a.com，不匹配a.com、c.b.a.com
+func matchCertificateDomain(certDomains []string, domain string) bool {
+	for _, certDomain := range certDomains {
+		if certDomain == domain {
+			return true
+		}
+		if !strings.HasPrefix(certDomain, "*.") {
+			continue
+		}
+		i := strings.Index(domain, ".")
+		if i > 0 && domain[:i] != "*" && domain[i+1:] == certDomain[2:] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+func sortedDomains(domains map[string]string) []string {
+	list := make([]string, 0, len(domains))
+	for domain := range domains {
+		list = append(list, domain)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// PEM中第一个证书的SHA-256指纹，与Certificate.Fingerprint一致
+func pemFingerprint(data string) string {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// 调用CDN、DCDN的API
+type aliyunCDNClient struct {
+	*aliyunAPI
+	product *aliyunCDNProduct
+}
+
+type aliyunCDNDomain struct {
+	DomainName   string
+	DomainStatus string
+}
+
+type aliyunCDNDomainsResponse struct {
+	Domains struct {
+		PageData []aliyunCDNDomain
+	}
+	TotalCount int
+}
+
+// 加速域名的证书，CDN返回ServerCertificate、ServerCertificateStatus，DCDN返回SSLPub、SSLProtocol
+type aliyunCDNCertificateInfo struct {
+	DomainName              string
+	ServerCertificate       string
+	ServerCertificateStatus string
+	SSLPub                  string
+	SSLProtocol             string
+}
+
+type aliyunCDNCertificateResponse struct {
+	CertInfos struct {
+		CertInfo []aliyunCDNCertificateInfo
+	}
+}
+
+// 加速域名使用的证书指纹，未开启HTTPS时为空
+func (info *aliyunCDNCertificateInfo) fingerprint() string {
+	if info == nil || (info.ServerCertificateStatus != "on" && info.SSLProtocol != "on") {
+		return ""
+	}
+	if info.ServerCertificate != "" {
+		return pemFingerprint(info.ServerCertificate)
+	}
+
+	return pemFingerprint(info.SSLPub)
+}
+
+// 全部加速域名
+func (c *aliyunCDNClient) describeDomains() ([]aliyunCDNDomain, error) {
+	domains := make([]aliyunCDNDomain, 0)
+	for page := 1; ; page++ {
+		response := new(aliyunCDNDomainsResponse)
+		params := map[string]string{
+			"PageSize":   strconv.Itoa(aliyunCDNPageSize),
+			"PageNumber": strconv.Itoa(page),
+		}
+		if err := c.call(c.product.describeDomainsAction, params, nil, response); err != nil {
+			return nil, err
+		}
+		domains = append(domains, response.Domains.PageData...)
+		if len(response.Domains.PageData) == 0 || len(domains) >= response.TotalCount {
+			return domains, nil
+		}
+	}
+}
+
+// 加速域名的证书，没有证书时返回nil
+func (c *aliyunCDNClient) describeCertificate(domain string) (*aliyunCDNCertificateInfo, error) {
+	response := new(aliyunCDNCertificateResponse)
+	if err := c.call(c.product.describeCertificateAction, map[string]string{"DomainName": domain}, nil, response); err != nil {
+		return nil, err
+	}
+	for i, info := range response.CertInfos.CertInfo {
+		if normalizeDomain(info.DomainName) == domain {
+			return &response.CertInfos.CertInfo[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// 上传证书并开启HTTPS
+func (c *aliyunCDNClient) setCertificate(domain, certName, certificate, privateKey string) error {
+	params := map[string]string{
+		"DomainName":          domain,
+		"CertName":            certName,
+		"CertType":            "upload",
+		c.product.statusParam: "on",
+	}
+	if c.product.forceSet {
+		params["ForceSet"] = "1"
+	}
+	form := map[string]string{
+		c.product.certificateParam: certificate,
+		c.product.privateKeyParam:  privateKey,
+	}
+
+	return c.call(c.product.setCertificateAction, params, form, nil)
+}
+
+// 关闭HTTPS
+func (c *aliyunCDNClient) disableCertificate(domain string) error {
+	params := map[string]string{
+		"DomainName":          domain,
+		c.product.statusParam: "off",
+	}
+
+	return c.call(c.product.setCertificateAction, params, nil, nil)
+}
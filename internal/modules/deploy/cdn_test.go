@@ -0,0 +1,285 @@
+package deploy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 本地模拟的CDN、DCDN API
+type testAliyunCDNServer struct {
+	product *aliyunCDNProduct
+	// 加速域名及其状态
+	domains map[string]string
+	// 加速域名使用的证书，没有时未开启HTTPS
+	certs map[string]string
+	// 设置证书失败的加速域名
+	failed map[string]bool
+	// 设置证书成功但不生效，用于验证失败
+	ignoreSet bool
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func (s *testAliyunCDNServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action := r.Form.Get("Action")
+	domain := r.Form.Get("DomainName")
+	s.actions = append(s.actions, strings.TrimSpace(action+" "+domain))
+
+	var response interface{}
+	switch action {
+	case s.product.describeDomainsAction:
+		data := make([]aliyunCDNDomain, 0, len(s.domains))
+		for _, name := range sortedDomains(s.domains) {
+			data = append(data, aliyunCDNDomain{DomainName: name, DomainStatus: s.domains[name]})
+		}
+		resp := new(aliyunCDNDomainsResponse)
+		resp.Domains.PageData = data
+		resp.TotalCount = len(data)
+		response = resp
+	case s.product.describeCertificateAction:
+		resp := new(aliyunCDNCertificateResponse)
+		info := aliyunCDNCertificateInfo{DomainName: domain, ServerCertificateStatus: "off", SSLProtocol: "off"}
+		if cert, ok := s.certs[domain]; ok {
+			info.ServerCertificateStatus, info.SSLProtocol = "on", "on"
+			info.ServerCertificate, info.SSLPub = cert, cert
+		}
+		resp.CertInfos.CertInfo = append(resp.CertInfos.CertInfo, info)
+		response = resp
+	case s.product.setCertificateAction:
+		if s.failed[domain] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, `{"RequestId":"test","Code":"InvalidParameter","Message":"set certificate failed: %s"}`, domain)
+			return
+		}
+		if r.Form.Get(s.product.statusParam) == "off" {
+			delete(s.certs, domain)
+		} else if !s.ignoreSet {
+			if r.Form.Get(s.product.privateKeyParam) == "" {
+				http.Error(w, `{"Code":"MissingPrivateKey","Message":"private key is required"}`, http.StatusBadRequest)
+				return
+			}
+			s.certs[domain] = r.Form.Get(s.product.certificateParam)
+		}
+		response = map[string]string{"RequestId": "test"}
+	default:
+		http.Error(w, `{"Code":"InvalidAction","Message":"unknown action"}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newTestAliyunCDNTarget(t *testing.T, targetType string, server *httptest.Server, domains ...string) *Target {
+	data, err := json.Marshal(AliyunCDNConfig{Domains: domains, Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &Target{}
+	target.Name = "cdn"
+	target.Type = targetType
+	target.Config = string(data)
+	target.Status = models.Enabled
+	target.AccessKey.Id = 1
+	target.AccessKey.AccessKeyId = "test"
+	target.AccessKey.AccessKeySecret = "secret"
+
+	return target
+}
+
+// 自签名证书
+func newTestCertificate(t *testing.T, domains ...string) models.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := models.Certificate{
+		Id:          1,
+		Domain:      domains[0],
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:  models.Secret(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+		Status:      models.CertificateStatusActive,
+	}
+	if err = certificate.ParseMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func TestMatchCertificateDomain(t *testing.T) {
+	certDomains := []string{"*.a.com", "a.com", "b.com"}
+	matched := []string{"a.com", "x.a.com", "b.com"}
+	for _, domain := range matched {
+		if !matchCertificateDomain(certDomains, domain) {
+			t.Fatalf("域名应匹配-%s", domain)
+		}
+	}
+	unmatched := []string{"x.y.a.com", "x.b.com", "c.com", "*.b.com", ".a.com"}
+	for _, domain := range unmatched {
+		if matchCertificateDomain(certDomains, domain) {
+			t.Fatalf("域名不应匹配-%s", domain)
+		}
+	}
+	if !matchCertificateDomain([]string{"*.a.com"}, "*.a.com") {
+		t.Fatal("泛域名的加速域名应匹配相同的泛域名证书")
+	}
+}
+
+func TestAliyunCDNDeploy(t *testing.T) {
+	for targetType, product := range map[string]*aliyunCDNProduct{TypeAliyunCDN: aliyunCDN, TypeAliyunDCDN: aliyunDCDN} {
+		old := newTestCertificate(t, "a.com", "*.a.com")
+		stub := &testAliyunCDNServer{
+			product: product,
+			domains: map[string]string{
+				"a.com":       "online",
+				"www.a.com":   "online",
+				"img.a.com":   "offline",
+				"x.y.a.com":   "online",
+				"b.com":       "online",
+				"new.a.com":   "configuring",
+				"error.a.com": "online",
+			},
+			certs:  map[string]string{"www.a.com": old.Certificate},
+			failed: map[string]bool{"error.a.com": true},
+		}
+		server := httptest.NewServer(stub)
+
+		certificate := newTestCertificate(t, "a.com", "*.a.com")
+		target := newTestAliyunCDNTarget(t, targetType, server)
+		result, err := Run(target, certificate)
+		if err == nil || !strings.Contains(err.Error(), "1个") {
+			t.Fatalf("%s: 部分加速域名失败时应返回错误, 实际%v", targetType, err)
+		}
+		for _, domain := range []string{"a.com", "www.a.com", "img.a.com"} {
+			if stub.certs[domain] != certificate.Certificate {
+				t.Fatalf("%s: 加速域名[%s]未部署证书", targetType, domain)
+			}
+			if !strings.Contains(result.Output, fmt.Sprintf("加速域名[%s]部署成功", domain)) {
+				t.Fatalf("%s: 输出缺少加速域名[%s]的结果: %s", targetType, domain, result.Output)
+			}
+		}
+		for _, domain := range []string{"x.y.a.com", "b.com", "new.a.com"} {
+			if _, ok := stub.certs[domain]; ok {
+				t.Fatalf("%s: 加速域名[%s]不应部署证书", targetType, domain)
+			}
+		}
+		if !strings.Contains(result.Output, "加速域名[error.a.com]部署失败") || !strings.Contains(result.Output, "加速域名[new.a.com]状态为configuring，跳过") {
+			t.Fatalf("%s: 输出缺少失败、跳过的加速域名: %s", targetType, result.Output)
+		}
+
+		state := new(aliyunCDNState)
+		if err = result.DecodeState(state); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{"a.com": "", "img.a.com": "", "www.a.com": old.Fingerprint}
+		if len(state.Domains) != len(expected) {
+			t.Fatalf("%s: 部署前的状态错误: %v", targetType, state.Domains)
+		}
+		for domain, fingerprint := range expected {
+			if state.Domains[domain] != fingerprint {
+				t.Fatalf("%s: 加速域名[%s]部署前的证书错误: %s", targetType, domain, state.Domains[domain])
+			}
+		}
+
+		// 再次部署同一证书时跳过已使用该证书的加速域名
+		stub.actions = nil
+		target = newTestAliyunCDNTarget(t, targetType, server, "a.com")
+		result, err = Run(target, certificate)
+		if err != nil {
+			t.Fatalf("%s: %s", targetType, err)
+		}
+		if !strings.Contains(result.Output, "加速域名[a.com]已使用该证书，跳过") {
+			t.Fatalf("%s: 输出错误: %s", targetType, result.Output)
+		}
+		for _, action := range stub.actions {
+			if strings.HasPrefix(action, product.setCertificateAction) {
+				t.Fatalf("%s: 不应重复设置证书: %v", targetType, stub.actions)
+			}
+		}
+		server.Close()
+	}
+}
+
+func TestAliyunCDNDeployUnmatchedDomain(t *testing.T) {
+	stub := &testAliyunCDNServer{
+		product: aliyunCDN,
+		domains: map[string]string{"a.com": "online", "b.com": "online"},
+		certs:   map[string]string{},
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	certificate := newTestCertificate(t, "a.com")
+	result, err := Run(newTestAliyunCDNTarget(t, TypeAliyunCDN, server, "b.com", "c.com"), certificate)
+	if err == nil {
+		t.Fatal("指定的加速域名不匹配时应返回错误")
+	}
+	if !strings.Contains(result.Output, "加速域名[b.com]部署失败：与证书域名不匹配") || !strings.Contains(result.Output, "加速域名[c.com]部署失败：CDN加速域名不存在") {
+		t.Fatalf("输出错误: %s", result.Output)
+	}
+	if len(stub.certs) > 0 {
+		t.Fatalf("不应部署证书: %v", stub.certs)
+	}
+
+	if _, err = Run(newTestAliyunCDNTarget(t, TypeAliyunCDN, server), newTestCertificate(t, "c.com")); err == nil {
+		t.Fatal("没有匹配的加速域名时应返回错误")
+	}
+}
+
+// 设置证书后加速域名仍未使用部署的证书时回滚，部署前未开启HTTPS的加速域名关闭HTTPS
+func TestAliyunCDNVerifyFailedRollback(t *testing.T) {
+	stub := &testAliyunCDNServer{
+		product:   aliyunDCDN,
+		domains:   map[string]string{"a.com": "online"},
+		certs:     map[string]string{},
+		ignoreSet: true,
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	_, err := Run(newTestAliyunCDNTarget(t, TypeAliyunDCDN, server), newTestCertificate(t, "a.com"))
+	if _, ok := err.(*RolledBackError); !ok {
+		t.Fatalf("验证失败时应回滚, 实际%v", err)
+	}
+	last := stub.actions[len(stub.actions)-1]
+	if last != aliyunDCDN.setCertificateAction+" a.com" {
+		t.Fatalf("回滚时应关闭HTTPS: %v", stub.actions)
+	}
+}
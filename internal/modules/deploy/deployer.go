@@ -0,0 +1,176 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 证书部署，每种部署目标类型实现一个Deployer，通过Register注册
+type Deployer interface {
+	// 校验部署目标的配置
+	Validate(target *Target) error
+	// 部署证书，返回的Result.State保存部署前的状态，回滚时使用
+	Deploy(target *Target, certificate models.Certificate) (*Result, error)
+	// 验证部署目标使用的是部署的证书
+	Verify(target *Target, certificate models.Certificate, result *Result) error
+	// 恢复到部署前的状态
+	Rollback(target *Target, result *Result) error
+}
+
+// 部署验证成功后清理不再使用的资源，如：旧证书，Deployer可选实现
+// 清理失败不影响部署结果
+type Cleaner interface {
+	Cleanup(target *Target, certificate models.Certificate, result *Result) (output string, err error)
+}
+
+// 部署目标及其使用的AccessKey
+type Target struct {
+	models.DeployTarget
+	AccessKey models.AccessKey
+}
+
+// 部署结果
+type Result struct {
+	// 部署过程的输出，记录到任务日志
+	Output string
+	// 部署前的状态，json格式，由Deployer定义
+	State string
+}
+
+var (
+	deployers     = make(map[string]Deployer)
+	deployersLock sync.RWMutex
+)
+
+// 注册部署类型，类型重复时panic
+func Register(targetType string, deployer Deployer) {
+	deployersLock.Lock()
+	defer deployersLock.Unlock()
+	if _, ok := deployers[targetType]; ok {
+		panic(fmt.Sprintf("部署类型重复注册：%s", targetType))
+	}
+	deployers[targetType] = deployer
+}
+
+// 获取部署类型的Deployer
+func Get(targetType string) (Deployer, error) {
+	deployersLock.RLock()
+	defer deployersLock.RUnlock()
+	deployer, ok := deployers[targetType]
+	if !ok {
+		return nil, fmt.Errorf("不支持的部署类型：%s", targetType)
+	}
+
+	return deployer, nil
+}
+
+// 已注册的部署类型
+func Types() []string {
+	deployersLock.RLock()
+	defer deployersLock.RUnlock()
+	types := make([]string, 0, len(deployers))
+	for targetType := range deployers {
+		types = append(types, targetType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// 校验部署目标的配置
+func Validate(target *Target) error {
+	deployer, err := Get(target.Type)
+	if err != nil {
+		return err
+	}
+
+	return deployer.Validate(target)
+}
+
+// 部署证书：校验配置、部署、验证，验证失败时回滚，验证成功后清理
+// 返回的Result不为nil时需要保存State，用于手动回滚
+func Run(target *Target, certificate models.Certificate) (*Result, error) {
+	deployer, err := Get(target.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err = deployer.Validate(target); err != nil {
+		return nil, fmt.Errorf("部署配置错误：%s", err)
+	}
+	if !certificate.IsDeployable() {
+		return nil, fmt.Errorf("证书状态为%s，不能部署#证书ID-%d", certificate.Status, certificate.Id)
+	}
+	if !certificate.HasPrivateKey() {
+		return nil, fmt.Errorf("证书没有私钥，不能部署#证书ID-%d", certificate.Id)
+	}
+
+	result, err := deployer.Deploy(target, certificate)
+	if err != nil {
+		return result, err
+	}
+	if err = deployer.Verify(target, certificate, result); err != nil {
+		if rollbackErr := deployer.Rollback(target, result); rollbackErr != nil {
+			return result, fmt.Errorf("部署验证失败：%s，回滚失败：%s", err, rollbackErr)
+		}
+		return result, &RolledBackError{Err: err}
+	}
+	if cleaner, ok := deployer.(Cleaner); ok {
+		output, err := cleaner.Cleanup(target, certificate, result)
+		if err != nil {
+			output = strings.TrimSpace(output + "\n清理失败：" + err.Error())
+		}
+		if output != "" {
+			result.Output = strings.TrimSpace(result.Output + "\n" + output)
+		}
+	}
+
+	return result, nil
+}
+
+// 恢复到部署前的状态，state为部署时保存的Result.State
+func Rollback(target *Target, state string) error {
+	if state == "" {
+		return errors.New("没有部署记录，不能回滚")
+	}
+	deployer, err := Get(target.Type)
+	if err != nil {
+		return err
+	}
+
+	return deployer.Rollback(target, &Result{State: state})
+}
+
+// 部署验证失败，已回滚
+type RolledBackError struct {
+	Err error
+}
+
+func (e *RolledBackError) Error() string {
+	return fmt.Sprintf("部署验证失败，已回滚：%s", e.Err)
+}
+
+// 解析部署前的状态
+func (r *Result) DecodeState(v interface{}) error {
+	if r == nil || r.State == "" {
+		return errors.New("没有部署前的状态")
+	}
+
+	return json.Unmarshal([]byte(r.State), v)
+}
+
+// 保存部署前的状态
+func (r *Result) EncodeState(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.State = string(data)
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/app"
+	rpcClient "github.com/ouqiang/gocron/internal/modules/rpc/client"
+	pb "github.com/ouqiang/gocron/internal/modules/rpc/proto"
+)
+
+// gocron-node主机
+const TypeNode = "node"
+
+// 检查命令、重载命令默认超时时间，单位秒
+const defaultNodeCommandTimeout = 60
+
+func init() {
+	Register(TypeNode, new(nodeDeployer))
+}
+
+// gocron-node主机的部署配置，证书、私钥、证书链写入指定路径后执行检查命令、重载命令
+// 检查或重载失败时节点恢复原文件
+// 私钥通过gRPC发送到节点，未开启TLS(enable_tls)时连接不加密，不能部署到节点
+type NodeConfig struct {
+	HostIds []int `json:"host_ids"`
+	// 证书路径，内容与保存的证书一致，申请时bundle的证书包含中间证书
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+	// 证书链路径，为空时不写入
+	ChainPath string `json:"chain_path"`
+	// 文件权限，八进制，如：0644，为空时保留原文件权限，新文件使用0600
+	CertMode string `json:"cert_mode"`
+	KeyMode  string `json:"key_mode"`
+	// 所有者，格式：user或user:group，为空时保留原文件所有者
+	Owner string `json:"owner"`
+	// 检查命令，如：nginx -t
+	ValidateCommand string `json:"validate_command"`
+	// 重载命令，如：nginx -s reload
+	ReloadCommand string `json:"reload_command"`
+	// 命令超时时间，单位秒，默认60
+	Timeout int `json:"timeout"`
+}
+
+// 部署前的证书版本，回滚时重新部署该版本
+type nodeState struct {
+	CertificateId int `json:"certificate_id"`
+	VersionId     int `json:"version_id"`
+}
+
+type nodeDeployer struct{}
+
+func (d *nodeDeployer) config(target *Target) (*NodeConfig, error) {
+	config := new(NodeConfig)
+	if err := target.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultNodeCommandTimeout
+	}
+
+	return config, nil
+}
+
+func (d *nodeDeployer) Validate(target *Target) error {
+	if err := checkNodeTLS(); err != nil {
+		return err
+	}
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	if len(config.HostIds) == 0 {
+		return errors.New("需要选择主机")
+	}
+	if config.CertPath == "" || config.KeyPath == "" {
+		return errors.New("cert_path、key_path不能为空")
+	}
+	for _, path := range []string{config.CertPath, config.KeyPath, config.ChainPath} {
+		if path != "" && !strings.HasPrefix(filepath.ToSlash(path), "/") && !filepath.IsAbs(path) {
+			return fmt.Errorf("文件路径必须是绝对路径：%s", path)
+		}
+	}
+	for _, mode := range []string{config.CertMode, config.KeyMode} {
+		if _, err = parseFileMode(mode); err != nil {
+			return err
+		}
+	}
+	hosts, err := d.hosts(config)
+	if err != nil {
+		return err
+	}
+	if len(hosts) != len(config.HostIds) {
+		return errors.New("选择的主机不存在")
+	}
+
+	return nil
+}
+
+func (d *nodeDeployer) hosts(config *NodeConfig) ([]models.Host, error) {
+	hostModel := new(models.Host)
+
+	return hostModel.ListByIds(config.HostIds)
+}
+
+// 依次部署到每个主机，某个主机失败时已部署的主机重新部署上一个版本
+func (d *nodeDeployer) Deploy(target *Target, certificate models.Certificate) (*Result, error) {
+	config, err := d.config(target)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := d.hosts(config)
+	if err != nil {
+		return nil, err
+	}
+	previous := new(models.CertificateVersion)
+	if err = previous.FindPrevious(certificate.Id, certificate.Fingerprint); err != nil {
+		return nil, err
+	}
+	result := new(Result)
+	if err = result.EncodeState(nodeState{CertificateId: certificate.Id, VersionId: previous.Id}); err != nil {
+		return nil, err
+	}
+
+	req, err := d.request(config, certificate.Certificate, certificate.IssuerCertificate, string(certificate.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	req.Id = int64(certificate.Id)
+	output := make([]string, 0, len(hosts))
+	for i, host := range hosts {
+		out, rolledBack, err := rpcClient.DeployCertificate(host.Name, host.Port, req)
+		if err == nil {
+			output = append(output, fmt.Sprintf("主机[%s-%s:%d]部署成功%s", host.Alias, host.Name, host.Port, formatNodeOutput(out)))
+			continue
+		}
+		message := fmt.Sprintf("主机[%s-%s:%d]部署失败：%s", host.Alias, host.Name, host.Port, err)
+		if rolledBack {
+			message += "，已恢复原文件"
+		}
+		output = append(output, message+formatNodeOutput(out))
+		if i > 0 && previous.Id > 0 {
+			if rollbackErr := d.deployVersion(config, hosts[:i], previous); rollbackErr != nil {
+				output = append(output, "已部署的主机回滚失败："+rollbackErr.Error())
+			} else {
+				output = append(output, fmt.Sprintf("已部署的主机回滚到版本%d", previous.Version))
+			}
+		}
+		result.Output = strings.Join(output, "\n")
+		return result, errors.New(message)
+	}
+	result.Output = strings.Join(output, "\n")
+
+	return result, nil
+}
+
+// 节点写入文件后已执行检查命令、重载命令，不需要再次验证
+func (d *nodeDeployer) Verify(target *Target, certificate models.Certificate, result *Result) error {
+	return nil
+}
+
+// 重新部署部署前的证书版本
+func (d *nodeDeployer) Rollback(target *Target, result *Result) error {
+	state := new(nodeState)
+	if err := result.DecodeState(state); err != nil {
+		return err
+	}
+	if state.VersionId == 0 {
+		return errors.New("证书没有上一个版本，不能回滚")
+	}
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	hosts, err := d.hosts(config)
+	if err != nil {
+		return err
+	}
+	version := new(models.CertificateVersion)
+	if err = version.Find(state.VersionId); err != nil || version.Id == 0 {
+		return fmt.Errorf("证书版本不存在#ID-%d#%v", state.VersionId, err)
+	}
+
+	return d.deployVersion(config, hosts, version)
+}
+
+func (d *nodeDeployer) deployVersion(config *NodeConfig, hosts []models.Host, version *models.CertificateVersion) error {
+	req, err := d.request(config, version.Certificate, version.IssuerCertificate, string(version.PrivateKey))
+	if err != nil {
+		return err
+	}
+	req.Id = int64(version.CertificateId)
+	for _, host := range hosts {
+		if _, _, err = rpcClient.DeployCertificate(host.Name, host.Port, req); err != nil {
+			return fmt.Errorf("主机[%s-%s:%d]#%s", host.Alias, host.Name, host.Port, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *nodeDeployer) request(config *NodeConfig, certPEM, chainPEM, keyPEM string) (*pb.CertificateRequest, error) {
+	if err := checkNodeTLS(); err != nil {
+		return nil, err
+	}
+	certMode, err := parseFileMode(config.CertMode)
+	if err != nil {
+		return nil, err
+	}
+	keyMode, err := parseFileMode(config.KeyMode)
+	if err != nil {
+		return nil, err
+	}
+	req := &pb.CertificateRequest{
+		Files: []*pb.CertificateFile{
+			{Path: config.CertPath, Content: []byte(certPEM), Mode: certMode, Owner: config.Owner},
+			{Path: config.KeyPath, Content: []byte(keyPEM), Mode: keyMode, Owner: config.Owner},
+		},
+		ValidateCommand: config.ValidateCommand,
+		ReloadCommand:   config.ReloadCommand,
+		Timeout:         int32(config.Timeout),
+	}
+	if config.ChainPath != "" {
+		if strings.TrimSpace(chainPEM) == "" {
+			return nil, errors.New("证书没有中间证书，不能写入chain_path")
+		}
+		req.Files = append(req.Files, &pb.CertificateFile{Path: config.ChainPath, Content: []byte(chainPEM), Mode: certMode, Owner: config.Owner})
+	}
+
+	return req, nil
+}
+
+// 与节点的连接未开启TLS时私钥会明文传输
+func checkNodeTLS() error {
+	if app.Setting == nil || !app.Setting.EnableTLS {
+		return errors.New("未开启TLS(enable_tls)，私钥会明文传输，不能部署到gocron-node主机")
+	}
+
+	return nil
+}
+
+// 解析八进制的文件权限，为空时返回0
+func parseFileMode(mode string) (uint32, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("文件权限无效：%s", mode)
+	}
+
+	return uint32(value), nil
+}
+
+func formatNodeOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return ""
+	}
+
+	return "\n" + output
+}
@@ -0,0 +1,547 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 阿里云SLB
+const TypeAliyunSLB = "aliyun_slb"
+
+func init() {
+	Register(TypeAliyunSLB, new(aliyunSLBDeployer))
+}
+
+// 阿里云SLB的部署配置
+type AliyunSLBConfig struct {
+	// regionId 区域，如：cn-hangzhou
+	RegionId string `json:"region_id"`
+	// SLB实例id
+	LoadBalancerId string `json:"load_balancer_id"`
+	// 监听端口，默认443
+	ListenerPort int `json:"listener_port"`
+	// 多个监听端口，不为空时忽略listener_port
+	ListenerPorts []int `json:"listener_ports"`
+	// 后端端口，新建监听时使用，默认9080
+	BackendServerPort int `json:"backend_server_port"`
+	// 作为扩展证书部署到监听的扩展域名(SNI)，不替换监听的默认证书，监听需要已存在
+	Extension bool `json:"extension"`
+	// 扩展域名，为空时使用证书的全部域名
+	ExtensionDomains []string `json:"extension_domains"`
+	// 部署成功后删除本系统上传的、不再被任何监听引用的同域名旧证书，部署前使用的证书保留用于回滚
+	Cleanup bool `json:"cleanup"`
+}
+
+// 部署的监听端口
+func (c *AliyunSLBConfig) ports() []int {
+	if len(c.ListenerPorts) > 0 {
+		return c.ListenerPorts
+	}
+
+	return []int{c.ListenerPort}
+}
+
+// 部署的证书，以及部署前监听、扩展域名使用的证书
+type aliyunSLBState struct {
+	ServerCertificateId string                    `json:"server_certificate_id"`
+	Listeners           []aliyunSLBListenerState  `json:"listeners"`
+	Extensions          []aliyunSLBExtensionState `json:"extensions"`
+}
+
+type aliyunSLBListenerState struct {
+	Port int `json:"port"`
+	// 部署前的监听状态，为空时监听是部署时新建的
+	Status                      string `json:"status"`
+	PreviousServerCertificateId string `json:"previous_server_certificate_id"`
+}
+
+type aliyunSLBExtensionState struct {
+	Port              int    `json:"port"`
+	Domain            string `json:"domain"`
+	DomainExtensionId string `json:"domain_extension_id"`
+	// 为空时扩展域名是部署时新增的
+	PreviousServerCertificateId string `json:"previous_server_certificate_id"`
+}
+
+// 旧版本的SLB配置转换为部署目标，任务参数指定了aliyun_slb_id时使用
+func NewAliyunSLBTarget(aslb models.AliyunSLB, ak models.AccessKey) (*Target, error) {
+	data, err := json.Marshal(AliyunSLBConfig{
+		RegionId:          aslb.RegionId,
+		LoadBalancerId:    aslb.LoadBalancerId,
+		ListenerPort:      aslb.ListenerPort,
+		BackendServerPort: aslb.BackendServerPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+	target := &Target{AccessKey: ak}
+	target.Name = fmt.Sprintf("SLB-%s#端口-%d", aslb.LoadBalancerId, aslb.ListenerPort)
+	target.Type = TypeAliyunSLB
+	target.Config = string(data)
+	target.AccessKeyId = ak.Id
+	target.Status = models.Enabled
+
+	return target, nil
+}
+
+// SLB的接口，部署逻辑只依赖此接口，测试时使用内存中的实现
+type aliyunSLBAPI interface {
+	describeServerCertificates() ([]aliyunSLBServerCertificate, error)
+	// 上传证书，返回证书ID
+	uploadServerCertificate(name, certificate, privateKey string) (string, error)
+	deleteServerCertificate(serverCertificateId string) error
+	// HTTPS监听的状态和使用的证书ID，监听不存在时状态为空
+	describeHTTPSListener(loadBalancerId string, port int) (status, serverCertificateId string, err error)
+	createHTTPSListener(loadBalancerId string, port, backendServerPort int, serverCertificateId string) error
+	setHTTPSListenerCertificate(loadBalancerId string, port int, serverCertificateId string) error
+	startListener(loadBalancerId string, port int) error
+	describeDomainExtensions(loadBalancerId string, port int) ([]aliyunSLBDomainExtension, error)
+	// 添加扩展域名，返回扩展域名ID
+	addDomainExtension(loadBalancerId string, port int, domain, serverCertificateId string) (string, error)
+	setDomainExtensionCertificate(domainExtensionId, serverCertificateId string) error
+	deleteDomainExtension(domainExtensionId string) error
+	// 区域内全部SLB实例ID
+	describeLoadBalancers() ([]string, error)
+	describeHTTPSListenerPorts(loadBalancerId string) ([]int, error)
+}
+
+// SLB上的证书
+type aliyunSLBServerCertificate struct {
+	ServerCertificateId   string
+	ServerCertificateName string
+	// 过期时间，毫秒
+	ExpireTimeStamp         int64
+	SubjectAlternativeNames []string
+}
+
+// 监听的扩展域名
+type aliyunSLBDomainExtension struct {
+	DomainExtensionId   string
+	Domain              string
+	ServerCertificateId string
+}
+
+type aliyunSLBDeployer struct{}
+
+func (d *aliyunSLBDeployer) config(target *Target) (*AliyunSLBConfig, error) {
+	config := new(AliyunSLBConfig)
+	if err := target.DecodeConfig(config); err != nil {
+		return nil, err
+	}
+	if config.ListenerPort == 0 {
+		config.ListenerPort = 443
+	}
+	if config.BackendServerPort == 0 {
+		config.BackendServerPort = 9080
+	}
+	domains := make([]string, 0, len(config.ExtensionDomains))
+	for _, domain := range config.ExtensionDomains {
+		if domain = normalizeDomain(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	config.ExtensionDomains = domains
+
+	return config, nil
+}
+
+func (d *aliyunSLBDeployer) client(target *Target, config *AliyunSLBConfig) (aliyunSLBAPI, error) {
+	return newAliyunSLBAPI(config.RegionId, target.AccessKey)
+}
+
+func (d *aliyunSLBDeployer) Validate(target *Target) error {
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	if config.RegionId == "" || config.LoadBalancerId == "" {
+		return errors.New("region_id、load_balancer_id不能为空")
+	}
+	ports := make(map[int]bool)
+	for _, port := range config.ports() {
+		if port < 1 || port > 65535 || ports[port] {
+			return fmt.Errorf("监听端口无效或重复：%d", port)
+		}
+		ports[port] = true
+	}
+	if config.BackendServerPort < 1 || config.BackendServerPort > 65535 {
+		return errors.New("后端端口无效")
+	}
+	if target.AccessKey.Id == 0 {
+		return errors.New("需要选择AccessKey")
+	}
+
+	return nil
+}
+
+// 部署到每个监听：默认证书模式新建或修改监听的证书并启动监听，扩展证书模式新增或修改扩展域名的证书
+// 调用方已决定部署该证书，监听或扩展域名使用其他证书时都替换；失败时返回已部署部分的状态，可以手动回滚
+func (d *aliyunSLBDeployer) Deploy(target *Target, certificate models.Certificate) (*Result, error) {
+	config, err := d.config(target)
+	if err != nil {
+		return nil, err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return nil, err
+	}
+	domains := certificateDomains(certificate)
+	if config.Extension && len(config.ExtensionDomains) > 0 {
+		for _, domain := range config.ExtensionDomains {
+			if !matchCertificateDomain(domains, domain) {
+				return nil, fmt.Errorf("扩展域名%s与证书域名不匹配", domain)
+			}
+		}
+		domains = config.ExtensionDomains
+	}
+	cs, err := client.describeServerCertificates()
+	if err != nil {
+		return nil, err
+	}
+	deployment := &aliyunSLBDeployment{
+		client:      client,
+		config:      config,
+		certificate: certificate,
+		state: aliyunSLBState{
+			Listeners:  make([]aliyunSLBListenerState, 0),
+			Extensions: make([]aliyunSLBExtensionState, 0),
+		},
+		result: new(Result),
+	}
+	certName := aliyunCertName(aliyunSLBCertNamePrefix, certificate)
+	for _, c := range cs {
+		// 同一证书已上传过时不再重复上传
+		if c.ServerCertificateName == certName && deployment.state.ServerCertificateId == "" {
+			deployment.state.ServerCertificateId = c.ServerCertificateId
+		}
+	}
+
+	for _, port := range config.ports() {
+		if config.Extension {
+			err = deployment.deployExtensions(port, domains)
+		} else {
+			err = deployment.deployListener(port)
+		}
+		if err != nil {
+			return deployment.finish(err)
+		}
+	}
+
+	return deployment.finish(nil)
+}
+
+// 监听、扩展域名使用的证书与部署的证书一致
+func (d *aliyunSLBDeployer) Verify(target *Target, certificate models.Certificate, result *Result) error {
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	state := new(aliyunSLBState)
+	if err = result.DecodeState(state); err != nil {
+		return err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return err
+	}
+	for _, listener := range state.Listeners {
+		status, serverCertificateId, err := client.describeHTTPSListener(config.LoadBalancerId, listener.Port)
+		if err != nil {
+			return err
+		}
+		if status == "" {
+			return fmt.Errorf("监听不存在#SLB-%s#端口-%d", config.LoadBalancerId, listener.Port)
+		}
+		if serverCertificateId != state.ServerCertificateId {
+			return fmt.Errorf("监听[%d]使用的证书%s与部署的证书%s不一致", listener.Port, serverCertificateId, state.ServerCertificateId)
+		}
+	}
+	extensions := make(map[int][]aliyunSLBDomainExtension)
+	for _, extension := range state.Extensions {
+		list, ok := extensions[extension.Port]
+		if !ok {
+			if list, err = client.describeDomainExtensions(config.LoadBalancerId, extension.Port); err != nil {
+				return err
+			}
+			extensions[extension.Port] = list
+		}
+		serverCertificateId := ""
+		for _, item := range list {
+			if item.DomainExtensionId == extension.DomainExtensionId {
+				serverCertificateId = item.ServerCertificateId
+				break
+			}
+		}
+		if serverCertificateId != state.ServerCertificateId {
+			return fmt.Errorf("监听[%d]扩展域名[%s]使用的证书%s与部署的证书%s不一致", extension.Port, extension.Domain, serverCertificateId, state.ServerCertificateId)
+		}
+	}
+
+	return nil
+}
+
+// 监听、扩展域名恢复使用部署前的证书，部署时新增的扩展域名删除，部署时新建的监听不处理
+func (d *aliyunSLBDeployer) Rollback(target *Target, result *Result) error {
+	config, err := d.config(target)
+	if err != nil {
+		return err
+	}
+	state := new(aliyunSLBState)
+	if err = result.DecodeState(state); err != nil {
+		return err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, listener := range state.Listeners {
+		previous := listener.PreviousServerCertificateId
+		if previous == "" || previous == state.ServerCertificateId {
+			continue
+		}
+		if err = client.setHTTPSListenerCertificate(config.LoadBalancerId, listener.Port, previous); err != nil {
+			errs = append(errs, fmt.Sprintf("监听[%d]恢复证书失败：%s", listener.Port, err))
+		}
+	}
+	for _, extension := range state.Extensions {
+		previous := extension.PreviousServerCertificateId
+		if previous == state.ServerCertificateId {
+			continue
+		}
+		if previous == "" {
+			err = client.deleteDomainExtension(extension.DomainExtensionId)
+		} else {
+			err = client.setDomainExtensionCertificate(extension.DomainExtensionId, previous)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("监听[%d]扩展域名[%s]恢复失败：%s", extension.Port, extension.Domain, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "；"))
+	}
+
+	return nil
+}
+
+// 删除本系统上传的同域名旧证书，部署的证书、部署前使用的证书、仍被区域内任一监听或扩展域名引用的证书不删除
+func (d *aliyunSLBDeployer) Cleanup(target *Target, certificate models.Certificate, result *Result) (string, error) {
+	config, err := d.config(target)
+	if err != nil || !config.Cleanup {
+		return "", err
+	}
+	state := new(aliyunSLBState)
+	if err = result.DecodeState(state); err != nil {
+		return "", err
+	}
+	client, err := d.client(target, config)
+	if err != nil {
+		return "", err
+	}
+	keep := map[string]bool{state.ServerCertificateId: true}
+	for _, listener := range state.Listeners {
+		keep[listener.PreviousServerCertificateId] = true
+	}
+	for _, extension := range state.Extensions {
+		keep[extension.PreviousServerCertificateId] = true
+	}
+	cs, err := client.describeServerCertificates()
+	if err != nil {
+		return "", err
+	}
+	candidates := make([]aliyunSLBServerCertificate, 0)
+	for _, c := range cs {
+		if !keep[c.ServerCertificateId] && isAliyunSLBCertName(c.ServerCertificateName, certificate) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	referenced, err := referencedServerCertificates(client)
+	if err != nil {
+		return "", err
+	}
+	output := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if referenced[c.ServerCertificateId] {
+			output = append(output, fmt.Sprintf("证书[%s-%s]仍被监听使用，保留", c.ServerCertificateName, c.ServerCertificateId))
+			continue
+		}
+		if err = client.deleteServerCertificate(c.ServerCertificateId); err != nil {
+			return strings.Join(output, "\n"), err
+		}
+		output = append(output, fmt.Sprintf("删除不再使用的证书[%s-%s]", c.ServerCertificateName, c.ServerCertificateId))
+	}
+
+	return strings.Join(output, "\n"), nil
+}
+
+// 一次部署过程，证书在第一次需要时上传
+type aliyunSLBDeployment struct {
+	client      aliyunSLBAPI
+	config      *AliyunSLBConfig
+	certificate models.Certificate
+	state       aliyunSLBState
+	output      []string
+	result      *Result
+}
+
+// 上传证书，已上传过时直接使用
+func (dp *aliyunSLBDeployment) serverCertificateId() (string, error) {
+	if dp.state.ServerCertificateId != "" {
+		return dp.state.ServerCertificateId, nil
+	}
+	serverCertificateId, err := dp.client.uploadServerCertificate(aliyunCertName(aliyunSLBCertNamePrefix, dp.certificate), dp.certificate.Certificate, string(dp.certificate.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+	dp.state.ServerCertificateId = serverCertificateId
+	dp.output = append(dp.output, fmt.Sprintf("上传证书成功#证书ID-%s", serverCertificateId))
+
+	return serverCertificateId, nil
+}
+
+// 部署为监听的默认证书，监听不存在时新建，监听未运行时启动
+func (dp *aliyunSLBDeployment) deployListener(port int) error {
+	loadBalancerId := dp.config.LoadBalancerId
+	// 监听状态定义参考 https://help.aliyun.com/document_detail/27607.html
+	status, previous, err := dp.client.describeHTTPSListener(loadBalancerId, port)
+	if err != nil {
+		return err
+	}
+	if status != "" && previous != "" && previous == dp.state.ServerCertificateId {
+		dp.state.Listeners = append(dp.state.Listeners, aliyunSLBListenerState{Port: port, Status: status, PreviousServerCertificateId: previous})
+		dp.output = append(dp.output, fmt.Sprintf("监听[%d]已使用该证书，跳过", port))
+		return nil
+	}
+	serverCertificateId, err := dp.serverCertificateId()
+	if err != nil {
+		return err
+	}
+	dp.state.Listeners = append(dp.state.Listeners, aliyunSLBListenerState{Port: port, Status: status, PreviousServerCertificateId: previous})
+	if status == "" {
+		// 监听不存在，就需要新建一个https监听
+		if err = dp.client.createHTTPSListener(loadBalancerId, port, dp.config.BackendServerPort, serverCertificateId); err != nil {
+			return err
+		}
+	} else {
+		// 监听存在就直接修改这个监听的证书id
+		if err = dp.client.setHTTPSListenerCertificate(loadBalancerId, port, serverCertificateId); err != nil {
+			return err
+		}
+	}
+
+	if status != "running" {
+		// 通过工单与官方沟通得知，更换slb的证书不用重启监听。这个消息非常棒！！！
+
+		// 启动监听
+		if err = dp.client.startListener(loadBalancerId, port); err != nil {
+			return err
+		}
+	}
+	dp.output = append(dp.output, fmt.Sprintf("证书部署到SLB成功#SLB-%s#端口-%d#证书ID-%s", loadBalancerId, port, serverCertificateId))
+
+	return nil
+}
+
+// 部署为监听的扩展证书，扩展域名不存在时新增，已存在时修改证书
+func (dp *aliyunSLBDeployment) deployExtensions(port int, domains []string) error {
+	loadBalancerId := dp.config.LoadBalancerId
+	status, _, err := dp.client.describeHTTPSListener(loadBalancerId, port)
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		return fmt.Errorf("监听[%d]不存在，不能添加扩展域名", port)
+	}
+	extensions, err := dp.client.describeDomainExtensions(loadBalancerId, port)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]aliyunSLBDomainExtension, len(extensions))
+	for _, extension := range extensions {
+		existing[normalizeDomain(extension.Domain)] = extension
+	}
+	for _, domain := range domains {
+		extension, ok := existing[domain]
+		if ok && extension.ServerCertificateId == dp.state.ServerCertificateId {
+			dp.state.Extensions = append(dp.state.Extensions, aliyunSLBExtensionState{Port: port, Domain: domain, DomainExtensionId: extension.DomainExtensionId, PreviousServerCertificateId: extension.ServerCertificateId})
+			dp.output = append(dp.output, fmt.Sprintf("监听[%d]扩展域名[%s]已使用该证书，跳过", port, domain))
+			continue
+		}
+		serverCertificateId, err := dp.serverCertificateId()
+		if err != nil {
+			return err
+		}
+		if ok {
+			dp.state.Extensions = append(dp.state.Extensions, aliyunSLBExtensionState{Port: port, Domain: domain, DomainExtensionId: extension.DomainExtensionId, PreviousServerCertificateId: extension.ServerCertificateId})
+			err = dp.client.setDomainExtensionCertificate(extension.DomainExtensionId, serverCertificateId)
+		} else {
+			var domainExtensionId string
+			if domainExtensionId, err = dp.client.addDomainExtension(loadBalancerId, port, domain, serverCertificateId); err == nil {
+				dp.state.Extensions = append(dp.state.Extensions, aliyunSLBExtensionState{Port: port, Domain: domain, DomainExtensionId: domainExtensionId})
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("监听[%d]扩展域名[%s]部署失败：%s", port, domain, err)
+		}
+		dp.output = append(dp.output, fmt.Sprintf("监听[%d]扩展域名[%s]部署成功#证书ID-%s", port, domain, serverCertificateId))
+	}
+
+	return nil
+}
+
+// 保存部署前的状态和输出，err不为nil时也返回已部署部分的状态
+func (dp *aliyunSLBDeployment) finish(err error) (*Result, error) {
+	dp.result.Output = strings.Join(dp.output, "\n")
+	if encodeErr := dp.result.EncodeState(dp.state); encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	return dp.result, err
+}
+
+// 本系统上传到SLB的证书名称前缀
+const aliyunSLBCertNamePrefix = "gocron-"
+
+// 是否为本系统上传的同域名证书，旧版本上传时使用域名作为证书名称
+func isAliyunSLBCertName(name string, certificate models.Certificate) bool {
+	return name == certificate.Domain || strings.HasPrefix(name, aliyunCertNamePrefix(aliyunSLBCertNamePrefix, certificate))
+}
+
+// 区域内全部监听、扩展域名引用的证书
+func referencedServerCertificates(client aliyunSLBAPI) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	loadBalancerIds, err := client.describeLoadBalancers()
+	if err != nil {
+		return nil, err
+	}
+	for _, loadBalancerId := range loadBalancerIds {
+		ports, err := client.describeHTTPSListenerPorts(loadBalancerId)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range ports {
+			_, serverCertificateId, err := client.describeHTTPSListener(loadBalancerId, port)
+			if err != nil {
+				return nil, err
+			}
+			referenced[serverCertificateId] = true
+			extensions, err := client.describeDomainExtensions(loadBalancerId, port)
+			if err != nil {
+				return nil, err
+			}
+			for _, extension := range extensions {
+				referenced[extension.ServerCertificateId] = true
+			}
+		}
+	}
+
+	return referenced, nil
+}
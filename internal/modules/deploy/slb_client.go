@@ -0,0 +1,266 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	sdkerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 查询SLB实例列表每页数量，接口允许的最大值
+const aliyunSLBPageSize = 100
+
+// 创建SLB的接口，测试时替换为内存中的实现
+var newAliyunSLBAPI = func(regionId string, ak models.AccessKey) (aliyunSLBAPI, error) {
+	client, err := slb.NewClientWithAccessKey(regionId, ak.AccessKeyId, string(ak.AccessKeySecret))
+	if err != nil {
+		return nil, err
+	}
+
+	return &aliyunSLBClient{client: client}, nil
+}
+
+// 使用SDK调用SLB的API
+type aliyunSLBClient struct {
+	client *slb.Client
+}
+
+// 修改意见存在的监听的 ssl证书id
+func (c *aliyunSLBClient) setHTTPSListenerCertificate(loadBalancerId string, port int, serverCertificateId string) error {
+	request := slb.CreateSetLoadBalancerHTTPSListenerAttributeRequest()
+	request.Scheme = "https"
+
+	request.ListenerPort = requests.NewInteger(port)
+	request.LoadBalancerId = loadBalancerId
+	request.ServerCertificateId = serverCertificateId
+
+	_, err := c.client.SetLoadBalancerHTTPSListenerAttribute(request)
+
+	return err
+}
+
+// 启动监听
+func (c *aliyunSLBClient) startListener(loadBalancerId string, port int) error {
+	request := slb.CreateStartLoadBalancerListenerRequest()
+	request.Scheme = "https"
+
+	request.ListenerPort = requests.NewInteger(port)
+	request.LoadBalancerId = loadBalancerId
+
+	response, err := c.client.StartLoadBalancerListener(request)
+	if err != nil {
+		return err
+	}
+	if !response.IsSuccess() {
+		return fmt.Errorf("启动监听(%d/%s)失败,错误：%s", port, loadBalancerId, response.GetHttpContentString())
+	}
+
+	return nil
+}
+
+// 上传证书，返回证书ID
+func (c *aliyunSLBClient) uploadServerCertificate(name, certificate, privateKey string) (string, error) {
+	request := slb.CreateUploadServerCertificateRequest()
+	request.Scheme = "https"
+
+	request.ServerCertificate = certificate
+	request.PrivateKey = privateKey
+	request.ServerCertificateName = name
+
+	response, err := c.client.UploadServerCertificate(request)
+	if err != nil {
+		return "", err
+	}
+
+	return response.ServerCertificateId, nil
+}
+
+// 删除指定的证书
+func (c *aliyunSLBClient) deleteServerCertificate(serverCertificateId string) error {
+	request := slb.CreateDeleteServerCertificateRequest()
+	request.Scheme = "https"
+
+	request.ServerCertificateId = serverCertificateId
+
+	_, err := c.client.DeleteServerCertificate(request)
+
+	return err
+}
+
+// 获取负载均衡的指定端口详情，返回监听状态和使用的证书ID，监听不存在时状态为空
+func (c *aliyunSLBClient) describeHTTPSListener(loadBalancerId string, port int) (status, serverCertificateId string, err error) {
+	request := slb.CreateDescribeLoadBalancerHTTPSListenerAttributeRequest()
+	request.Scheme = "https"
+
+	request.ListenerPort = requests.NewInteger(port)
+	request.LoadBalancerId = loadBalancerId
+
+	response, err := c.client.DescribeLoadBalancerHTTPSListenerAttribute(request)
+	if err != nil {
+		if cerr, ok := err.(*sdkerrors.ServerError); ok && cerr.ErrorCode() == "InvalidParameter" {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+
+	return response.Status, response.ServerCertificateId, nil
+}
+
+// 对指定负载均衡添加监听
+func (c *aliyunSLBClient) createHTTPSListener(loadBalancerId string, port, backendServerPort int, serverCertificateId string) error {
+	request := slb.CreateCreateLoadBalancerHTTPSListenerRequest()
+	request.Scheme = "https"
+
+	request.ListenerPort = requests.NewInteger(port)
+	request.ServerCertificateId = serverCertificateId
+	request.LoadBalancerId = loadBalancerId
+
+	// 下面这些都是必选参数，这儿都设置一个默认值！参数说明见：https://help.aliyun.com/document_detail/27593.html
+	request.Bandwidth = requests.NewInteger(-1)
+	request.StickySession = "on"                                       // session会话粘连/会话保持
+	request.HealthCheck = "off"                                        // 监控检查
+	request.BackendServerPort = requests.NewInteger(backendServerPort) // 后端端口，swarm容器服务器默认使用9080端口。
+	request.StickySessionType = "insert"                               // session保存使用的cookie方式
+	request.CookieTimeout = requests.NewInteger(86400)                 // cookie超时时间
+	// end
+
+	_, err := c.client.CreateLoadBalancerHTTPSListener(request)
+
+	return err
+}
+
+// 查询证书列表
+func (c *aliyunSLBClient) describeServerCertificates() ([]aliyunSLBServerCertificate, error) {
+	request := slb.CreateDescribeServerCertificatesRequest()
+	request.Scheme = "https"
+
+	response, err := c.client.DescribeServerCertificates(request)
+	if err != nil {
+		return nil, err
+	}
+	cs := make([]aliyunSLBServerCertificate, 0, len(response.ServerCertificates.ServerCertificate))
+	for _, item := range response.ServerCertificates.ServerCertificate {
+		cs = append(cs, aliyunSLBServerCertificate{
+			ServerCertificateId:     item.ServerCertificateId,
+			ServerCertificateName:   item.ServerCertificateName,
+			ExpireTimeStamp:         item.ExpireTimeStamp,
+			SubjectAlternativeNames: item.SubjectAlternativeNames.SubjectAlternativeName,
+		})
+	}
+
+	return cs, nil
+}
+
+// 查询监听的扩展域名
+func (c *aliyunSLBClient) describeDomainExtensions(loadBalancerId string, port int) ([]aliyunSLBDomainExtension, error) {
+	request := slb.CreateDescribeDomainExtensionsRequest()
+	request.Scheme = "https"
+
+	request.ListenerPort = requests.NewInteger(port)
+	request.LoadBalancerId = loadBalancerId
+
+	response, err := c.client.DescribeDomainExtensions(request)
+	if err != nil {
+		return nil, err
+	}
+	extensions := make([]aliyunSLBDomainExtension, 0, len(response.DomainExtensions.DomainExtension))
+	for _, item := range response.DomainExtensions.DomainExtension {
+		extensions = append(extensions, aliyunSLBDomainExtension{
+			DomainExtensionId:   item.DomainExtensionId,
+			Domain:              item.Domain,
+			ServerCertificateId: item.ServerCertificateId,
+		})
+	}
+
+	return extensions, nil
+}
+
+// 添加扩展域名，返回扩展域名ID
+func (c *aliyunSLBClient) addDomainExtension(loadBalancerId string, port int, domain, serverCertificateId string) (string, error) {
+	request := slb.CreateCreateDomainExtensionRequest()
+	request.Scheme = "https"
+
+	request.ListenerPort = requests.NewInteger(port)
+	request.LoadBalancerId = loadBalancerId
+	request.Domain = domain
+	request.ServerCertificateId = serverCertificateId
+
+	response, err := c.client.CreateDomainExtension(request)
+	if err != nil {
+		return "", err
+	}
+
+	return response.DomainExtensionId, nil
+}
+
+// 修改扩展域名的证书
+func (c *aliyunSLBClient) setDomainExtensionCertificate(domainExtensionId, serverCertificateId string) error {
+	request := slb.CreateSetDomainExtensionAttributeRequest()
+	request.Scheme = "https"
+
+	request.DomainExtensionId = domainExtensionId
+	request.ServerCertificateId = serverCertificateId
+
+	_, err := c.client.SetDomainExtensionAttribute(request)
+
+	return err
+}
+
+// 删除扩展域名
+func (c *aliyunSLBClient) deleteDomainExtension(domainExtensionId string) error {
+	request := slb.CreateDeleteDomainExtensionRequest()
+	request.Scheme = "https"
+
+	request.DomainExtensionId = domainExtensionId
+
+	_, err := c.client.DeleteDomainExtension(request)
+
+	return err
+}
+
+// 区域内全部SLB实例ID
+func (c *aliyunSLBClient) describeLoadBalancers() ([]string, error) {
+	loadBalancerIds := make([]string, 0)
+	for page := 1; ; page++ {
+		request := slb.CreateDescribeLoadBalancersRequest()
+		request.Scheme = "https"
+
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(aliyunSLBPageSize)
+
+		response, err := c.client.DescribeLoadBalancers(request)
+		if err != nil {
+			return nil, err
+		}
+		for _, loadBalancer := range response.LoadBalancers.LoadBalancer {
+			loadBalancerIds = append(loadBalancerIds, loadBalancer.LoadBalancerId)
+		}
+		if len(response.LoadBalancers.LoadBalancer) == 0 || len(loadBalancerIds) >= response.TotalCount {
+			return loadBalancerIds, nil
+		}
+	}
+}
+
+// SLB实例的HTTPS监听端口
+func (c *aliyunSLBClient) describeHTTPSListenerPorts(loadBalancerId string) ([]int, error) {
+	request := slb.CreateDescribeLoadBalancerAttributeRequest()
+	request.Scheme = "https"
+
+	request.LoadBalancerId = loadBalancerId
+
+	response, err := c.client.DescribeLoadBalancerAttribute(request)
+	if err != nil {
+		return nil, err
+	}
+	ports := make([]int, 0)
+	for _, listener := range response.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+		if strings.EqualFold(listener.ListenerProtocol, "https") {
+			ports = append(ports, listener.ListenerPort)
+		}
+	}
+
+	return ports, nil
+}
@@ -0,0 +1,525 @@
+package deploy
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 内存中的SLB，记录修改操作
+type testAliyunSLB struct {
+	certs map[string]aliyunSLBServerCertificate
+	// key为SLB实例ID:端口
+	listeners  map[string]*testAliyunSLBListener
+	extensions map[string]*testAliyunSLBExtension
+	// 操作失败，key为操作名称
+	errs map[string]error
+	// 修改证书成功但不生效，用于验证失败
+	ignoreSet bool
+
+	nextId int
+	ops    []string
+}
+
+type testAliyunSLBListener struct {
+	status              string
+	serverCertificateId string
+}
+
+type testAliyunSLBExtension struct {
+	listener string
+	aliyunSLBDomainExtension
+}
+
+func newTestAliyunSLB() *testAliyunSLB {
+	return &testAliyunSLB{
+		certs:      make(map[string]aliyunSLBServerCertificate),
+		listeners:  make(map[string]*testAliyunSLBListener),
+		extensions: make(map[string]*testAliyunSLBExtension),
+		errs:       make(map[string]error),
+	}
+}
+
+// 替换newAliyunSLBAPI，返回恢复的函数
+func (s *testAliyunSLB) use() func() {
+	original := newAliyunSLBAPI
+	newAliyunSLBAPI = func(regionId string, ak models.AccessKey) (aliyunSLBAPI, error) {
+		return s, nil
+	}
+
+	return func() {
+		newAliyunSLBAPI = original
+	}
+}
+
+func (s *testAliyunSLB) addCert(id, name string, expire time.Time, domains ...string) {
+	s.certs[id] = aliyunSLBServerCertificate{
+		ServerCertificateId:     id,
+		ServerCertificateName:   name,
+		ExpireTimeStamp:         expire.UnixNano() / int64(time.Millisecond),
+		SubjectAlternativeNames: domains,
+	}
+}
+
+func (s *testAliyunSLB) addListener(loadBalancerId string, port int, status, serverCertificateId string) {
+	s.listeners[testAliyunSLBListenerKey(loadBalancerId, port)] = &testAliyunSLBListener{status: status, serverCertificateId: serverCertificateId}
+}
+
+func (s *testAliyunSLB) addExtension(loadBalancerId string, port int, id, domain, serverCertificateId string) {
+	s.extensions[id] = &testAliyunSLBExtension{
+		listener:                 testAliyunSLBListenerKey(loadBalancerId, port),
+		aliyunSLBDomainExtension: aliyunSLBDomainExtension{DomainExtensionId: id, Domain: domain, ServerCertificateId: serverCertificateId},
+	}
+}
+
+func (s *testAliyunSLB) listener(loadBalancerId string, port int) *testAliyunSLBListener {
+	return s.listeners[testAliyunSLBListenerKey(loadBalancerId, port)]
+}
+
+func testAliyunSLBListenerKey(loadBalancerId string, port int) string {
+	return fmt.Sprintf("%s:%d", loadBalancerId, port)
+}
+
+func (s *testAliyunSLB) do(op string, args ...interface{}) error {
+	if err := s.errs[op]; err != nil {
+		return err
+	}
+	s.ops = append(s.ops, strings.TrimSpace(fmt.Sprintln(append([]interface{}{op}, args...)...)))
+
+	return nil
+}
+
+func (s *testAliyunSLB) describeServerCertificates() ([]aliyunSLBServerCertificate, error) {
+	cs := make([]aliyunSLBServerCertificate, 0, len(s.certs))
+	for _, c := range s.certs {
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].ServerCertificateId < cs[j].ServerCertificateId
+	})
+
+	return cs, nil
+}
+
+func (s *testAliyunSLB) uploadServerCertificate(name, certificate, privateKey string) (string, error) {
+	if err := s.do("upload", name); err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil || privateKey == "" {
+		return "", errors.New("证书或私钥无效")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	s.nextId++
+	id := fmt.Sprintf("cert-%d", s.nextId)
+	s.addCert(id, name, cert.NotAfter, cert.DNSNames...)
+
+	return id, nil
+}
+
+func (s *testAliyunSLB) deleteServerCertificate(serverCertificateId string) error {
+	if err := s.do("delete", serverCertificateId); err != nil {
+		return err
+	}
+	delete(s.certs, serverCertificateId)
+
+	return nil
+}
+
+func (s *testAliyunSLB) describeHTTPSListener(loadBalancerId string, port int) (string, string, error) {
+	listener := s.listener(loadBalancerId, port)
+	if listener == nil {
+		return "", "", nil
+	}
+
+	return listener.status, listener.serverCertificateId, nil
+}
+
+func (s *testAliyunSLB) createHTTPSListener(loadBalancerId string, port, backendServerPort int, serverCertificateId string) error {
+	if s.listener(loadBalancerId, port) != nil {
+		return errors.New("监听已存在")
+	}
+	if err := s.do("create", testAliyunSLBListenerKey(loadBalancerId, port), serverCertificateId); err != nil {
+		return err
+	}
+	s.addListener(loadBalancerId, port, "stopped", serverCertificateId)
+
+	return nil
+}
+
+func (s *testAliyunSLB) setHTTPSListenerCertificate(loadBalancerId string, port int, serverCertificateId string) error {
+	listener := s.listener(loadBalancerId, port)
+	if listener == nil {
+		return errors.New("监听不存在")
+	}
+	if err := s.do("set", testAliyunSLBListenerKey(loadBalancerId, port), serverCertificateId); err != nil {
+		return err
+	}
+	if !s.ignoreSet {
+		listener.serverCertificateId = serverCertificateId
+	}
+
+	return nil
+}
+
+func (s *testAliyunSLB) startListener(loadBalancerId string, port int) error {
+	listener := s.listener(loadBalancerId, port)
+	if listener == nil {
+		return errors.New("监听不存在")
+	}
+	if err := s.do("start", testAliyunSLBListenerKey(loadBalancerId, port)); err != nil {
+		return err
+	}
+	listener.status = "running"
+
+	return nil
+}
+
+func (s *testAliyunSLB) describeDomainExtensions(loadBalancerId string, port int) ([]aliyunSLBDomainExtension, error) {
+	key := testAliyunSLBListenerKey(loadBalancerId, port)
+	extensions := make([]aliyunSLBDomainExtension, 0)
+	for _, extension := range s.extensions {
+		if extension.listener == key {
+			extensions = append(extensions, extension.aliyunSLBDomainExtension)
+		}
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		return extensions[i].DomainExtensionId < extensions[j].DomainExtensionId
+	})
+
+	return extensions, nil
+}
+
+func (s *testAliyunSLB) addDomainExtension(loadBalancerId string, port int, domain, serverCertificateId string) (string, error) {
+	if s.listener(loadBalancerId, port) == nil {
+		return "", errors.New("监听不存在")
+	}
+	if err := s.do("add_extension", testAliyunSLBListenerKey(loadBalancerId, port), domain, serverCertificateId); err != nil {
+		return "", err
+	}
+	s.nextId++
+	id := fmt.Sprintf("ext-%d", s.nextId)
+	s.addExtension(loadBalancerId, port, id, domain, serverCertificateId)
+
+	return id, nil
+}
+
+func (s *testAliyunSLB) setDomainExtensionCertificate(domainExtensionId, serverCertificateId string) error {
+	extension, ok := s.extensions[domainExtensionId]
+	if !ok {
+		return errors.New("扩展域名不存在")
+	}
+	if err := s.do("set_extension", domainExtensionId, serverCertificateId); err != nil {
+		return err
+	}
+	if !s.ignoreSet {
+		extension.ServerCertificateId = serverCertificateId
+	}
+
+	return nil
+}
+
+func (s *testAliyunSLB) deleteDomainExtension(domainExtensionId string) error {
+	if err := s.do("delete_extension", domainExtensionId); err != nil {
+		return err
+	}
+	delete(s.extensions, domainExtensionId)
+
+	return nil
+}
+
+func (s *testAliyunSLB) describeLoadBalancers() ([]string, error) {
+	exists := make(map[string]bool)
+	loadBalancerIds := make([]string, 0)
+	for key := range s.listeners {
+		loadBalancerId := key[:strings.LastIndex(key, ":")]
+		if !exists[loadBalancerId] {
+			exists[loadBalancerId] = true
+			loadBalancerIds = append(loadBalancerIds, loadBalancerId)
+		}
+	}
+	sort.Strings(loadBalancerIds)
+
+	return loadBalancerIds, nil
+}
+
+func (s *testAliyunSLB) describeHTTPSListenerPorts(loadBalancerId string) ([]int, error) {
+	ports := make([]int, 0)
+	for key := range s.listeners {
+		var port int
+		if strings.HasPrefix(key, loadBalancerId+":") {
+			if _, err := fmt.Sscanf(key[len(loadBalancerId)+1:], "%d", &port); err != nil {
+				return nil, err
+			}
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+
+	return ports, nil
+}
+
+func newTestAliyunSLBTarget(t *testing.T, config AliyunSLBConfig) *Target {
+	if config.RegionId == "" {
+		config.RegionId = "cn-hangzhou"
+	}
+	if config.LoadBalancerId == "" {
+		config.LoadBalancerId = "lb-1"
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &Target{}
+	target.Name = "slb"
+	target.Type = TypeAliyunSLB
+	target.Config = string(data)
+	target.Status = models.Enabled
+	target.AccessKey.Id = 1
+	target.AccessKey.AccessKeyId = "test"
+	target.AccessKey.AccessKeySecret = "secret"
+
+	return target
+}
+
+func assertTestAliyunSLBOps(t *testing.T, stub *testAliyunSLB, expected ...string) {
+	t.Helper()
+	if len(expected) == 0 && len(stub.ops) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(stub.ops, expected) {
+		t.Fatalf("操作错误\n实际: %q\n期望: %q", stub.ops, expected)
+	}
+}
+
+// 监听不存在时上传证书、新建监听并启动，再次部署同一证书时不重复上传
+func TestAliyunSLBDeployNewListener(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+
+	certificate := newTestCertificate(t, "a.com")
+	certName := aliyunCertName(aliyunSLBCertNamePrefix, certificate)
+	result, err := Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{}), certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub, "upload "+certName, "create lb-1:443 cert-1", "start lb-1:443")
+	if listener := stub.listener("lb-1", 443); listener.status != "running" || listener.serverCertificateId != "cert-1" {
+		t.Fatalf("监听状态错误: %+v", listener)
+	}
+	if !strings.Contains(result.Output, "证书部署到SLB成功#SLB-lb-1#端口-443#证书ID-cert-1") {
+		t.Fatalf("输出错误: %s", result.Output)
+	}
+	state := new(aliyunSLBState)
+	if err = result.DecodeState(state); err != nil {
+		t.Fatal(err)
+	}
+	if state.ServerCertificateId != "cert-1" || len(state.Listeners) != 1 || state.Listeners[0].Status != "" {
+		t.Fatalf("部署前的状态错误: %+v", state)
+	}
+
+	stub.ops = nil
+	result, err = Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{}), certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub)
+	if !strings.Contains(result.Output, "监听[443]已使用该证书，跳过") {
+		t.Fatalf("输出错误: %s", result.Output)
+	}
+}
+
+// 监听使用其他证书时替换并启动已停止的监听，多个端口分别处理
+func TestAliyunSLBDeployExpiringCertificate(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+	stub.addCert("old-1", "a.com", time.Now().Add(10*24*time.Hour), "a.com")
+	stub.addListener("lb-1", 443, "stopped", "old-1")
+	stub.addListener("lb-1", 8443, "running", "old-1")
+
+	certificate := newTestCertificate(t, "a.com")
+	target := newTestAliyunSLBTarget(t, AliyunSLBConfig{ListenerPorts: []int{443, 8443, 9443}})
+	result, err := Run(target, certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub,
+		"upload "+aliyunCertName(aliyunSLBCertNamePrefix, certificate),
+		"set lb-1:443 cert-1", "start lb-1:443",
+		"set lb-1:8443 cert-1",
+		"create lb-1:9443 cert-1", "start lb-1:9443",
+	)
+
+	// 回滚时新建的监听不处理
+	stub.ops = nil
+	if err = Rollback(target, result.State); err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub, "set lb-1:443 old-1", "set lb-1:8443 old-1")
+}
+
+// 回滚到旧版本时，监听使用的同域名新证书未过期也替换为旧版本，已上传的旧版本不重复上传
+func TestAliyunSLBDeployOlderVersion(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+	older := newTestCertificate(t, "a.com")
+	newer := newTestCertificate(t, "a.com")
+	stub.addCert("old-1", aliyunCertName(aliyunSLBCertNamePrefix, older), time.Now().Add(80*24*time.Hour), "a.com")
+	stub.addCert("new-1", aliyunCertName(aliyunSLBCertNamePrefix, newer), time.Now().Add(90*24*time.Hour), "a.com")
+	stub.addListener("lb-1", 443, "running", "new-1")
+
+	result, err := Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{}), older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub, "set lb-1:443 old-1")
+	if listener := stub.listener("lb-1", 443); listener.serverCertificateId != "old-1" {
+		t.Fatalf("监听应使用旧版本证书: %+v", listener)
+	}
+	state := new(aliyunSLBState)
+	if err = result.DecodeState(state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Listeners) != 1 || state.Listeners[0].PreviousServerCertificateId != "new-1" {
+		t.Fatalf("部署前的状态错误: %+v", state)
+	}
+}
+
+// 部署失败时返回已部署部分的状态
+func TestAliyunSLBDeployFailed(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+	stub.errs["start"] = errors.New("start failed")
+
+	result, err := Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{}), newTestCertificate(t, "a.com"))
+	if err == nil || err.Error() != "start failed" {
+		t.Fatalf("启动监听失败时应返回错误, 实际%v", err)
+	}
+	state := new(aliyunSLBState)
+	if err = result.DecodeState(state); err != nil {
+		t.Fatal(err)
+	}
+	if state.ServerCertificateId != "cert-1" || len(state.Listeners) != 1 {
+		t.Fatalf("部署前的状态错误: %+v", state)
+	}
+}
+
+// 修改证书后监听仍未使用部署的证书时回滚
+func TestAliyunSLBVerifyFailedRollback(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+	stub.addCert("old-1", "a.com", time.Now().Add(10*24*time.Hour), "a.com")
+	stub.addListener("lb-1", 443, "running", "old-1")
+	stub.ignoreSet = true
+
+	_, err := Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{Cleanup: true}), newTestCertificate(t, "a.com"))
+	if _, ok := err.(*RolledBackError); !ok {
+		t.Fatalf("验证失败时应回滚, 实际%v", err)
+	}
+	if last := stub.ops[len(stub.ops)-1]; last != "set lb-1:443 old-1" {
+		t.Fatalf("回滚时应恢复部署前的证书: %q", stub.ops)
+	}
+}
+
+// 扩展域名已存在时修改证书，不存在时新增，回滚时恢复或删除
+func TestAliyunSLBDeployExtensions(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+	stub.addCert("default-1", "b.com", time.Now().Add(60*24*time.Hour), "b.com")
+	stub.addCert("old-1", "www.a.com", time.Now().Add(10*24*time.Hour), "www.a.com")
+	stub.addCert("valid-1", "img.a.com", time.Now().Add(60*24*time.Hour), "img.a.com")
+	stub.addListener("lb-1", 443, "running", "default-1")
+	stub.addListener("lb-1", 8443, "running", "default-1")
+	stub.addExtension("lb-1", 443, "ext-www", "www.a.com", "old-1")
+	stub.addExtension("lb-1", 443, "ext-img", "img.a.com", "valid-1")
+
+	certificate := newTestCertificate(t, "a.com", "*.a.com")
+	target := newTestAliyunSLBTarget(t, AliyunSLBConfig{
+		ListenerPorts:    []int{443, 8443},
+		Extension:        true,
+		ExtensionDomains: []string{"www.a.com", "img.a.com"},
+	})
+	result, err := Run(target, certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub,
+		"upload "+aliyunCertName(aliyunSLBCertNamePrefix, certificate),
+		"set_extension ext-www cert-1",
+		"set_extension ext-img cert-1",
+		"add_extension lb-1:8443 www.a.com cert-1",
+		"add_extension lb-1:8443 img.a.com cert-1",
+	)
+	if stub.listener("lb-1", 443).serverCertificateId != "default-1" || stub.listener("lb-1", 8443).serverCertificateId != "default-1" {
+		t.Fatal("扩展证书模式不应修改监听的默认证书")
+	}
+	stub.ops = nil
+	if err = Rollback(target, result.State); err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub, "set_extension ext-www old-1", "set_extension ext-img valid-1", "delete_extension ext-2", "delete_extension ext-3")
+
+	if _, err = Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{ListenerPort: 9443, Extension: true}), certificate); err == nil {
+		t.Fatal("监听不存在时不能添加扩展域名")
+	}
+	if _, err = Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{Extension: true, ExtensionDomains: []string{"b.com"}}), certificate); err == nil {
+		t.Fatal("扩展域名与证书域名不匹配时应返回错误")
+	}
+}
+
+// 只删除本系统上传的、不再被任何监听引用的同域名旧证书
+func TestAliyunSLBCleanup(t *testing.T) {
+	stub := newTestAliyunSLB()
+	defer stub.use()()
+	expire := time.Now().Add(10 * 24 * time.Hour)
+	stub.addCert("old-1", "a.com", expire, "a.com")
+	stub.addCert("stale-1", "gocron-a.com-000000000001", expire, "a.com")
+	stub.addCert("stale-2", "gocron-a.com-000000000002", expire, "a.com")
+	stub.addCert("user-1", "my-a.com", expire, "a.com")
+	stub.addCert("other-1", "gocron-b.com-000000000001", expire, "b.com")
+	stub.addListener("lb-1", 443, "running", "old-1")
+	stub.addListener("lb-2", 443, "running", "stale-2")
+
+	certificate := newTestCertificate(t, "a.com")
+	result, err := Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{Cleanup: true}), certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestAliyunSLBOps(t, stub,
+		"upload "+aliyunCertName(aliyunSLBCertNamePrefix, certificate),
+		"set lb-1:443 cert-1",
+		"delete stale-1",
+	)
+	for _, id := range []string{"old-1", "stale-2", "user-1", "other-1", "cert-1"} {
+		if _, ok := stub.certs[id]; !ok {
+			t.Fatalf("证书%s不应删除", id)
+		}
+	}
+	if !strings.Contains(result.Output, "删除不再使用的证书[gocron-a.com-000000000001-stale-1]") || !strings.Contains(result.Output, "证书[gocron-a.com-000000000002-stale-2]仍被监听使用，保留") {
+		t.Fatalf("输出错误: %s", result.Output)
+	}
+
+	// 删除失败不影响部署结果
+	stub.ops = nil
+	stub.addCert("stale-3", "gocron-a.com-000000000003", expire, "a.com")
+	stub.errs["delete"] = errors.New("delete failed")
+	result, err = Run(newTestAliyunSLBTarget(t, AliyunSLBConfig{Cleanup: true}), certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Output, "清理失败：delete failed") {
+		t.Fatalf("输出错误: %s", result.Output)
+	}
+}
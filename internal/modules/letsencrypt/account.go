@@ -0,0 +1,212 @@
+package letsencrypt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v3/lego"
+	"github.com/go-acme/lego/v3/registration"
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+)
+
+const (
+	opAccountQuery      = "查询账号"
+	opAccountUpdate     = "更新账号"
+	opAccountDeactivate = "停用账号"
+	opAccountKeyChange  = "轮换账号密钥"
+	opAccountResolve    = "恢复账号"
+)
+
+// 账号在CA的信息
+type AccountStatus struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact"`
+	URI     string   `json:"uri"`
+}
+
+// 加载已注册的账号，缺少Account数据时通过账号私钥从CA查询并保存
+func loadAccount(au *models.AcmeUser, op string) (*tmpUser, *lego.Client, error) {
+	if au.IsDeactivated() {
+		return nil, nil, newError(ErrorCategoryAccount, op, fmt.Errorf("账号已停用#%s#状态-%s", au.Email, au.Status))
+	}
+	myUser, err := getUserByAcmeUser(au, false)
+	if err != nil {
+		return nil, nil, newError(ErrorCategoryAccount, op, err)
+	}
+	client, err := newClient(myUser)
+	if err != nil {
+		return nil, nil, wrapError(op, err)
+	}
+	if myUser.Registration == nil {
+		if err = resolveAccount(client, au, myUser); err != nil {
+			return nil, nil, wrapError(op, err)
+		}
+	}
+
+	return myUser, client, nil
+}
+
+// 通过账号私钥从CA查询已注册的账号并保存，用于Resource数据丢失的账号
+func resolveAccount(client *lego.Client, au *models.AcmeUser, myUser *tmpUser) error {
+	reg, err := client.Registration.ResolveAccountByKey()
+	if err != nil {
+		return err
+	}
+	myUser.Registration = reg
+	logger.Infof("通过账号私钥恢复账号#%s#%s", au.Email, reg.URI)
+
+	return saveAccount(au, myUser)
+}
+
+func saveAccount(au *models.AcmeUser, myUser *tmpUser) error {
+	au.Status = myUser.Registration.Body.Status
+	au.StatusCheckedAt = time.Now()
+
+	return au.SaveResourceAndPrivateKey(myUser.PrivateKey, myUser.Registration)
+}
+
+// 通过账号私钥从CA查询已注册的账号，覆盖保存的Account数据
+func ResolveAccount(au *models.AcmeUser) error {
+	myUser, err := getUserByAcmeUser(au, false)
+	if err != nil {
+		return newError(ErrorCategoryAccount, opAccountResolve, err)
+	}
+	client, err := newClient(myUser)
+	if err != nil {
+		return wrapError(opAccountResolve, err)
+	}
+
+	return wrapError(opAccountResolve, resolveAccount(client, au, myUser))
+}
+
+// 查询账号在CA的状态并保存
+func QueryAccount(au *models.AcmeUser) (*AccountStatus, error) {
+	// 已停用的账号CA会返回unauthorized，直接返回保存的状态
+	if au.IsDeactivated() {
+		return &AccountStatus{Status: au.Status}, nil
+	}
+	myUser, client, err := loadAccount(au, opAccountQuery)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := client.Registration.QueryRegistration()
+	if err != nil {
+		return nil, wrapError(opAccountQuery, err)
+	}
+	myUser.Registration = reg
+	if err = saveAccount(au, myUser); err != nil {
+		return nil, err
+	}
+
+	return &AccountStatus{Status: reg.Body.Status, Contact: reg.Body.Contact, URI: reg.URI}, nil
+}
+
+// 更新账号的联系邮箱
+func UpdateAccountContact(au *models.AcmeUser, email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return newError(ErrorCategoryInvalidParameter, opAccountUpdate, errors.New("邮箱不能为空"))
+	}
+	myUser, _, err := loadAccount(au, opAccountUpdate)
+	if err != nil {
+		return err
+	}
+	request, err := newAccountRequest(au, myUser)
+	if err != nil {
+		return newError(ErrorCategoryAccount, opAccountUpdate, err)
+	}
+	account, err := request.updateContact([]string{"mailto:" + email})
+	if err != nil {
+		return wrapError(opAccountUpdate, err)
+	}
+	au.Email = email
+	myUser.Registration = &registration.Resource{URI: myUser.Registration.URI, Body: *account}
+
+	return saveAccount(au, myUser)
+}
+
+// 使用账号私钥签名的ACME请求
+func newAccountRequest(au *models.AcmeUser, myUser *tmpUser) (*acmeRequest, error) {
+	caDirURL, err := ResolveCADirURL(au.CaDirUrl)
+	if err != nil {
+		return nil, err
+	}
+	request := &acmeRequest{caDirURL: caDirURL, key: myUser.PrivateKey, kid: myUser.Registration.URI}
+	if request.httpClient, err = newCAHTTPClient(au); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// 停用账号，停用后不能恢复，账号申请的证书不受影响
+func DeactivateAccount(au *models.AcmeUser) error {
+	_, client, err := loadAccount(au, opAccountDeactivate)
+	if err != nil {
+		return err
+	}
+	if err = client.Registration.DeleteRegistration(); err != nil {
+		return wrapError(opAccountDeactivate, err)
+	}
+	au.Status = models.AcmeUserStatusDeactivated
+	au.StatusCheckedAt = time.Now()
+	_, err = au.UpdateStatus(au.Id)
+
+	return err
+}
+
+// 轮换账号密钥，keyType为空时使用EC256
+// 轮换后通过lego使用新密钥从CA查询账号，确认CA已使用新密钥后才保存，保证保存的密钥与CA一致
+func RolloverAccountKey(au *models.AcmeUser, keyType string) error {
+	if keyType == "" {
+		keyType = models.KeyTypeEC256
+	}
+	myUser, _, err := loadAccount(au, opAccountKeyChange)
+	if err != nil {
+		return err
+	}
+	newKey, err := generatePrivateKey(keyType)
+	if err != nil {
+		return newError(ErrorCategoryInvalidParameter, opAccountKeyChange, err)
+	}
+	request, err := newAccountRequest(au, myUser)
+	if err != nil {
+		return newError(ErrorCategoryAccount, opAccountKeyChange, err)
+	}
+	keyChangeErr := request.keyChange(newKey)
+
+	// keyChange返回错误时CA也可能已更换密钥(如：响应超时)，以新密钥能否查询到账号为准
+	newUser := &tmpUser{AcmeUser: au, PrivateKey: newKey}
+	reg, err := resolveAccountByKey(newUser)
+	if err != nil {
+		if keyChangeErr != nil {
+			return wrapError(opAccountKeyChange, keyChangeErr)
+		}
+		// CA已确认更换密钥，查询失败时仍保存新密钥，账号数据沿用原来的
+		logger.Warnf("账号密钥已轮换，使用新密钥查询账号失败#%s#%s", au.Email, err)
+		reg = myUser.Registration
+	}
+	if reg.URI != myUser.Registration.URI {
+		return newError(ErrorCategoryAccount, opAccountKeyChange, fmt.Errorf("新密钥对应的账号%s与原账号%s不一致，未保存新密钥", reg.URI, myUser.Registration.URI))
+	}
+	logger.Infof("账号密钥已轮换#%s#%s", au.Email, keyType)
+	newUser.Registration = reg
+	if err = saveAccount(au, newUser); err != nil {
+		return fmt.Errorf("账号密钥已轮换，保存新密钥失败#%s#%s", au.Email, err)
+	}
+
+	return nil
+}
+
+// 通过lego使用账号私钥从CA查询账号
+func resolveAccountByKey(myUser *tmpUser) (*registration.Resource, error) {
+	client, err := newClient(myUser)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Registration.ResolveAccountByKey()
+}
@@ -0,0 +1,194 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-acme/lego/v3/acme"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// lego v3不支持的ACME请求：账号密钥轮换(keyChange)、修改账号联系方式
+// lego的acme/api没有对应的方法，签名和nonce相关的实现在internal包中不能复用，这两个请求自行签名
+// 其他请求(包括指定原因注销证书、使用证书私钥注销证书)都通过lego的API，不要在这里增加
+// kid为账号URL，使用账号私钥签名
+type acmeRequest struct {
+	httpClient *http.Client
+	caDirURL   string
+	key        crypto.PrivateKey
+	kid        string
+}
+
+type acmeDirectory struct {
+	NewNonce  string `json:"newNonce"`
+	KeyChange string `json:"keyChange"`
+}
+
+func (r *acmeRequest) directory() (*acmeDirectory, error) {
+	if r.httpClient == nil {
+		r.httpClient = &http.Client{Timeout: ariTimeout}
+	}
+	directory := new(acmeDirectory)
+	if err := getJSON(r.httpClient, r.caDirURL, directory); err != nil {
+		return nil, err
+	}
+	if directory.NewNonce == "" {
+		return nil, errors.New("CA目录缺少newNonce地址")
+	}
+
+	return directory, nil
+}
+
+// 账号密钥轮换，参考 https://tools.ietf.org/html/rfc8555#section-7.3.5
+// 内层JWS使用新密钥签名并携带新公钥，外层JWS使用旧密钥签名
+func (r *acmeRequest) keyChange(newKey crypto.PrivateKey) error {
+	if r.kid == "" {
+		return errors.New("缺少账号URL")
+	}
+	directory, err := r.directory()
+	if err != nil {
+		return err
+	}
+	if directory.KeyChange == "" {
+		return errors.New("CA目录缺少keyChange地址")
+	}
+	oldPublicKey := r.key.(crypto.Signer).Public()
+	innerPayload, err := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}{r.kid, jose.JSONWebKey{Key: oldPublicKey}})
+	if err != nil {
+		return err
+	}
+	algorithm, err := signatureAlgorithm(newKey)
+	if err != nil {
+		return err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: newKey}, &jose.SignerOptions{
+		EmbedJWK:     true,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": directory.KeyChange},
+	})
+	if err != nil {
+		return err
+	}
+	inner, err := signer.Sign(innerPayload)
+	if err != nil {
+		return err
+	}
+
+	return r.postWithRetry(directory, directory.KeyChange, []byte(inner.FullSerialize()), nil)
+}
+
+// 修改账号的联系方式，参考 https://tools.ietf.org/html/rfc8555#section-7.3.2
+func (r *acmeRequest) updateContact(contact []string) (*acme.Account, error) {
+	if r.kid == "" {
+		return nil, errors.New("缺少账号URL")
+	}
+	directory, err := r.directory()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(map[string][]string{"contact": contact})
+	if err != nil {
+		return nil, err
+	}
+	account := new(acme.Account)
+	if err = r.postWithRetry(directory, r.kid, payload, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// nonce失效时重试一次
+func (r *acmeRequest) postWithRetry(directory *acmeDirectory, url string, payload []byte, v interface{}) error {
+	err := r.post(directory, url, payload, v)
+	if problem, ok := err.(*acme.ProblemDetails); ok && problem.Type == acme.BadNonceErr {
+		err = r.post(directory, url, payload, v)
+	}
+
+	return err
+}
+
+// 响应的json解析到v，v为nil时不解析
+func (r *acmeRequest) post(directory *acmeDirectory, url string, payload []byte, v interface{}) error {
+	algorithm, err := signatureAlgorithm(r.key)
+	if err != nil {
+		return err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: r.key}, &jose.SignerOptions{
+		NonceSource:  &nonceSource{httpClient: r.httpClient, url: directory.NewNonce},
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": url, "kid": r.kid},
+	})
+	if err != nil {
+		return err
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.httpClient.Post(url, "application/jose+json", bytes.NewBufferString(signed.FullSerialize()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(body, v)
+	}
+
+	problem := &acme.ProblemDetails{HTTPStatus: resp.StatusCode}
+	if err = json.Unmarshal(body, problem); err != nil || problem.Type == "" {
+		return fmt.Errorf("ACME请求失败#%s#HTTP状态码-%d#%s", url, resp.StatusCode, body)
+	}
+
+	return problem
+}
+
+// 签名算法
+func signatureAlgorithm(key crypto.PrivateKey) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jose.ES256, nil
+		case 384:
+			return jose.ES384, nil
+		}
+	}
+
+	return "", fmt.Errorf("不支持的私钥类型：%T", key)
+}
+
+// 从CA的newNonce地址获取nonce
+type nonceSource struct {
+	httpClient *http.Client
+	url        string
+}
+
+func (n *nonceSource) Nonce() (string, error) {
+	resp, err := n.httpClient.Head(n.url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("CA没有返回Replay-Nonce")
+	}
+
+	return nonce, nil
+}
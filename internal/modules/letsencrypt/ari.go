@@ -0,0 +1,174 @@
+package letsencrypt
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+)
+
+// CA不支持ARI(ACME Renewal Information)，参考 https://datatracker.ietf.org/doc/draft-ietf-acme-ari/
+var errARIUnsupported = errors.New("CA不支持ARI")
+
+const ariTimeout = 30 * time.Second
+
+// CA建议的续期时间窗口
+type RenewalInfo struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	// CA提前续期的说明，如：证书需要批量注销
+	ExplanationURL string `json:"explanationURL"`
+}
+
+// 在建议的时间窗口内随机选择续期时间，避免同一时间大量续期
+func (r *RenewalInfo) randomTime() time.Time {
+	start, end := r.SuggestedWindow.Start, r.SuggestedWindow.End
+	if !end.After(start) {
+		return start
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return start.Add(time.Duration(rnd.Int63n(int64(end.Sub(start)))))
+}
+
+// 计算证书的续期时间，返回是否需要续期
+// CA支持ARI时在CA建议的时间窗口内随机选择续期时间，窗口不变时保留上次选择的时间；CA提前续期(如批量注销)时窗口会提前，到期后立即续期
+// CA不支持ARI或查询失败时，按域名配置的续期天数计算
+// 计算结果保存在certificate的RenewAt、RenewWindowStart、RenewWindowEnd字段，续期时间变化时同时更新到数据库
+func ScheduleRenewal(au *models.AcmeUser, config models.DomainConfig, certificate *models.Certificate) (bool, error) {
+	certificates, err := certcrypto.ParsePEMBundle([]byte(certificate.Certificate))
+	if err != nil {
+		return false, fmt.Errorf("证书解析失败#证书ID-%d#%s", certificate.Id, err)
+	}
+	x509Cert := certificates[0]
+	if x509Cert.IsCA {
+		return false, fmt.Errorf("[%s] Certificate bundle starts with a CA certificate", certificate.Domain)
+	}
+
+	var info *RenewalInfo
+	if au != nil && au.Id > 0 {
+		info, err = fetchRenewalInfo(au, x509Cert)
+		if err != nil && err != errARIUnsupported {
+			logger.Warnf("[%s] 查询ARI失败，按续期天数计算续期时间#%s", certificate.Domain, err)
+		}
+	}
+	if info == nil {
+		days := config.GetRenewDay()
+		if days < 0 {
+			return true, nil
+		}
+		renewAt := x509Cert.NotAfter.Add(-time.Duration(days) * 24 * time.Hour)
+		if !renewAt.Equal(certificate.RenewAt) || !certificate.RenewWindowStart.IsZero() || !certificate.RenewWindowEnd.IsZero() {
+			certificate.RenewWindowStart = time.Time{}
+			certificate.RenewWindowEnd = time.Time{}
+			certificate.RenewAt = renewAt
+			saveRenewal(certificate)
+		}
+	} else if !info.SuggestedWindow.Start.Equal(certificate.RenewWindowStart) ||
+		!info.SuggestedWindow.End.Equal(certificate.RenewWindowEnd) || certificate.RenewAt.IsZero() {
+		certificate.RenewWindowStart = info.SuggestedWindow.Start
+		certificate.RenewWindowEnd = info.SuggestedWindow.End
+		certificate.RenewAt = info.randomTime()
+		saveRenewal(certificate)
+		if info.ExplanationURL != "" {
+			logger.Warnf("[%s] CA调整了续期时间窗口%s ~ %s，说明：%s", certificate.Domain,
+				info.SuggestedWindow.Start.Format(time.RFC3339), info.SuggestedWindow.End.Format(time.RFC3339), info.ExplanationURL)
+		}
+	}
+
+	if time.Now().Before(certificate.RenewAt) {
+		logger.Infof("[%s] The certificate will be renewed at %s: no renewal.", certificate.Domain, certificate.RenewAt.Format(time.RFC3339))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// 保存续期时间，手动续期时未到续期时间也需要保存新选择的时间
+func saveRenewal(certificate *models.Certificate) {
+	if certificate.Id <= 0 {
+		return
+	}
+	if _, err := certificate.UpdateRenewal(certificate.Id); err != nil {
+		logger.Errorf("[%s] 保存续期时间失败#证书ID-%d#%s", certificate.Domain, certificate.Id, err)
+	}
+}
+
+// 查询证书的ARI
+func fetchRenewalInfo(au *models.AcmeUser, x509Cert *x509.Certificate) (*RenewalInfo, error) {
+	caDirURL, err := ResolveCADirURL(au.CaDirUrl)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newCAHTTPClient(au)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = &http.Client{Timeout: ariTimeout}
+	}
+
+	directory := struct {
+		RenewalInfo string `json:"renewalInfo"`
+	}{}
+	if err = getJSON(client, caDirURL, &directory); err != nil {
+		return nil, err
+	}
+	if directory.RenewalInfo == "" {
+		return nil, errARIUnsupported
+	}
+	certID, err := ariCertID(x509Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	info := new(RenewalInfo)
+	if err = getJSON(client, strings.TrimSuffix(directory.RenewalInfo, "/")+"/"+certID, info); err != nil {
+		return nil, err
+	}
+	if info.SuggestedWindow.Start.IsZero() || info.SuggestedWindow.End.IsZero() {
+		return nil, fmt.Errorf("ARI返回的续期时间窗口无效")
+	}
+
+	return info, nil
+}
+
+// ARI证书标识：base64url(Authority Key Identifier).base64url(序列号的DER编码)
+func ariCertID(x509Cert *x509.Certificate) (string, error) {
+	if len(x509Cert.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("证书缺少Authority Key Identifier")
+	}
+	serial := x509Cert.SerialNumber.Bytes()
+	// DER编码的整数最高位为1时需要补0，否则会被当作负数
+	if len(serial) > 0 && serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(x509Cert.AuthorityKeyId) + "." +
+		base64.RawURLEncoding.EncodeToString(serial), nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求%s失败，HTTP状态码：%d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
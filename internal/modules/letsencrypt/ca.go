@@ -0,0 +1,75 @@
+package letsencrypt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v3/lego"
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 默认使用的CA
+const DefaultCA = "letsencrypt"
+
+// 内置的CA目录地址
+var CADirectories = map[string]string{
+	// letsencrypt正式api， 有限制：https://letsencrypt.org/docs/rate-limits/
+	"letsencrypt": lego.LEDirectoryProduction,
+	// letsencrypt官方测试api，说明：https://letsencrypt.org/docs/staging-environment/
+	"letsencrypt-staging": lego.LEDirectoryStaging,
+	// 需要EAB
+	"zerossl":         "https://acme.zerossl.com/v2/DV90",
+	"buypass":         "https://api.buypass.com/acme/directory",
+	"buypass-staging": "https://api.test4.buypass.no/acme/directory",
+	// 需要EAB
+	"google":         "https://dv.acme-v02.api.pki.goog/directory",
+	"google-staging": "https://dv.acme-v02.test-api.pki.goog/directory",
+	// 本地Pebble测试服务器的默认地址
+	"pebble": "https://localhost:14000/dir",
+}
+
+// 解析账号的CA目录地址，支持预设名称和完整的URL
+func ResolveCADirURL(caDirURL string) (string, error) {
+	caDirURL = strings.TrimSpace(caDirURL)
+	if caDirURL == "" {
+		caDirURL = DefaultCA
+	}
+	if u, ok := CADirectories[strings.ToLower(caDirURL)]; ok {
+		return u, nil
+	}
+	// 明文访问CA会暴露账号的签名和nonce，只支持https
+	if !strings.HasPrefix(caDirURL, "https://") {
+		return "", fmt.Errorf("无效的CA目录地址，只支持https：%s", caDirURL)
+	}
+
+	return caDirURL, nil
+}
+
+// 访问CA的http client，配置了自定义根证书时追加到系统根证书中
+func newCAHTTPClient(au *models.AcmeUser) (*http.Client, error) {
+	if strings.TrimSpace(au.CaCertificates) == "" {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(au.CaCertificates)) {
+		return nil, fmt.Errorf("CA根证书解析失败，请检查是否为PEM格式#账号-%s", au.Email)
+	}
+
+	return &http.Client{
+		Timeout: 2 * time.Minute,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSHandshakeTimeout:   15 * time.Second,
+			ResponseHeaderTimeout: 15 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       &tls.Config{RootCAs: pool},
+		},
+	}, nil
+}
@@ -0,0 +1,33 @@
+package letsencrypt
+
+import (
+	"testing"
+
+	"github.com/go-acme/lego/v3/lego"
+)
+
+func TestResolveCADirURL(t *testing.T) {
+	tests := map[string]string{
+		"":                                      lego.LEDirectoryProduction,
+		" Letsencrypt-Staging":                  lego.LEDirectoryStaging,
+		"https://ca.example.com/acme/directory": "https://ca.example.com/acme/directory",
+	}
+	for caDirURL, expected := range tests {
+		u, err := ResolveCADirURL(caDirURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != expected {
+			t.Fatalf("CA目录地址解析错误-%q, 期望%s, 实际%s", caDirURL, expected, u)
+		}
+	}
+}
+
+func TestResolveCADirURLInvalid(t *testing.T) {
+	invalid := []string{"unknown", "http://ca.example.com/acme/directory", "ftp://ca.example.com"}
+	for _, caDirURL := range invalid {
+		if _, err := ResolveCADirURL(caDirURL); err == nil {
+			t.Fatalf("无效的CA目录地址未返回错误-%s", caDirURL)
+		}
+	}
+}
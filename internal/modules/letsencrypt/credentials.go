@@ -0,0 +1,180 @@
+package letsencrypt
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务商凭证参数, key为服务商Config的字段名, 不区分大小写
+type credentials struct {
+	values map[string]string
+	// 域名配置中的通用参数, 如: TTL, 服务商Config没有该字段时忽略; 凭证参数中配置了时以凭证参数为准
+	optional map[string]string
+}
+
+// 把凭证参数按字段类型写入服务商的Config, config必须是结构体指针
+// 支持的字段类型: string、bool、整数、浮点数、time.Duration、*url.URL、[]string
+func (c credentials) apply(config interface{}) error {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config必须是结构体指针")
+	}
+	fields := make(map[string]configField)
+	collectConfigFields(v.Elem(), fields)
+
+	for key, value := range c.optional {
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			continue
+		}
+		if err := setConfigField(field.value, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("参数%s无效: %s", key, err)
+		}
+	}
+	for key, value := range c.values {
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			return fmt.Errorf("不支持的凭证参数: %s, 可用参数: %s", key, strings.Join(configFieldNames(fields), ", "))
+		}
+		if err := setConfigField(field.value, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("凭证参数%s无效: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+type configField struct {
+	name  string
+	value reflect.Value
+}
+
+// 收集可设置的字段, 包括嵌入结构体的字段
+func collectConfigFields(v reflect.Value, fields map[string]configField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		field := v.Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		if structField.Anonymous && field.Kind() == reflect.Struct {
+			collectConfigFields(field, fields)
+			continue
+		}
+		if isSupportedConfigField(field) {
+			fields[strings.ToLower(structField.Name)] = configField{name: structField.Name, value: field}
+		}
+	}
+}
+
+func isSupportedConfigField(field reflect.Value) bool {
+	switch field.Interface().(type) {
+	case time.Duration, *url.URL, []string:
+		return true
+	}
+	switch field.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+func configFieldNames(fields map[string]configField) []string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, field.name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case *url.URL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(u))
+		return nil
+	case []string:
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	}
+
+	return nil
+}
+
+// 时间间隔, 支持 30s、2m 格式, 纯数字为秒数
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// 取出参数, 不区分大小写
+func (c credentials) pop(key string) string {
+	for k, v := range c.values {
+		if strings.EqualFold(k, key) {
+			delete(c.values, k)
+			return strings.TrimSpace(v)
+		}
+	}
+
+	return ""
+}
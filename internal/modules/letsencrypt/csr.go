@@ -0,0 +1,98 @@
+package letsencrypt
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/go-acme/lego/v3/lego"
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/utils"
+)
+
+// 解析PEM格式的CSR并校验签名
+func ParseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(csrPEM)))
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return nil, errors.New("CSR不是有效的PEM格式")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("CSR解析失败：%s", err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("CSR签名校验失败：%s", err)
+	}
+
+	return csr, nil
+}
+
+// CSR中的域名，必须包含在域名配置中，不支持IP地址、邮箱、URI
+func csrDomains(csr *x509.CertificateRequest, config models.DomainConfig) ([]string, error) {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, errors.New("CSR只能包含域名，不支持IP地址、邮箱、URI")
+	}
+	allowed, err := ParseDomains(config.Domain)
+	if err != nil {
+		return nil, err
+	}
+	domains := make([]string, 0)
+	for _, name := range certcrypto.ExtractDomainsCSR(csr) {
+		domain, err := normalizeDomain(name)
+		if err != nil {
+			return nil, fmt.Errorf("CSR中的域名无效：%s", err)
+		}
+		if !utils.InStringSlice(allowed, domain) {
+			return nil, fmt.Errorf("CSR中的域名%s不在域名配置中", domain)
+		}
+		domains = merge(domains, []string{domain})
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("CSR中没有域名")
+	}
+
+	return domains, nil
+}
+
+// 加载CSR：优先使用csrPEM，其次是域名配置的CSR，都为空时返回nil
+func loadCSR(csrPEM string, config models.DomainConfig) (string, *x509.CertificateRequest, []string, error) {
+	if strings.TrimSpace(csrPEM) == "" {
+		csrPEM = config.Csr
+	}
+	if strings.TrimSpace(csrPEM) == "" {
+		return "", nil, nil, nil
+	}
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	domains, err := csrDomains(csr, config)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return strings.TrimSpace(csrPEM), csr, domains, nil
+}
+
+// 使用CSR申请证书，私钥由用户保管，返回的证书没有私钥
+func obtainForCSR(client *lego.Client, au *models.AcmeUser, config models.DomainConfig, csrPEM string, csr *x509.CertificateRequest, bundle bool) (*models.Certificate, error) {
+	certRes, err := client.Certificate.ObtainForCSR(*csr, bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Certificate{
+		DomainConfigId:    config.Id,
+		AcmeUserId:        au.Id,
+		Domain:            certRes.Domain,
+		CertUrl:           certRes.CertURL,
+		CertStableUrl:     certRes.CertStableURL,
+		Certificate:       string(certRes.Certificate),
+		IssuerCertificate: string(certRes.IssuerCertificate),
+		Csr:               csrPEM,
+		Domains:           strings.Join(certcrypto.ExtractDomainsCSR(csr), " "),
+	}, nil
+}
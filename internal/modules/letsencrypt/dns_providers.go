@@ -2,9 +2,15 @@ package letsencrypt
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cpu/goacmedns"
 	"github.com/go-acme/lego/v3/challenge"
 	"github.com/go-acme/lego/v3/challenge/dns01"
 	"github.com/go-acme/lego/v3/providers/dns/acmedns"
+	"github.com/go-acme/lego/v3/providers/dns/alidns"
 	"github.com/go-acme/lego/v3/providers/dns/auroradns"
 	"github.com/go-acme/lego/v3/providers/dns/azure"
 	"github.com/go-acme/lego/v3/providers/dns/bindman"
@@ -13,7 +19,6 @@ import (
 	"github.com/go-acme/lego/v3/providers/dns/cloudns"
 	"github.com/go-acme/lego/v3/providers/dns/cloudxns"
 	"github.com/go-acme/lego/v3/providers/dns/conoha"
-	"github.com/go-acme/lego/v3/providers/dns/designate"
 	"github.com/go-acme/lego/v3/providers/dns/digitalocean"
 	"github.com/go-acme/lego/v3/providers/dns/dnsimple"
 	"github.com/go-acme/lego/v3/providers/dns/dnsmadeeasy"
@@ -36,7 +41,6 @@ import (
 	"github.com/go-acme/lego/v3/providers/dns/iij"
 	"github.com/go-acme/lego/v3/providers/dns/inwx"
 	"github.com/go-acme/lego/v3/providers/dns/joker"
-	"github.com/go-acme/lego/v3/providers/dns/lightsail"
 	"github.com/go-acme/lego/v3/providers/dns/linode"
 	"github.com/go-acme/lego/v3/providers/dns/linodev4"
 	"github.com/go-acme/lego/v3/providers/dns/mydnsjp"
@@ -52,7 +56,6 @@ import (
 	"github.com/go-acme/lego/v3/providers/dns/pdns"
 	"github.com/go-acme/lego/v3/providers/dns/rackspace"
 	"github.com/go-acme/lego/v3/providers/dns/rfc2136"
-	"github.com/go-acme/lego/v3/providers/dns/route53"
 	"github.com/go-acme/lego/v3/providers/dns/sakuracloud"
 	"github.com/go-acme/lego/v3/providers/dns/selectel"
 	"github.com/go-acme/lego/v3/providers/dns/stackpath"
@@ -62,134 +65,475 @@ import (
 	"github.com/go-acme/lego/v3/providers/dns/vscale"
 	"github.com/go-acme/lego/v3/providers/dns/vultr"
 	"github.com/go-acme/lego/v3/providers/dns/zoneee"
+	"github.com/oracle/oci-go-sdk/common"
 	"github.com/ouqiang/gocron/internal/models"
-	"github.com/ouqiang/gocron/internal/modules/letsencrypt/alidns"
 )
 
 // newDNSChallengeProviderByName Factory for DNS providers
+// 服务商凭证从AccessKey读取, 使用各服务商的NewDNSProviderConfig创建, 同一服务商可以配置多个账号
+// 只能从环境变量读取凭证的服务商(designate、route53、lightsail)不支持, 避免使用进程环境中的凭证
 func newDNSChallengeProviderByName(config models.DomainConfig, ak models.AccessKey) (challenge.Provider, error) {
+	c, err := loadCredentials(config, ak)
+	if err != nil {
+		return nil, err
+	}
+
 	switch config.ProviderName {
 	case "acme-dns":
-		return acmedns.NewDNSProvider()
+		// 账号需要预先在acme-dns注册并配置好CNAME, 凭证中保存账号信息
+		apiBase := c.pop("APIBase")
+		if apiBase == "" {
+			return nil, fmt.Errorf("acme-dns凭证缺少参数: APIBase")
+		}
+		storage, err := newAcmeDNSStorage(c)
+		if err != nil {
+			return nil, err
+		}
+		return acmedns.NewDNSProviderClient(goacmedns.NewClient(apiBase), storage)
 	case "alidns":
-		return alidns.NewDNSProvider(ak)
+		cfg := alidns.NewDefaultConfig()
+		cfg.APIKey = ak.AccessKeyId
+		cfg.SecretKey = string(ak.AccessKeySecret)
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return alidns.NewDNSProviderConfig(cfg)
 	case "azure":
-		return azure.NewDNSProvider()
+		cfg := azure.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return azure.NewDNSProviderConfig(cfg)
 	case "auroradns":
-		return auroradns.NewDNSProvider()
+		cfg := auroradns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return auroradns.NewDNSProviderConfig(cfg)
 	case "bindman":
-		return bindman.NewDNSProvider()
+		cfg := bindman.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return bindman.NewDNSProviderConfig(cfg)
 	case "bluecat":
-		return bluecat.NewDNSProvider()
+		cfg := bluecat.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return bluecat.NewDNSProviderConfig(cfg)
 	case "cloudflare":
-		return cloudflare.NewDNSProvider()
+		cfg := cloudflare.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return cloudflare.NewDNSProviderConfig(cfg)
 	case "cloudns":
-		return cloudns.NewDNSProvider()
+		cfg := cloudns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return cloudns.NewDNSProviderConfig(cfg)
 	case "cloudxns":
-		return cloudxns.NewDNSProvider()
+		cfg := cloudxns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return cloudxns.NewDNSProviderConfig(cfg)
 	case "conoha":
-		return conoha.NewDNSProvider()
-	case "designate":
-		return designate.NewDNSProvider()
+		cfg := conoha.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return conoha.NewDNSProviderConfig(cfg)
+	case "designate", "route53", "lightsail":
+		// 认证参数不能通过Config设置, 只能读取环境变量或默认凭证文件
+		return nil, fmt.Errorf("服务商%s的凭证只能通过环境变量配置, 暂不支持", config.ProviderName)
 	case "digitalocean":
-		return digitalocean.NewDNSProvider()
+		cfg := digitalocean.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return digitalocean.NewDNSProviderConfig(cfg)
 	case "dnsimple":
-		return dnsimple.NewDNSProvider()
+		cfg := dnsimple.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return dnsimple.NewDNSProviderConfig(cfg)
 	case "dnsmadeeasy":
-		return dnsmadeeasy.NewDNSProvider()
+		cfg := dnsmadeeasy.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return dnsmadeeasy.NewDNSProviderConfig(cfg)
 	case "dnspod":
-		return dnspod.NewDNSProvider()
+		cfg := dnspod.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return dnspod.NewDNSProviderConfig(cfg)
 	case "dode":
-		return dode.NewDNSProvider()
+		cfg := dode.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return dode.NewDNSProviderConfig(cfg)
 	case "dreamhost":
-		return dreamhost.NewDNSProvider()
+		cfg := dreamhost.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return dreamhost.NewDNSProviderConfig(cfg)
 	case "duckdns":
-		return duckdns.NewDNSProvider()
+		cfg := duckdns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return duckdns.NewDNSProviderConfig(cfg)
 	case "dyn":
-		return dyn.NewDNSProvider()
+		cfg := dyn.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return dyn.NewDNSProviderConfig(cfg)
 	case "fastdns":
-		return fastdns.NewDNSProvider()
+		cfg := fastdns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return fastdns.NewDNSProviderConfig(cfg)
 	case "easydns":
-		return easydns.NewDNSProvider()
+		cfg := easydns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return easydns.NewDNSProviderConfig(cfg)
 	case "exec":
-		return exec.NewDNSProvider()
+		cfg := exec.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return exec.NewDNSProviderConfig(cfg)
 	case "exoscale":
-		return exoscale.NewDNSProvider()
+		cfg := exoscale.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return exoscale.NewDNSProviderConfig(cfg)
 	case "gandi":
-		return gandi.NewDNSProvider()
+		cfg := gandi.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return gandi.NewDNSProviderConfig(cfg)
 	case "gandiv5":
-		return gandiv5.NewDNSProvider()
+		cfg := gandiv5.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return gandiv5.NewDNSProviderConfig(cfg)
 	case "glesys":
-		return glesys.NewDNSProvider()
+		cfg := glesys.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return glesys.NewDNSProviderConfig(cfg)
 	case "gcloud":
-		return gcloud.NewDNSProvider()
+		// 使用服务账号的JSON密钥, 不使用Google默认凭证
+		key := c.pop("ServiceAccountKey")
+		if key == "" {
+			return nil, fmt.Errorf("gcloud凭证缺少参数: ServiceAccountKey")
+		}
+		return gcloud.NewDNSProviderServiceAccountKey([]byte(key))
 	case "godaddy":
-		return godaddy.NewDNSProvider()
+		cfg := godaddy.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return godaddy.NewDNSProviderConfig(cfg)
 	case "hostingde":
-		return hostingde.NewDNSProvider()
+		cfg := hostingde.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return hostingde.NewDNSProviderConfig(cfg)
 	case "httpreq":
-		return httpreq.NewDNSProvider()
+		cfg := httpreq.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return httpreq.NewDNSProviderConfig(cfg)
 	case "iij":
-		return iij.NewDNSProvider()
+		cfg := iij.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return iij.NewDNSProviderConfig(cfg)
 	case "inwx":
-		return inwx.NewDNSProvider()
+		cfg := inwx.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return inwx.NewDNSProviderConfig(cfg)
 	case "joker":
-		return joker.NewDNSProvider()
-	case "lightsail":
-		return lightsail.NewDNSProvider()
+		cfg := joker.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return joker.NewDNSProviderConfig(cfg)
 	case "linode":
-		return linode.NewDNSProvider()
+		cfg := linode.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return linode.NewDNSProviderConfig(cfg)
 	case "linodev4":
-		return linodev4.NewDNSProvider()
+		cfg := linodev4.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return linodev4.NewDNSProviderConfig(cfg)
 	case "manual":
 		return dns01.NewDNSProviderManual()
 	case "mydnsjp":
-		return mydnsjp.NewDNSProvider()
+		cfg := mydnsjp.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return mydnsjp.NewDNSProviderConfig(cfg)
 	case "namecheap":
-		return namecheap.NewDNSProvider()
+		cfg := namecheap.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return namecheap.NewDNSProviderConfig(cfg)
 	case "namedotcom":
-		return namedotcom.NewDNSProvider()
+		cfg := namedotcom.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return namedotcom.NewDNSProviderConfig(cfg)
 	case "namesilo":
-		return namesilo.NewDNSProvider()
+		cfg := namesilo.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return namesilo.NewDNSProviderConfig(cfg)
 	case "netcup":
-		return netcup.NewDNSProvider()
+		cfg := netcup.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return netcup.NewDNSProviderConfig(cfg)
 	case "nifcloud":
-		return nifcloud.NewDNSProvider()
+		cfg := nifcloud.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return nifcloud.NewDNSProviderConfig(cfg)
 	case "ns1":
-		return ns1.NewDNSProvider()
+		cfg := ns1.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return ns1.NewDNSProviderConfig(cfg)
 	case "oraclecloud":
-		return oraclecloud.NewDNSProvider()
+		// OCI认证参数由凭证生成ConfigurationProvider, 其余参数写入Config
+		provider, err := newOCIConfigProvider(c)
+		if err != nil {
+			return nil, err
+		}
+		cfg := oraclecloud.NewDefaultConfig()
+		cfg.OCIConfigProvider = provider
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return oraclecloud.NewDNSProviderConfig(cfg)
 	case "otc":
-		return otc.NewDNSProvider()
+		cfg := otc.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return otc.NewDNSProviderConfig(cfg)
 	case "ovh":
-		return ovh.NewDNSProvider()
+		cfg := ovh.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return ovh.NewDNSProviderConfig(cfg)
 	case "pdns":
-		return pdns.NewDNSProvider()
+		cfg := pdns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return pdns.NewDNSProviderConfig(cfg)
 	case "rackspace":
-		return rackspace.NewDNSProvider()
-	case "route53":
-		return route53.NewDNSProvider()
+		cfg := rackspace.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return rackspace.NewDNSProviderConfig(cfg)
 	case "rfc2136":
-		return rfc2136.NewDNSProvider()
+		cfg := rfc2136.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return rfc2136.NewDNSProviderConfig(cfg)
 	case "sakuracloud":
-		return sakuracloud.NewDNSProvider()
+		cfg := sakuracloud.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return sakuracloud.NewDNSProviderConfig(cfg)
 	case "stackpath":
-		return stackpath.NewDNSProvider()
+		cfg := stackpath.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return stackpath.NewDNSProviderConfig(cfg)
 	case "selectel":
-		return selectel.NewDNSProvider()
+		cfg := selectel.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return selectel.NewDNSProviderConfig(cfg)
 	case "transip":
-		return transip.NewDNSProvider()
+		cfg := transip.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return transip.NewDNSProviderConfig(cfg)
 	case "vegadns":
-		return vegadns.NewDNSProvider()
+		cfg := vegadns.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return vegadns.NewDNSProviderConfig(cfg)
 	case "versio":
-		return versio.NewDNSProvider()
+		cfg := versio.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return versio.NewDNSProviderConfig(cfg)
 	case "vultr":
-		return vultr.NewDNSProvider()
+		cfg := vultr.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return vultr.NewDNSProviderConfig(cfg)
 	case "vscale":
-		return vscale.NewDNSProvider()
+		cfg := vscale.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return vscale.NewDNSProviderConfig(cfg)
 	case "zoneee":
-		return zoneee.NewDNSProvider()
+		cfg := zoneee.NewDefaultConfig()
+		if err = c.apply(cfg); err != nil {
+			return nil, err
+		}
+		return zoneee.NewDNSProviderConfig(cfg)
 	default:
 		return nil, fmt.Errorf("unrecognized DNS provider: %s", config.ProviderName)
 	}
 }
+
+// 加载服务商凭证, 凭证必须属于域名配置的服务商
+func loadCredentials(config models.DomainConfig, ak models.AccessKey) (c credentials, err error) {
+	c.values = make(map[string]string)
+	c.optional = make(map[string]string)
+	if config.DnsTtl > 0 {
+		c.optional["TTL"] = strconv.Itoa(config.DnsTtl)
+	}
+	// dns-01等待记录生效的超时时间和检查间隔(秒)，0使用服务商默认值
+	if config.DnsPropagationTimeout > 0 {
+		c.optional["PropagationTimeout"] = strconv.Itoa(config.DnsPropagationTimeout)
+	}
+	if config.DnsPollingInterval > 0 {
+		c.optional["PollingInterval"] = strconv.Itoa(config.DnsPollingInterval)
+	}
+	if ak.Id == 0 {
+		return c, nil
+	}
+	if ak.GetProviderName() != config.ProviderName {
+		return c, fmt.Errorf("凭证#%d属于服务商%s，不能用于%s", ak.Id, ak.GetProviderName(), config.ProviderName)
+	}
+	c.values, err = ak.CredentialMap()
+	if err != nil {
+		return c, fmt.Errorf("凭证#%d的参数格式错误: %s", ak.Id, err)
+	}
+
+	return c, nil
+}
+
+// 域名配置的dns-01检查参数
+func dnsChallengeOptions(config models.DomainConfig) []dns01.ChallengeOption {
+	options := make([]dns01.ChallengeOption, 0)
+	if resolvers := config.DnsResolverList(); len(resolvers) > 0 {
+		options = append(options, dns01.AddRecursiveNameservers(dns01.ParseNameservers(resolvers)))
+	}
+	if config.HasDnsSkipFullPropagation() {
+		options = append(options, dns01.DisableCompletePropagationRequirement())
+	}
+
+	return options
+}
+
+// OCI API签名密钥, 参数与~/.oci/config一致
+func newOCIConfigProvider(c credentials) (common.ConfigurationProvider, error) {
+	keys := []string{"TenancyID", "UserID", "Region", "Fingerprint", "PrivateKey"}
+	values := make(map[string]string)
+	for _, key := range keys {
+		values[key] = c.pop(key)
+		if values[key] == "" {
+			return nil, fmt.Errorf("oraclecloud凭证缺少参数: %s", key)
+		}
+	}
+	var passphrase *string
+	if value := c.pop("PrivateKeyPassphrase"); value != "" {
+		passphrase = &value
+	}
+
+	return common.NewRawConfigurationProvider(values["TenancyID"], values["UserID"], values["Region"],
+		values["Fingerprint"], values["PrivateKey"], passphrase), nil
+}
+
+// acme-dns账号存储, 所有域名都使用凭证中的账号, 不自动注册新账号
+type acmeDNSStorage struct {
+	account goacmedns.Account
+}
+
+func newAcmeDNSStorage(c credentials) (*acmeDNSStorage, error) {
+	account := goacmedns.Account{
+		FullDomain: c.pop("FullDomain"),
+		SubDomain:  c.pop("SubDomain"),
+		Username:   c.pop("Username"),
+		Password:   c.pop("Password"),
+	}
+	if account.FullDomain == "" || account.SubDomain == "" || account.Username == "" || account.Password == "" {
+		return nil, fmt.Errorf("acme-dns凭证缺少参数: FullDomain、SubDomain、Username、Password")
+	}
+	if len(c.values) > 0 {
+		keys := make([]string, 0, len(c.values))
+		for key := range c.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("不支持的凭证参数: %s", strings.Join(keys, ", "))
+	}
+
+	return &acmeDNSStorage{account: account}, nil
+}
+
+func (s *acmeDNSStorage) Fetch(domain string) (goacmedns.Account, error) {
+	return s.account, nil
+}
+
+func (s *acmeDNSStorage) Put(domain string, account goacmedns.Account) error {
+	return fmt.Errorf("acme-dns账号需要保存在凭证中")
+}
+
+func (s *acmeDNSStorage) Save() error {
+	return fmt.Errorf("acme-dns账号需要保存在凭证中")
+}
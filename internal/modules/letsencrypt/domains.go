@@ -0,0 +1,96 @@
+package letsencrypt
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxDomainLength = 253
+	maxLabelLength  = 63
+	// letsencrypt单个证书最多100个域名
+	maxDomainCount = 100
+)
+
+// 解析域名配置，多个域名以空格隔开
+// 域名统一转为小写，国际化域名转为punycode，去重；通配符域名会同时申请主域名，如：*.a.com 会加上 a.com
+func ParseDomains(domain string) ([]string, error) {
+	fields := strings.Fields(domain)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("域名不能为空")
+	}
+
+	domains := make([]string, 0, len(fields))
+	for _, field := range fields {
+		d, err := normalizeDomain(field)
+		if err != nil {
+			return nil, err
+		}
+		domains = merge(domains, []string{d})
+		if strings.HasPrefix(d, "*.") {
+			domains = merge(domains, []string{d[2:]})
+		}
+	}
+	if len(domains) > maxDomainCount {
+		return nil, fmt.Errorf("域名数量不能超过%d个，当前%d个", maxDomainCount, len(domains))
+	}
+
+	return domains, nil
+}
+
+// 格式化并校验单个域名
+func normalizeDomain(domain string) (string, error) {
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	wildcard := strings.HasPrefix(d, "*.")
+	if wildcard {
+		d = d[2:]
+	}
+	if net.ParseIP(d) != nil {
+		return "", fmt.Errorf("不支持IP地址：%s", domain)
+	}
+
+	ascii, err := idna.Lookup.ToASCII(d)
+	if err != nil {
+		return "", fmt.Errorf("无效的域名：%s，%s", domain, err)
+	}
+	if len(ascii) > maxDomainLength {
+		return "", fmt.Errorf("域名长度不能超过%d：%s", maxDomainLength, domain)
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("无效的域名：%s", domain)
+	}
+	for _, label := range labels {
+		if !isValidLabel(label) {
+			return "", fmt.Errorf("无效的域名：%s", domain)
+		}
+	}
+
+	if wildcard {
+		return "*." + ascii, nil
+	}
+
+	return ascii, nil
+}
+
+// 域名标签只能包含字母、数字、中划线，且不能以中划线开头或结尾
+func isValidLabel(label string) bool {
+	if len(label) == 0 || len(label) > maxLabelLength {
+		return false
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
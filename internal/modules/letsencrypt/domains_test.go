@@ -0,0 +1,26 @@
+package letsencrypt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDomains(t *testing.T) {
+	domains, err := ParseDomains(" *.A.com  b.com. B.COM 中文.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"*.a.com", "a.com", "b.com", "xn--fiq228c.com"}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Fatalf("域名解析错误, 期望%v, 实际%v", expected, domains)
+	}
+}
+
+func TestParseDomainsInvalid(t *testing.T) {
+	invalid := []string{"", "com", "a_b.com", "*.*.a.com", "a.*.com", "-a.com", "127.0.0.1"}
+	for _, domain := range invalid {
+		if _, err := ParseDomains(domain); err == nil {
+			t.Fatalf("无效的域名未返回错误-%s", domain)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package letsencrypt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-acme/lego/v3/acme"
+)
+
+// 错误分类
+type ErrorCategory string
+
+const (
+	ErrorCategoryRateLimited      ErrorCategory = "rate_limited"      // CA频率限制
+	ErrorCategoryUnauthorized     ErrorCategory = "unauthorized"      // 域名所有权验证失败或账号无权限
+	ErrorCategoryDNSPropagation   ErrorCategory = "dns_propagation"   // DNS记录传播超时
+	ErrorCategoryCAAForbidden     ErrorCategory = "caa_forbidden"     // CAA记录不允许该CA签发
+	ErrorCategoryBadCSR           ErrorCategory = "bad_csr"           // CSR无效
+	ErrorCategoryChallenge        ErrorCategory = "challenge"         // 验证失败(DNS、连接、TLS等)
+	ErrorCategoryAccount          ErrorCategory = "account"           // 账号注册、EAB等问题
+	ErrorCategoryProvider         ErrorCategory = "provider"          // challenge provider配置错误
+	ErrorCategoryInvalidParameter ErrorCategory = "invalid_parameter" // 参数、证书数据错误
+	ErrorCategoryNoRenewal        ErrorCategory = "no_renewal"        // 未到续期时间
+	ErrorCategoryACME             ErrorCategory = "acme"              // 其他ACME错误
+	ErrorCategoryUnknown          ErrorCategory = "unknown"           // 其他错误
+)
+
+// ACME错误类型对应的分类，参考 https://tools.ietf.org/html/rfc8555#section-6.7
+var problemCategories = map[string]ErrorCategory{
+	"urn:ietf:params:acme:error:rateLimited":             ErrorCategoryRateLimited,
+	"urn:ietf:params:acme:error:unauthorized":            ErrorCategoryUnauthorized,
+	"urn:ietf:params:acme:error:caa":                     ErrorCategoryCAAForbidden,
+	"urn:ietf:params:acme:error:badCSR":                  ErrorCategoryBadCSR,
+	"urn:ietf:params:acme:error:dns":                     ErrorCategoryChallenge,
+	"urn:ietf:params:acme:error:connection":              ErrorCategoryChallenge,
+	"urn:ietf:params:acme:error:tls":                     ErrorCategoryChallenge,
+	"urn:ietf:params:acme:error:incorrectResponse":       ErrorCategoryChallenge,
+	"urn:ietf:params:acme:error:accountDoesNotExist":     ErrorCategoryAccount,
+	"urn:ietf:params:acme:error:externalAccountRequired": ErrorCategoryAccount,
+	"urn:ietf:params:acme:error:invalidContact":          ErrorCategoryAccount,
+	"urn:ietf:params:acme:error:unsupportedContact":      ErrorCategoryAccount,
+	"urn:ietf:params:acme:error:userActionRequired":      ErrorCategoryAccount,
+	"urn:ietf:params:acme:error:rejectedIdentifier":      ErrorCategoryInvalidParameter,
+	"urn:ietf:params:acme:error:unsupportedIdentifier":   ErrorCategoryInvalidParameter,
+	"urn:ietf:params:acme:error:malformed":               ErrorCategoryInvalidParameter,
+}
+
+// 证书操作错误，包含错误分类和ACME返回的problem document
+type Error struct {
+	Category ErrorCategory
+	// 操作，如：obtain、renew、revoke
+	Op      string
+	Problem *acme.ProblemDetails
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%s]%s失败: %s", e.Category, e.Op, e.Err)
+}
+
+// ACME problem document的说明，没有时返回原始错误
+func (e *Error) Detail() string {
+	if e.Problem == nil {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%s :: %s", e.Problem.Type, e.Problem.Detail)
+}
+
+func newError(category ErrorCategory, op string, err error) *Error {
+	return &Error{Category: category, Op: op, Err: err}
+}
+
+// 包装错误，解析ACME返回的problem document得到错误分类
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	e := newError(ErrorCategoryUnknown, op, err)
+	if problem := findProblem(err); problem != nil {
+		e.Problem = problem
+		e.Category = ErrorCategoryACME
+		if category, ok := problemCategories[problem.Type]; ok {
+			e.Category = category
+		}
+		return e
+	}
+	// 错误被转为字符串时，从错误信息中查找ACME错误类型
+	message := err.Error()
+	for problemType, category := range problemCategories {
+		if strings.Contains(message, problemType+" ") {
+			e.Category = category
+			return e
+		}
+	}
+	// dns01等待记录生效超时
+	if strings.Contains(message, "time limit exceeded") {
+		e.Category = ErrorCategoryDNSPropagation
+	}
+
+	return e
+}
+
+// 查找ACME错误，申请证书时多个域名的错误以map的形式返回
+func findProblem(err error) *acme.ProblemDetails {
+	switch e := err.(type) {
+	case *acme.ProblemDetails:
+		return e
+	case *acme.NonceError:
+		return e.ProblemDetails
+	}
+
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Map {
+		return nil
+	}
+	for _, key := range v.MapKeys() {
+		if domainErr, ok := v.MapIndex(key).Interface().(error); ok {
+			if problem := findProblem(domainErr); problem != nil {
+				return problem
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package letsencrypt
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/ouqiang/gocron/internal/models"
+	keystore "github.com/pavel-v-chernykh/keystore-go"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// 证书导出格式
+const (
+	ExportFormatCert      = "cert"      // 证书
+	ExportFormatKey       = "key"       // 私钥
+	ExportFormatChain     = "chain"     // 中间证书
+	ExportFormatFullchain = "fullchain" // 证书+中间证书
+	ExportFormatHAProxy   = "haproxy"   // 证书+中间证书+私钥，HAProxy的crt参数使用
+	ExportFormatPKCS12    = "pkcs12"    // PKCS#12，需要密码
+	ExportFormatJKS       = "jks"       // Java keystore，需要密码
+	ExportFormatZip       = "zip"       // 全部格式打包，附带manifest.json，指定密码时包含PKCS#12和JKS
+)
+
+// Java keytool要求密码至少6位
+const minKeystorePasswordLength = 6
+
+// 导出的文件
+type ExportFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// zip包中的证书信息
+type exportManifest struct {
+	CertificateId int                   `json:"certificate_id"`
+	Domain        string                `json:"domain"`
+	Domains       []string              `json:"domains"`
+	KeyType       string                `json:"key_type"`
+	SerialNumber  string                `json:"serial_number"`
+	Issuer        string                `json:"issuer"`
+	Fingerprint   string                `json:"fingerprint"`
+	NotBefore     time.Time             `json:"not_before"`
+	NotAfter      time.Time             `json:"not_after"`
+	ExportedAt    time.Time             `json:"exported_at"`
+	Files         []exportManifestEntry `json:"files"`
+}
+
+type exportManifestEntry struct {
+	Name   string `json:"name"`
+	Sha256 string `json:"sha256"`
+}
+
+// 证书拆分后的内容
+type exportBundle struct {
+	certificate models.Certificate
+	leaf        *x509.Certificate
+	chain       []*x509.Certificate
+	certPEM     []byte
+	chainPEM    []byte
+	keyPEM      []byte
+}
+
+// 按格式导出证书，PKCS#12、JKS需要密码
+func ExportCertificate(certificate models.Certificate, format, password string) (*ExportFile, error) {
+	bundle, err := newExportBundle(certificate)
+	if err != nil {
+		return nil, err
+	}
+	name := exportFileName(certificate.Domain)
+
+	switch format {
+	case ExportFormatCert:
+		return pemFile(name+".crt", bundle.certPEM), nil
+	case ExportFormatKey:
+		if len(bundle.keyPEM) == 0 {
+			return nil, errors.New("证书没有私钥")
+		}
+		return pemFile(name+".key", bundle.keyPEM), nil
+	case ExportFormatChain:
+		if len(bundle.chainPEM) == 0 {
+			return nil, errors.New("证书没有中间证书")
+		}
+		return pemFile(name+".chain.crt", bundle.chainPEM), nil
+	case ExportFormatFullchain:
+		return pemFile(name+".fullchain.crt", bundle.fullchain()), nil
+	case ExportFormatHAProxy:
+		data, err := bundle.haproxy()
+		if err != nil {
+			return nil, err
+		}
+		return pemFile(name+".pem", data), nil
+	case ExportFormatPKCS12:
+		data, err := bundle.pkcs12(password)
+		if err != nil {
+			return nil, err
+		}
+		return &ExportFile{Name: name + ".p12", ContentType: "application/x-pkcs12", Data: data}, nil
+	case ExportFormatJKS:
+		data, err := bundle.jks(password)
+		if err != nil {
+			return nil, err
+		}
+		return &ExportFile{Name: name + ".jks", ContentType: "application/octet-stream", Data: data}, nil
+	case ExportFormatZip:
+		data, err := bundle.zip(name, password)
+		if err != nil {
+			return nil, err
+		}
+		return &ExportFile{Name: name + ".zip", ContentType: "application/zip", Data: data}, nil
+	}
+
+	return nil, fmt.Errorf("不支持的导出格式：%s", format)
+}
+
+// 证书内容中的第一个证书为域名证书，其余为中间证书，没有中间证书时使用IssuerCertificate
+func newExportBundle(certificate models.Certificate) (*exportBundle, error) {
+	certificates, err := certcrypto.ParsePEMBundle([]byte(certificate.Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("证书解析失败#证书ID-%d#%s", certificate.Id, err)
+	}
+	bundle := &exportBundle{
+		certificate: certificate,
+		leaf:        certificates[0],
+		chain:       certificates[1:],
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificates[0].Raw}),
+		keyPEM:      []byte(certificate.PrivateKey),
+	}
+	if len(bundle.chain) == 0 && strings.TrimSpace(certificate.IssuerCertificate) != "" {
+		if bundle.chain, err = certcrypto.ParsePEMBundle([]byte(certificate.IssuerCertificate)); err != nil {
+			return nil, fmt.Errorf("中间证书解析失败#证书ID-%d#%s", certificate.Id, err)
+		}
+	}
+	for _, cert := range bundle.chain {
+		bundle.chainPEM = append(bundle.chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return bundle, nil
+}
+
+func (b *exportBundle) fullchain() []byte {
+	data := append([]byte{}, b.certPEM...)
+
+	return append(data, b.chainPEM...)
+}
+
+func (b *exportBundle) haproxy() ([]byte, error) {
+	if len(b.keyPEM) == 0 {
+		return nil, errors.New("证书没有私钥")
+	}
+
+	return append(b.fullchain(), b.keyPEM...), nil
+}
+
+func (b *exportBundle) pkcs12(password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("导出PKCS#12需要设置密码")
+	}
+	privateKey, err := b.privateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return pkcs12.Encode(rand.Reader, privateKey, b.leaf, b.chain, password)
+}
+
+func (b *exportBundle) jks(password string) ([]byte, error) {
+	if len(password) < minKeystorePasswordLength {
+		return nil, fmt.Errorf("导出JKS需要设置至少%d位的密码", minKeystorePasswordLength)
+	}
+	privateKey, err := b.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	chain := []keystore.Certificate{{Type: "X509", Content: b.leaf.Raw}}
+	for _, cert := range b.chain {
+		chain = append(chain, keystore.Certificate{Type: "X509", Content: cert.Raw})
+	}
+	ks := keystore.KeyStore{
+		strings.ToLower(b.certificate.Domain): &keystore.PrivateKeyEntry{
+			Entry:     keystore.Entry{CreationDate: time.Now()},
+			PrivKey:   keyDER,
+			CertChain: chain,
+		},
+	}
+	buf := new(bytes.Buffer)
+	if err = keystore.Encode(buf, ks, []byte(password)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// 打包全部格式，未指定密码时不包含PKCS#12和JKS
+func (b *exportBundle) zip(name, password string) ([]byte, error) {
+	files := []*ExportFile{
+		pemFile("cert.pem", b.certPEM),
+		pemFile("chain.pem", b.chainPEM),
+		pemFile("fullchain.pem", b.fullchain()),
+	}
+	if len(b.keyPEM) > 0 {
+		haproxy, _ := b.haproxy()
+		files = append(files, pemFile("privkey.pem", b.keyPEM), pemFile(name+".haproxy.pem", haproxy))
+	}
+	if password != "" && len(b.keyPEM) > 0 {
+		data, err := b.pkcs12(password)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &ExportFile{Name: name + ".p12", Data: data})
+		if len(password) >= minKeystorePasswordLength {
+			if data, err = b.jks(password); err != nil {
+				return nil, err
+			}
+			files = append(files, &ExportFile{Name: name + ".jks", Data: data})
+		}
+	}
+
+	manifest := exportManifest{
+		CertificateId: b.certificate.Id,
+		Domain:        b.certificate.Domain,
+		Domains:       b.leaf.DNSNames,
+		KeyType:       b.certificate.KeyType,
+		SerialNumber:  fmt.Sprintf("%x", b.leaf.SerialNumber),
+		Issuer:        b.leaf.Issuer.String(),
+		Fingerprint:   sha256Hex(b.leaf.Raw),
+		NotBefore:     b.leaf.NotBefore,
+		NotAfter:      b.leaf.NotAfter,
+		ExportedAt:    time.Now(),
+	}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, exportManifestEntry{Name: file.Name, Sha256: sha256Hex(file.Data)})
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, &ExportFile{Name: "manifest.json", Data: manifestData})
+
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+	for _, file := range files {
+		w, err := writer.Create(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(file.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (b *exportBundle) privateKey() (interface{}, error) {
+	if len(b.keyPEM) == 0 {
+		return nil, errors.New("证书没有私钥")
+	}
+	privateKey, err := certcrypto.ParsePEMPrivateKey(b.keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("私钥解析失败#证书ID-%d#%s", b.certificate.Id, err)
+	}
+
+	return privateKey, nil
+}
+
+func pemFile(name string, data []byte) *ExportFile {
+	return &ExportFile{Name: name, ContentType: "application/x-pem-file", Data: data}
+}
+
+// 通配符域名的*替换为_，如：_.example.com
+func exportFileName(domain string) string {
+	name := strings.Replace(strings.TrimSpace(domain), "*", "_", -1)
+	if name == "" {
+		return "certificate"
+	}
+
+	return name
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
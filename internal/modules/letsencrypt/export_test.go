@@ -0,0 +1,209 @@
+package letsencrypt
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/ouqiang/gocron/internal/models"
+	keystore "github.com/pavel-v-chernykh/keystore-go"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// 用parent签发证书，parent为nil时生成自签名证书
+func issueTestCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func testCATemplate(serial int64, name string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+}
+
+func testLeafTemplate(serial int64, domain string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+}
+
+func certificatePEM(certs ...*x509.Certificate) string {
+	data := make([]byte, 0)
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return string(data)
+}
+
+// 生成带私钥和中间证书的证书
+func newTestExportCertificate(t *testing.T) (models.Certificate, *x509.Certificate, *x509.Certificate, *ecdsa.PrivateKey) {
+	ca, caKey := issueTestCertificate(t, testCATemplate(1, "gocron test ca"), nil, nil)
+	leaf, key := issueTestCertificate(t, testLeafTemplate(2, "a.com"), ca, caKey)
+	certificate := models.Certificate{
+		Id:                1,
+		Domain:            "a.com",
+		KeyType:           "EC256",
+		Certificate:       certificatePEM(leaf),
+		IssuerCertificate: certificatePEM(ca),
+		PrivateKey:        models.Secret(certcrypto.PEMEncode(key)),
+	}
+
+	return certificate, leaf, ca, key
+}
+
+func TestExportPKCS12(t *testing.T) {
+	certificate, leaf, ca, key := newTestExportCertificate(t)
+
+	file, err := ExportCertificate(certificate, ExportFormatPKCS12, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, cert, caCerts, err := pkcs12.DecodeChain(file.Data, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, ok := privateKey.(*ecdsa.PrivateKey); !ok || decoded.D.Cmp(key.D) != 0 {
+		t.Fatal("PKCS#12 private key does not match")
+	}
+	if !bytes.Equal(cert.Raw, leaf.Raw) {
+		t.Fatal("PKCS#12 certificate does not match")
+	}
+	if len(caCerts) != 1 || !bytes.Equal(caCerts[0].Raw, ca.Raw) {
+		t.Fatalf("PKCS#12 chain has %d certificates, want the issuer only", len(caCerts))
+	}
+}
+
+func TestExportJKS(t *testing.T) {
+	certificate, leaf, ca, key := newTestExportCertificate(t)
+
+	file, err := ExportCertificate(certificate, ExportFormatJKS, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := keystore.Decode(bytes.NewReader(file.Data), []byte("changeit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := ks["a.com"].(*keystore.PrivateKeyEntry)
+	if !ok {
+		t.Fatalf("JKS has no private key entry for a.com: %v", ks)
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(entry.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, ok := privateKey.(*ecdsa.PrivateKey); !ok || decoded.D.Cmp(key.D) != 0 {
+		t.Fatal("JKS private key does not match")
+	}
+	if len(entry.CertChain) != 2 {
+		t.Fatalf("JKS chain has %d certificates, want 2", len(entry.CertChain))
+	}
+	if !bytes.Equal(entry.CertChain[0].Content, leaf.Raw) || !bytes.Equal(entry.CertChain[1].Content, ca.Raw) {
+		t.Fatal("JKS chain does not match")
+	}
+}
+
+func TestExportPassword(t *testing.T) {
+	certificate, _, _, _ := newTestExportCertificate(t)
+
+	tests := []struct {
+		format   string
+		password string
+	}{
+		{ExportFormatPKCS12, ""},
+		{ExportFormatJKS, ""},
+		{ExportFormatJKS, "12345"},
+	}
+	for _, test := range tests {
+		if _, err := ExportCertificate(certificate, test.format, test.password); err == nil {
+			t.Errorf("%s with password %q: expected error", test.format, test.password)
+		}
+	}
+}
+
+func TestExportZipManifest(t *testing.T) {
+	certificate, _, _, _ := newTestExportCertificate(t)
+
+	file, err := ExportCertificate(certificate, ExportFormatZip, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(file.Data), int64(len(file.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = data
+	}
+
+	manifest := new(exportManifest)
+	if err = json.Unmarshal(files["manifest.json"], manifest); err != nil {
+		t.Fatal(err)
+	}
+	// manifest以外的文件都要列出，且SHA-256与文件内容一致
+	if len(manifest.Files) != len(files)-1 {
+		t.Fatalf("manifest lists %d files, zip has %d", len(manifest.Files), len(files)-1)
+	}
+	for _, entry := range manifest.Files {
+		data, ok := files[entry.Name]
+		if !ok {
+			t.Fatalf("%s is listed in the manifest but not zipped", entry.Name)
+		}
+		if sha256Hex(data) != entry.Sha256 {
+			t.Errorf("%s sha256 = %s, manifest has %s", entry.Name, sha256Hex(data), entry.Sha256)
+		}
+	}
+	for _, name := range []string{"a.com.p12", "a.com.jks", "privkey.pem"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("zip is missing %s", name)
+		}
+	}
+}
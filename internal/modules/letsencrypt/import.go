@@ -0,0 +1,203 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 补全证书链时最多下载的中间证书数量
+const maxIssuerDownloads = 3
+
+// 中间证书最大长度
+const maxIssuerCertificateSize = 64 * 1024
+
+var issuerHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// 导入其他CA签发的证书，返回来源为external的证书，不保存
+// certPEM可以包含完整证书链，chainPEM为中间证书，顺序不限
+// 检查私钥与证书是否匹配，拒绝已过期的证书和CA证书，按签发关系排序证书链，缺少中间证书时通过AIA下载
+func ImportCertificate(certPEM, keyPEM, chainPEM string) (*models.Certificate, error) {
+	privateKey, err := certcrypto.ParsePEMPrivateKey([]byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("私钥解析失败：%s", err)
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("不支持的私钥类型")
+	}
+	certificates, err := certcrypto.ParsePEMBundle([]byte(certPEM))
+	if err != nil {
+		return nil, fmt.Errorf("证书解析失败：%s", err)
+	}
+	if strings.TrimSpace(chainPEM) != "" {
+		chain, err := certcrypto.ParsePEMBundle([]byte(chainPEM))
+		if err != nil {
+			return nil, fmt.Errorf("证书链解析失败：%s", err)
+		}
+		certificates = append(certificates, chain...)
+	}
+	certificates = uniqueCertificates(certificates)
+
+	leaf, err := findLeafCertificate(certificates, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if leaf.IsCA {
+		return nil, errors.New("不能导入CA证书")
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("证书已过期，过期时间：%s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	chain, err := buildCertificateChain(leaf, certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := leaf.Subject.CommonName
+	if domain == "" && len(leaf.DNSNames) > 0 {
+		domain = leaf.DNSNames[0]
+	}
+	if domain == "" {
+		return nil, errors.New("证书没有域名")
+	}
+	var issuerPEM []byte
+	for _, cert := range chain {
+		issuerPEM = append(issuerPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+
+	return &models.Certificate{
+		Domain:            domain,
+		Certificate:       string(append(leafPEM, issuerPEM...)),
+		IssuerCertificate: string(issuerPEM),
+		PrivateKey:        models.Secret(keyPEM),
+		Source:            models.CertificateSourceExternal,
+	}, nil
+}
+
+// 去掉重复的证书，证书链可能同时包含在certPEM和chainPEM中
+func uniqueCertificates(certificates []*x509.Certificate) []*x509.Certificate {
+	result := make([]*x509.Certificate, 0, len(certificates))
+	for _, cert := range certificates {
+		duplicate := false
+		for _, existing := range result {
+			if cert.Equal(existing) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			result = append(result, cert)
+		}
+	}
+
+	return result
+}
+
+// 查找与私钥匹配的证书
+func findLeafCertificate(certificates []*x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	expected, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certificates {
+		actual, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err == nil && bytes.Equal(actual, expected) {
+			return cert, nil
+		}
+	}
+
+	return nil, errors.New("私钥与证书不匹配")
+}
+
+// 从域名证书开始按签发关系排序中间证书，不包含根证书；提供的证书中缺少中间证书时通过AIA下载
+func buildCertificateChain(leaf *x509.Certificate, certificates []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := make([]*x509.Certificate, 0)
+	used := map[*x509.Certificate]bool{leaf: true}
+	current := leaf
+	downloads := 0
+	for !isSelfSigned(current) {
+		issuer := findIssuer(current, certificates, used)
+		if issuer == nil {
+			if downloads >= maxIssuerDownloads || len(current.IssuingCertificateURL) == 0 {
+				break
+			}
+			downloads++
+			var err error
+			if issuer, err = downloadIssuer(current); err != nil {
+				return nil, err
+			}
+		}
+		used[issuer] = true
+		if isSelfSigned(issuer) {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	for _, cert := range certificates {
+		if !used[cert] {
+			return nil, fmt.Errorf("证书链中包含无关的证书：%s", cert.Subject.String())
+		}
+	}
+
+	return chain, nil
+}
+
+func findIssuer(cert *x509.Certificate, certificates []*x509.Certificate, used map[*x509.Certificate]bool) *x509.Certificate {
+	for _, candidate := range certificates {
+		if used[candidate] {
+			continue
+		}
+		if bytes.Equal(candidate.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// 通过证书的AIA(Authority Information Access)下载颁发者证书，支持DER和PEM格式
+func downloadIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	url := cert.IssuingCertificateURL[0]
+	resp, err := issuerHTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("下载中间证书失败#%s#%s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载中间证书失败#%s#HTTP状态码-%d", url, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIssuerCertificateSize))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	issuer, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("中间证书解析失败#%s#%s", url, err)
+	}
+	if err = cert.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("下载的中间证书与证书不匹配#%s#%s", url, err)
+	}
+
+	return issuer, nil
+}
@@ -0,0 +1,80 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+func TestImportCertificate(t *testing.T) {
+	root, rootKey := issueTestCertificate(t, testCATemplate(1, "gocron test root"), nil, nil)
+	intermediate1, intermediate1Key := issueTestCertificate(t, testCATemplate(2, "gocron test intermediate 1"), root, rootKey)
+	intermediate2, intermediate2Key := issueTestCertificate(t, testCATemplate(3, "gocron test intermediate 2"), intermediate1, intermediate1Key)
+	leaf, key := issueTestCertificate(t, testLeafTemplate(4, "a.com"), intermediate2, intermediate2Key)
+
+	// 证书链顺序打乱且包含根证书，导入后按签发关系排序，不包含根证书
+	certificate, err := ImportCertificate(certificatePEM(leaf), string(certcrypto.PEMEncode(key)),
+		certificatePEM(intermediate1, root, intermediate2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Domain != "a.com" || certificate.Source != models.CertificateSourceExternal {
+		t.Fatalf("domain = %s, source = %s", certificate.Domain, certificate.Source)
+	}
+	if certificate.IssuerCertificate != certificatePEM(intermediate2, intermediate1) {
+		t.Fatal("issuer certificates are not ordered from the leaf's issuer up")
+	}
+	if certificate.Certificate != certificatePEM(leaf, intermediate2, intermediate1) {
+		t.Fatal("certificate is not the leaf followed by the ordered chain")
+	}
+	if !bytes.Equal([]byte(certificate.PrivateKey), certcrypto.PEMEncode(key)) {
+		t.Fatal("private key is not kept")
+	}
+}
+
+func TestImportCertificateKeyMismatch(t *testing.T) {
+	ca, caKey := issueTestCertificate(t, testCATemplate(1, "gocron test ca"), nil, nil)
+	leaf, _ := issueTestCertificate(t, testLeafTemplate(2, "a.com"), ca, caKey)
+	_, otherKey := issueTestCertificate(t, testLeafTemplate(3, "b.com"), ca, caKey)
+
+	_, err := ImportCertificate(certificatePEM(leaf), string(certcrypto.PEMEncode(otherKey)), certificatePEM(ca))
+	if err == nil {
+		t.Fatal("expected error when the private key does not match the certificate")
+	}
+}
+
+func TestImportCertificateExpired(t *testing.T) {
+	ca, caKey := issueTestCertificate(t, testCATemplate(1, "gocron test ca"), nil, nil)
+	template := testLeafTemplate(2, "a.com")
+	template.NotBefore = time.Now().Add(-48 * time.Hour)
+	template.NotAfter = time.Now().Add(-time.Hour)
+	leaf, key := issueTestCertificate(t, template, ca, caKey)
+
+	_, err := ImportCertificate(certificatePEM(leaf), string(certcrypto.PEMEncode(key)), certificatePEM(ca))
+	if err == nil {
+		t.Fatal("expected error when importing an expired certificate")
+	}
+}
+
+func TestImportCertificateCA(t *testing.T) {
+	ca, caKey := issueTestCertificate(t, testCATemplate(1, "gocron test ca"), nil, nil)
+
+	_, err := ImportCertificate(certificatePEM(ca), string(certcrypto.PEMEncode(caKey)), "")
+	if err == nil {
+		t.Fatal("expected error when importing a CA certificate")
+	}
+}
+
+func TestImportCertificateUnrelated(t *testing.T) {
+	ca, caKey := issueTestCertificate(t, testCATemplate(1, "gocron test ca"), nil, nil)
+	leaf, key := issueTestCertificate(t, testLeafTemplate(2, "a.com"), ca, caKey)
+	other, _ := issueTestCertificate(t, testCATemplate(3, "gocron other ca"), nil, nil)
+
+	_, err := ImportCertificate(certificatePEM(leaf), string(certcrypto.PEMEncode(key)), certificatePEM(ca, other))
+	if err == nil {
+		t.Fatal("expected error when the chain contains an unrelated certificate")
+	}
+}
@@ -0,0 +1,42 @@
+package letsencrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/ouqiang/gocron/internal/models"
+)
+
+// 按私钥类型生成证书私钥
+func generatePrivateKey(keyType string) (crypto.PrivateKey, error) {
+	switch keyType {
+	case "", models.KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case models.KeyTypeRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case models.KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case models.KeyTypeEC256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case models.KeyTypeEC384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	}
+
+	return nil, fmt.Errorf("不支持的私钥类型：%s", keyType)
+}
+
+// 私钥的类型，用于判断重用的私钥是否与配置一致
+func privateKeyType(privateKey crypto.PrivateKey) string {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return fmt.Sprintf("RSA%d", key.N.BitLen())
+	case *ecdsa.PrivateKey:
+		return fmt.Sprintf("EC%d", key.Curve.Params().BitSize)
+	}
+
+	return ""
+}
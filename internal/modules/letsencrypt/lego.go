@@ -5,18 +5,21 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/go-acme/lego/v3/acme"
+	"github.com/go-acme/lego/v3/acme/api"
 	"github.com/go-acme/lego/v3/certcrypto"
 	"github.com/go-acme/lego/v3/certificate"
 	"github.com/go-acme/lego/v3/lego"
 	"github.com/go-acme/lego/v3/registration"
 	"github.com/ouqiang/gocron/internal/models"
 	"github.com/ouqiang/gocron/internal/modules/logger"
+	"github.com/ouqiang/gocron/internal/modules/utils"
+	"net/http"
+	"strings"
 	"time"
-
-	"log"
 )
 
 type tmpUser struct {
@@ -39,10 +42,9 @@ func (u *tmpUser) GetPrivateKey() crypto.PrivateKey {
 // newUser bool 表示是否是新用户，如果是新用户就需要创建private key
 func getUserByAcmeUser(au *models.AcmeUser, newUser bool) (u *tmpUser, err error) {
 	var pk crypto.PrivateKey
-	var r registration.Resource
 	if len(au.PrivateKey) > 0 {
-		if pk, err = certcrypto.ParsePEMBundle([]byte(au.PrivateKey)); err != nil {
-			logger.Warnf("Error while loading the certificate for domain %s\n\t%v", au.Email, err)
+		if pk, err = certcrypto.ParsePEMPrivateKey([]byte(au.PrivateKey)); err != nil {
+			logger.Warnf("Error while loading the private key for account %s\n\t%v", au.Email, err)
 			return
 		}
 	} else if newUser { // 新用户
@@ -52,102 +54,152 @@ func getUserByAcmeUser(au *models.AcmeUser, newUser bool) (u *tmpUser, err error
 			return
 		}
 	} else {
-		if len(au.Resource) > 0 {
-			if err = json.Unmarshal([]byte(au.Resource), &r); err != nil {
-				return
-			}
-		} else {
-			return nil, fmt.Errorf("缺少用户的Account数据，请检查数据是否完整！！！")
+		return nil, fmt.Errorf("缺少用户的私钥数据，请检查数据是否完整！！！")
+	}
+
+	// 已注册的账号直接使用保存的Account数据，不需要重复注册
+	var r *registration.Resource
+	if len(au.Resource) > 0 {
+		r = new(registration.Resource)
+		if err = json.Unmarshal([]byte(au.Resource), r); err != nil {
+			return
 		}
 	}
-	u = &tmpUser{au, pk, &r}
+	// 缺少Account数据时Registration为nil，由调用方通过账号私钥从CA恢复或重新注册
+	u = &tmpUser{au, pk, r}
 	return
 }
-func newConfig(user registration.User) *lego.Config {
+func newConfig(user *tmpUser) (*lego.Config, error) {
 	vconfig := lego.NewConfig(user)
-	// This CA URL is configured for a local dev instance of Boulder running in Docker in a VM.
-	// config.CADirURL = "http://192.168.99.100:4000/directory"
-	// vconfig.CADirURL = "https://acme-v02.api.letsencrypt.org/directory" // 正式api， 有限制：https://letsencrypt.org/docs/rate-limits/
-	vconfig.CADirURL = "https://acme-staging-v02.api.letsencrypt.org/directory" // letsencrypt官方测试api，说明：https://letsencrypt.org/docs/staging-environment/
-	vconfig.Certificate.KeyType = certcrypto.RSA2048
-	return vconfig
-}
-
-// 申请新证书
-func ObtainCertificate(au *models.AcmeUser, config models.DomainConfig, ak models.AccessKey) (result *models.Certificate, err error) {
-	myUser, err1 := getUserByAcmeUser(au, true)
-	if err1 != nil {
-		return nil, err1
-	}
-
-	// A client facilitates communication with the CA server.
-	client, err := lego.NewClient(newConfig(myUser))
+	caDirURL, err := ResolveCADirURL(user.AcmeUser.CaDirUrl)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
-
-	provider, err := newDNSChallengeProviderByName(config, ak)
+	vconfig.CADirURL = caDirURL
+	httpClient, err := newCAHTTPClient(user.AcmeUser)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
+	if httpClient != nil {
+		vconfig.HTTPClient = httpClient
+	}
+	return vconfig, nil
+}
 
-	err = client.Challenge.SetDNS01Provider(provider)
+// 创建访问CA的客户端
+func newClient(user *tmpUser) (*lego.Client, error) {
+	config, err := newConfig(user)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
-	// We specify an http port of 5002 and an tls port of 5001 on all interfaces
-	// because we aren't running as root and can't bind a listener to port 80 and 443
-	// (used later when we attempt to pass challenges). Keep in mind that you still
-	// need to proxy challenge traffic to port 5002 and 5001.
-	// err = client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", "5002"))
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// err = client.Challenge.SetTLSALPN01Provider(tlsalpn01.NewProviderServer("", "5001"))
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+	return lego.NewClient(config)
+}
+
+const (
+	opObtain   = "申请证书"
+	opRenew    = "续期证书"
+	opRevoke   = "注销证书"
+	opRegister = "注册账号"
+)
 
-	// New users will need to register
-	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+// 申请新证书，同时申请RSA和ECDSA证书时返回多个证书，第一个为主证书
+// csrPEM不为空或域名配置了CSR时使用CSR申请，只返回一个没有私钥的证书，csrPEM优先
+func ObtainCertificate(au *models.AcmeUser, config models.DomainConfig, ak models.AccessKey, csrPEM string) (results []*models.Certificate, err error) {
+	domains, err := ParseDomains(config.Domain)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, newError(ErrorCategoryInvalidParameter, opObtain, err)
+	}
+	csrPEM, csr, requestDomains, err := loadCSR(csrPEM, config)
+	if err != nil {
+		return nil, newError(ErrorCategoryInvalidParameter, opObtain, err)
+	}
+	if csr != nil {
+		domains = requestDomains
 	}
-	myUser.Registration = reg
-	// 存储用户的密钥信息
-	_ = au.SaveResourceAndPrivateKey(myUser.PrivateKey, reg)
 
-	request := certificate.ObtainRequest{
-		Domains: []string{"*.scweiqu.com"},
-		Bundle:  true,
+	if au.IsDeactivated() {
+		return nil, newError(ErrorCategoryAccount, opObtain, fmt.Errorf("账号已停用#%s#状态-%s", au.Email, au.Status))
 	}
-	certificates, err := client.Certificate.Obtain(request)
+	myUser, err := getUserByAcmeUser(au, true)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, newError(ErrorCategoryAccount, opObtain, err)
 	}
 
-	// Each certificate comes back with the cert bytes, the bytes of the client's
-	// private key, and a certificate URL. SAVE THESE TO DISK.
-	// fmt.Printf("%#v\n", certificates)
-	result = &models.Certificate{
-		Domain:            certificates.Domain,
-		CertUrl:           certificates.CertURL,
-		CertStableUrl:     certificates.CertStableURL,
-		Certificate:       string(certificates.Certificate),
-		PrivateKey:        string(certificates.PrivateKey),
-		IssuerCertificate: string(certificates.IssuerCertificate),
+	// A client facilitates communication with the CA server.
+	client, err := newClient(myUser)
+	if err != nil {
+		return nil, wrapError(opObtain, err)
+	}
+
+	if err = setChallengeProvider(client, config, ak, domains); err != nil {
+		return nil, newError(ErrorCategoryProvider, opObtain, err)
+	}
+
+	// 已有私钥但缺少Account数据时先从CA恢复账号
+	if myUser.Registration == nil && len(au.PrivateKey) > 0 {
+		if err = resolveAccount(client, au, myUser); err != nil {
+			logger.Warnf("通过账号私钥恢复账号失败，重新注册#%s#%s", au.Email, err)
+		}
+	}
+	// New users will need to register
+	if myUser.Registration == nil {
+		reg, err := register(client, au)
+		if err != nil {
+			return nil, wrapError(opRegister, err)
+		}
+		myUser.Registration = reg
+		// 存储用户的密钥信息
+		if err = au.SaveResourceAndPrivateKey(myUser.PrivateKey, reg); err != nil {
+			logger.Errorf("保存ACME账号信息失败#%s#%s", au.Email, err)
+		}
+	}
+
+	if csr != nil {
+		result, err := obtainForCSR(client, au, config, csrPEM, csr, true)
+		if err != nil {
+			return nil, wrapError(opObtain, err)
+		}
+		return []*models.Certificate{result}, nil
+	}
+
+	// 同时申请RSA和ECDSA证书时，每种私钥类型各申请一个证书
+	for _, keyType := range config.KeyTypes() {
+		privateKey, err := generatePrivateKey(keyType)
+		if err != nil {
+			return nil, newError(ErrorCategoryInvalidParameter, opObtain, err)
+		}
+		request := certificate.ObtainRequest{
+			Domains:    domains,
+			Bundle:     true,
+			PrivateKey: privateKey,
+			MustStaple: config.HasMustStaple(),
+		}
+		certificates, err := client.Certificate.Obtain(request)
+		if err != nil {
+			return nil, wrapError(opObtain, err)
+		}
+
+		// Each certificate comes back with the cert bytes, the bytes of the client's
+		// private key, and a certificate URL.
+		results = append(results, &models.Certificate{
+			DomainConfigId:    config.Id,
+			AcmeUserId:        au.Id,
+			KeyType:           keyType,
+			Domain:            certificates.Domain,
+			CertUrl:           certificates.CertURL,
+			CertStableUrl:     certificates.CertStableURL,
+			Certificate:       string(certificates.Certificate),
+			PrivateKey:        models.Secret(certificates.PrivateKey),
+			IssuerCertificate: string(certificates.IssuerCertificate),
+			Domains:           strings.Join(domains, " "),
+		})
 	}
 	return
 }
 
 // 证书续期
-// defaultRenewDay int  续期天数
+// 续期时间由ScheduleRenewal计算，CA支持ARI时使用CA建议的时间，否则按DefaultRenewDay
 // reuseKey bool  实现重用现有的私钥
 // bundle bool  ???
 // mustStaple bool  ???
@@ -159,32 +211,54 @@ func RenewCertificate(au *models.AcmeUser, config models.DomainConfig, ak models
 	// as web servers would not be able to work with a combined file.
 	certificates, err := certcrypto.ParsePEMBundle([]byte(certificateData.Certificate))
 	if err != nil {
-		log.Fatalf("Error while loading the certificate for domain %s\n\t%v", domain, err)
-		return nil, err
+		return nil, newError(ErrorCategoryInvalidParameter, opRenew, fmt.Errorf("证书解析失败#%s#%s", domain, err))
 	}
 
 	cert := certificates[0]
 
-	if !needRenewal(cert, domain, config.DefaultRenewDay) {
-		return nil, fmt.Errorf("时间还充足，不允许续期证书！")
+	renewal, err := ScheduleRenewal(au, config, &certificateData)
+	if err != nil {
+		return nil, newError(ErrorCategoryInvalidParameter, opRenew, err)
+	}
+	if !renewal {
+		return nil, newError(ErrorCategoryNoRenewal, opRenew, fmt.Errorf("时间还充足，不允许续期证书！"))
 	}
 
 	// This is just meant to be informal for the tmpUser.
 	timeLeft := cert.NotAfter.Sub(time.Now().UTC())
 	logger.Debugf("[%s] acme: Trying renewal with %d hours remaining", domain, int(timeLeft.Hours()))
 
-	certDomains := certcrypto.ExtractDomains(cert)
+	// 使用申请证书时保存的域名，保证续期的证书与原证书域名一致
+	certDomains := strings.Fields(certificateData.Domains)
+	if len(certDomains) == 0 {
+		certDomains = certcrypto.ExtractDomains(cert)
+	}
+
+	// 没有私钥的证书使用CSR申请，续期时使用证书保存的CSR，没有时使用域名配置的CSR
+	if !certificateData.HasPrivateKey() {
+		return renewForCSR(au, config, ak, certificateData)
+	}
+
+	// 续期证书的私钥类型与原证书一致，原证书的类型已不在配置中时使用配置的主证书类型
+	keyTypes := config.KeyTypes()
+	keyType := certificateData.KeyType
+	if !utils.InStringSlice(keyTypes, keyType) {
+		keyType = keyTypes[0]
+	}
 
 	var privateKey crypto.PrivateKey
 	if config.HasReuseKey() {
 		if privateKey, err = certcrypto.ParsePEMPrivateKey([]byte(certificateData.PrivateKey)); err != nil {
-			return nil, err
+			return nil, newError(ErrorCategoryInvalidParameter, opRenew, fmt.Errorf("私钥解析失败#%s#%s", domain, err))
 		}
-	} else {
-		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err != nil {
-			log.Fatal(err)
-			return nil, err
+		if privateKeyType(privateKey) != keyType {
+			logger.Infof("[%s] 原证书私钥类型%s与配置的%s不一致，重新生成私钥", domain, privateKeyType(privateKey), keyType)
+			privateKey = nil
+		}
+	}
+	if privateKey == nil {
+		if privateKey, err = generatePrivateKey(keyType); err != nil {
+			return nil, newError(ErrorCategoryInvalidParameter, opRenew, err)
 		}
 	}
 
@@ -195,89 +269,187 @@ func RenewCertificate(au *models.AcmeUser, config models.DomainConfig, ak models
 		MustStaple: config.HasMustStaple(),
 	}
 
-	myUser, err1 := getUserByAcmeUser(au, false)
-	if err1 != nil {
-		return nil, err1
-	}
-
-	// A client facilitates communication with the CA server.
-	client, err := lego.NewClient(newConfig(myUser))
+	_, client, err := loadAccount(au, opRenew)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
-	// 提供dns-01 challenge
-	provider, err := newDNSChallengeProviderByName(config, ak)
-	if err != nil {
-		log.Fatal(err)
-		return nil, err
+	if err = setChallengeProvider(client, config, ak, certDomains); err != nil {
+		return nil, newError(ErrorCategoryProvider, opRenew, err)
 	}
 
-	err = client.Challenge.SetDNS01Provider(provider)
+	certRes, err := client.Certificate.Obtain(request)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
-	}
-	// end
-
-	certRes, errR := client.Certificate.Obtain(request)
-	if errR != nil {
-		log.Fatal(errR)
-		return nil, errR
+		return nil, wrapError(opRenew, err)
 	}
 	result = &models.Certificate{
+		DomainConfigId:    config.Id,
+		AcmeUserId:        au.Id,
+		KeyType:           keyType,
 		Domain:            certRes.Domain,
 		CertUrl:           certRes.CertURL,
 		CertStableUrl:     certRes.CertStableURL,
 		Certificate:       string(certRes.Certificate),
-		PrivateKey:        string(certRes.PrivateKey),
+		PrivateKey:        models.Secret(certRes.PrivateKey),
 		IssuerCertificate: string(certRes.IssuerCertificate),
+		Domains:           strings.Join(certDomains, " "),
 	}
 	return
 
 }
 
+// 使用CSR续期证书
+func renewForCSR(au *models.AcmeUser, config models.DomainConfig, ak models.AccessKey, certificateData models.Certificate) (*models.Certificate, error) {
+	csrPEM, csr, domains, err := loadCSR(certificateData.Csr, config)
+	if err != nil {
+		return nil, newError(ErrorCategoryInvalidParameter, opRenew, err)
+	}
+	if csr == nil {
+		return nil, newError(ErrorCategoryInvalidParameter, opRenew, fmt.Errorf("证书没有私钥和CSR，不能续期#证书ID-%d", certificateData.Id))
+	}
+
+	_, client, err := loadAccount(au, opRenew)
+	if err != nil {
+		return nil, err
+	}
+	if err = setChallengeProvider(client, config, ak, domains); err != nil {
+		return nil, newError(ErrorCategoryProvider, opRenew, err)
+	}
+	result, err := obtainForCSR(client, au, config, csrPEM, csr, config.HasBundle())
+	if err != nil {
+		return nil, wrapError(opRenew, err)
+	}
+
+	return result, nil
+}
+
 // 证书注销
-func RevokeCertificate(au *models.AcmeUser, config *models.DomainConfig, certificateData models.Certificate) (result *models.Certificate, err error) {
+// 使用账号私钥签名；账号丢失(au为nil或缺少账号数据)或useCertificateKey为true时使用证书私钥签名
+// caDirURL为空时使用账号的CA
+func RevokeCertificate(au *models.AcmeUser, caDirURL string, certificateData models.Certificate, reason uint, useCertificateKey bool) (err error) {
+	logger.Infof("Trying to revoke certificate for domain %s", certificateData.Domain)
+
+	certificates, err := certcrypto.ParsePEMBundle([]byte(certificateData.Certificate))
+	if err != nil {
+		return newError(ErrorCategoryInvalidParameter, opRevoke, fmt.Errorf("证书解析失败#%s#%s", certificateData.Domain, err))
+	}
+	var httpClient *http.Client
+	if au != nil {
+		if caDirURL == "" {
+			caDirURL = au.CaDirUrl
+		}
+		if httpClient, err = newCAHTTPClient(au); err != nil {
+			return newError(ErrorCategoryAccount, opRevoke, err)
+		}
+	}
+	if caDirURL, err = ResolveCADirURL(caDirURL); err != nil {
+		return newError(ErrorCategoryInvalidParameter, opRevoke, err)
+	}
 
-	log.Printf("Trying to revoke certificate for domain %s", certificateData.Domain)
+	// 使用账号私钥签名时kid为账号URL；kid为空时lego在请求中携带证书公钥(jwk)
+	var key crypto.PrivateKey
+	var kid string
+	if au != nil && !useCertificateKey {
+		myUser, _, err := loadAccount(au, opRevoke)
+		if err != nil {
+			logger.Warnf("[%s] 账号数据不可用，使用证书私钥注销#%s", certificateData.Domain, err)
+		} else {
+			key, kid = myUser.PrivateKey, myUser.Registration.URI
+		}
+	}
+	if key == nil {
+		if !certificateData.HasPrivateKey() {
+			return newError(ErrorCategoryInvalidParameter, opRevoke, fmt.Errorf("证书没有私钥，只能使用账号注销#%s", certificateData.Domain))
+		}
+		if key, err = certcrypto.ParsePEMPrivateKey([]byte(certificateData.PrivateKey)); err != nil {
+			return newError(ErrorCategoryInvalidParameter, opRevoke, fmt.Errorf("证书私钥解析失败，无法使用证书私钥注销#%s#%s", certificateData.Domain, err))
+		}
+	}
 
-	myUser, err1 := getUserByAcmeUser(au, false)
-	if err1 != nil {
-		return nil, err1
+	// lego的Client只能使用账号私钥注销证书且不能指定原因，直接使用acme/api
+	config := lego.NewConfig(nil)
+	if httpClient != nil {
+		config.HTTPClient = httpClient
 	}
-	// A client facilitates communication with the CA server.
-	client, err := lego.NewClient(newConfig(myUser))
+	core, err := api.New(config.HTTPClient, config.UserAgent, caDirURL, kid, key)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return wrapError(opRevoke, err)
 	}
-	err = client.Certificate.Revoke([]byte(certificateData.Certificate))
+	err = core.Certificates.Revoke(acme.RevokeCertMessage{
+		Certificate: base64.RawURLEncoding.EncodeToString(certificates[0].Raw),
+		Reason:      &reason,
+	})
 	if err != nil {
-		log.Fatalf("Error while revoking the certificate for domain %s\n\t%v", certificateData.Domain, err)
+		logger.Errorf("Error while revoking the certificate for domain %s\n\t%v", certificateData.Domain, err)
+		return wrapError(opRevoke, err)
 	}
 
-	log.Println("Certificate was revoked.")
-	return
+	logger.Infof("Certificate for domain %s was revoked.", certificateData.Domain)
+	return nil
 }
 
-func needRenewal(x509Cert *x509.Certificate, domain string, days int) bool {
-	if x509Cert.IsCA {
-		log.Fatalf("[%s] Certificate bundle starts with a CA certificate", domain)
+// 按域名配置的验证方式设置challenge provider
+func setChallengeProvider(client *lego.Client, config models.DomainConfig, ak models.AccessKey, domains []string) error {
+	switch config.GetChallengeType() {
+	case models.ChallengeDNS01:
+		provider, err := newDNSChallengeProviderByName(config, ak)
+		if err != nil {
+			return err
+		}
+		return client.Challenge.SetDNS01Provider(provider, dnsChallengeOptions(config)...)
+	case models.ChallengeHTTP01:
+		if err := checkWildcardDomains(domains); err != nil {
+			return err
+		}
+		provider, err := newNodeChallengeProvider(config)
+		if err != nil {
+			return err
+		}
+		return client.Challenge.SetHTTP01Provider(provider)
+	case models.ChallengeTLSALPN01:
+		if err := checkWildcardDomains(domains); err != nil {
+			return err
+		}
+		provider, err := newTLSALPNProvider(config)
+		if err != nil {
+			return err
+		}
+		return client.Challenge.SetTLSALPN01Provider(provider)
+	}
+
+	return fmt.Errorf("不支持的验证方式：%s", config.ChallengeType)
+}
+
+// 通配符域名只能使用dns-01验证
+func checkWildcardDomains(domains []string) error {
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "*.") {
+			return fmt.Errorf("通配符域名%s只能使用dns-01验证", domain)
+		}
 	}
 
-	if days >= 0 {
-		notAfter := int(time.Until(x509Cert.NotAfter).Hours() / 24.0)
-		if notAfter > days {
-			log.Printf("[%s] The certificate expires in %d days, the number of days defined to perform the renewal is %d: no renewal.",
-				domain, notAfter, days)
-			return false
+	return nil
+}
+
+// 注册账号，配置了EAB时使用External Account Binding注册
+func register(client *lego.Client, au *models.AcmeUser) (*registration.Resource, error) {
+	if (au.EabKid == "") != (au.EabHmacKey == "") {
+		return nil, newError(ErrorCategoryAccount, opRegister, fmt.Errorf("EAB的Key ID和HMAC Key必须同时配置#账号-%s", au.Email))
+	}
+	if !au.HasExternalAccountBinding() {
+		if client.GetExternalAccountRequired() {
+			return nil, newError(ErrorCategoryAccount, opRegister, fmt.Errorf("CA要求使用EAB(External Account Binding)注册，请为账号%s配置EAB的Key ID和HMAC Key", au.Email))
 		}
+		return client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
 	}
 
-	return true
+	return client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+		TermsOfServiceAgreed: true,
+		Kid:                  au.EabKid,
+		HmacEncoded:          string(au.EabHmacKey),
+	})
 }
+
 func merge(prevDomains []string, nextDomains []string) []string {
 	for _, next := range nextDomains {
 		var found bool
@@ -0,0 +1,110 @@
+package letsencrypt
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-acme/lego/v3/challenge"
+	"github.com/go-acme/lego/v3/challenge/tlsalpn01"
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+	rpcClient "github.com/ouqiang/gocron/internal/modules/rpc/client"
+	pb "github.com/ouqiang/gocron/internal/modules/rpc/proto"
+)
+
+// http-01、tls-alpn-01 challenge provider，通过gRPC把验证内容推送到gocron-node节点
+// http-01: 节点使用内置的http服务提供验证内容，或写入到网站根目录下的 /.well-known/acme-challenge/
+// tls-alpn-01: 节点使用内置的tls服务提供验证证书
+type nodeChallengeProvider struct {
+	challengeType string
+	hosts         []models.Host
+	webroot       string
+}
+
+func newNodeChallengeProvider(config models.DomainConfig) (*nodeChallengeProvider, error) {
+	hosts, err := loadChallengeHosts(config)
+	if err != nil {
+		return nil, err
+	}
+	provider := &nodeChallengeProvider{
+		challengeType: config.GetChallengeType(),
+		hosts:         hosts,
+	}
+	if provider.challengeType == models.ChallengeHTTP01 {
+		provider.webroot = strings.TrimSpace(config.Webroot)
+	}
+
+	return provider, nil
+}
+
+// 加载提供验证内容的主机
+func loadChallengeHosts(config models.DomainConfig) ([]models.Host, error) {
+	hostModel := new(models.Host)
+	hosts, err := hostModel.ListByIds(config.HostIdList())
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s验证需要选择提供验证内容的主机", config.GetChallengeType())
+	}
+
+	return hosts, nil
+}
+
+// 所有节点都部署成功才返回，任一节点失败时CA可能访问到该节点导致验证失败
+func (p *nodeChallengeProvider) Present(domain, token, keyAuth string) error {
+	req := p.request(domain, token, keyAuth)
+	for _, host := range p.hosts {
+		if err := rpcClient.PresentChallenge(host.Name, host.Port, req); err != nil {
+			return fmt.Errorf("主机[%s-%s:%d]部署%s验证内容失败: %s", host.Alias, host.Name, host.Port, p.challengeType, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *nodeChallengeProvider) CleanUp(domain, token, keyAuth string) error {
+	req := p.request(domain, token, keyAuth)
+	var lastErr error
+	for _, host := range p.hosts {
+		if err := rpcClient.CleanUpChallenge(host.Name, host.Port, req); err != nil {
+			logger.Warnf("主机[%s-%s:%d]删除%s验证内容失败: %s", host.Alias, host.Name, host.Port, p.challengeType, err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func (p *nodeChallengeProvider) request(domain, token, keyAuth string) *pb.ChallengeRequest {
+	return &pb.ChallengeRequest{
+		Type:    p.challengeType,
+		Domain:  domain,
+		Token:   token,
+		KeyAuth: keyAuth,
+		Webroot: p.webroot,
+	}
+}
+
+// tls-alpn-01 challenge provider
+// 选择了主机时由gocron-node提供验证证书，否则在调度器上启动lego内置的tls服务
+func newTLSALPNProvider(config models.DomainConfig) (challenge.Provider, error) {
+	if len(config.HostIdList()) > 0 {
+		provider, err := newNodeChallengeProvider(config)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+	addr := strings.TrimSpace(config.ChallengeAddr)
+	if addr == "" {
+		return nil, fmt.Errorf("tls-alpn-01验证需要选择提供验证内容的主机或配置调度器监听地址")
+	}
+	iface, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的监听地址：%s，%s", addr, err)
+	}
+
+	return tlsalpn01.NewProviderServer(iface, port), nil
+}
@@ -0,0 +1,113 @@
+package letsencrypt
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/ouqiang/gocron/internal/models"
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSP状态
+const (
+	OCSPStatusGood    = "good"
+	OCSPStatusRevoked = "revoked"
+	OCSPStatusUnknown = "unknown"
+)
+
+const ocspTimeout = 30 * time.Second
+
+// OCSP响应最大长度
+const maxOCSPResponseSize = 1024 * 1024
+
+var ocspHTTPClient = &http.Client{Timeout: ocspTimeout}
+
+// OCSP查询结果
+type OCSPResult struct {
+	Status string
+	// DER编码的OCSP响应，可直接用作nginx的ssl_stapling_file
+	Raw        []byte
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+	// 注销原因，参考RFC 5280 CRLReason
+	RevocationReason int
+}
+
+// 查询证书的OCSP状态，颁发者证书优先使用证书链中的第二个证书
+func FetchOCSP(certificate models.Certificate) (*OCSPResult, error) {
+	certificates, err := certcrypto.ParsePEMBundle([]byte(certificate.Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("证书解析失败#证书ID-%d#%s", certificate.Id, err)
+	}
+	leaf := certificates[0]
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("证书没有OCSP服务器地址#证书ID-%d", certificate.Id)
+	}
+	var issuer *x509.Certificate
+	if len(certificates) > 1 {
+		issuer = certificates[1]
+	} else if issuer, err = parseIssuerCertificate(certificate.IssuerCertificate); err != nil {
+		return nil, fmt.Errorf("颁发者证书解析失败#证书ID-%d#%s", certificate.Id, err)
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := postOCSPRequest(leaf.OCSPServer[0], request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP响应解析失败#%s#%s", leaf.OCSPServer[0], err)
+	}
+
+	result := &OCSPResult{
+		Status:           OCSPStatusUnknown,
+		Raw:              raw,
+		ThisUpdate:       response.ThisUpdate,
+		NextUpdate:       response.NextUpdate,
+		RevokedAt:        response.RevokedAt,
+		RevocationReason: response.RevocationReason,
+	}
+	switch response.Status {
+	case ocsp.Good:
+		result.Status = OCSPStatusGood
+	case ocsp.Revoked:
+		result.Status = OCSPStatusRevoked
+	}
+
+	return result, nil
+}
+
+func parseIssuerCertificate(issuerCertificate string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(issuerCertificate))
+	if block == nil {
+		return nil, errors.New("颁发者证书不是有效的PEM格式")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func postOCSPRequest(server string, request []byte) ([]byte, error) {
+	resp, err := ocspHTTPClient.Post(server, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP服务器无法访问#%s#%s", server, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP服务器返回错误#%s#HTTP状态码-%d", server, resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+}
@@ -0,0 +1,155 @@
+package letsencrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"golang.org/x/crypto/ocsp"
+)
+
+// 本地OCSP服务器，按revoked返回证书状态
+type testOCSPResponder struct {
+	issuer    *x509.Certificate
+	issuerKey crypto.Signer
+	revoked   bool
+}
+
+func (r *testOCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now.Add(-time.Hour),
+		NextUpdate:   now.Add(72 * time.Hour),
+	}
+	if r.revoked {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = now.Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	resp, err := ocsp.CreateResponse(r.issuer, r.issuer, template, r.issuerKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	_, _ = w.Write(resp)
+}
+
+// 生成CA和由CA签发的证书，证书的OCSP服务器地址为ocspServer
+func newTestCertificate(t *testing.T, ocspServer string) (models.Certificate, *x509.Certificate, crypto.Signer) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gocron test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "a.com"},
+		DNSNames:     []string{"a.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		OCSPServer:   []string{ocspServer},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate := models.Certificate{
+		Certificate:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		IssuerCertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+	}
+
+	return certificate, ca, caKey
+}
+
+func TestFetchOCSP(t *testing.T) {
+	responder := new(testOCSPResponder)
+	server := httptest.NewServer(responder)
+	defer server.Close()
+	certificate, ca, caKey := newTestCertificate(t, server.URL)
+	responder.issuer = ca
+	responder.issuerKey = caKey
+
+	result, err := FetchOCSP(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != OCSPStatusGood {
+		t.Fatalf("status = %s, want %s", result.Status, OCSPStatusGood)
+	}
+	if len(result.Raw) == 0 || result.NextUpdate.IsZero() {
+		t.Fatalf("OCSP response not stored: %+v", result)
+	}
+	// 保存的响应可以再次解析，用作stapling文件
+	if _, err = ocsp.ParseResponse(result.Raw, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	responder.revoked = true
+	result, err = FetchOCSP(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != OCSPStatusRevoked {
+		t.Fatalf("status = %s, want %s", result.Status, OCSPStatusRevoked)
+	}
+	if result.RevocationReason != ocsp.KeyCompromise {
+		t.Fatalf("revocation reason = %d, want %d", result.RevocationReason, ocsp.KeyCompromise)
+	}
+}
+
+func TestFetchOCSPUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+	certificate, _, _ := newTestCertificate(t, url)
+
+	if _, err := FetchOCSP(certificate); err == nil {
+		t.Fatal("expected error when OCSP responder is unreachable")
+	}
+}
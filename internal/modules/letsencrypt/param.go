@@ -12,20 +12,68 @@ type Param struct {
 	AliyunSLBId    int `json:"aliyun_slb_id"`
 	CertificateId  int `json:"certificate_id"`
 	DoaminConfigId int `json:"doamin_config_id"`
+
+	// 注销原因：unspecified、keyCompromise、superseded、cessationOfOperation
+	Reason string `json:"reason,omitempty"`
+	// 使用证书私钥注销，账号丢失时使用
+	UseCertificateKey bool `json:"use_certificate_key,omitempty"`
+	// 没有账号时注销证书使用的CA，为空时使用默认CA
+	CaDirUrl string `json:"ca_dir_url,omitempty"`
+	// 使用上传的CSR(PEM格式)申请证书，私钥由用户保管
+	Csr string `json:"csr,omitempty"`
+}
+
+// 转换为任务的command字段
+func (p *Param) Command() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
 }
 
 // 创建证书参数检查
 func (p *Param) ValidationObtain() (err error) {
+	if p.AcmeUserId <= 0 {
+		return fmt.Errorf("错误：acme_user_id无效！")
+	}
+	if p.DoaminConfigId <= 0 {
+		return fmt.Errorf("错误：doamin_config_id无效！")
+	}
+	if p.AliyunSLBId > 0 && p.AccessKeyId <= 0 {
+		return fmt.Errorf("错误：部署到SLB需要access_key_id！")
+	}
+	if p.Csr != "" {
+		if _, err = ParseCSR(p.Csr); err != nil {
+			return
+		}
+	}
 	return
 }
 
-// 创建证书参数检查
+// 续期证书参数检查
 func (p *Param) ValidationRenew() (err error) {
+	if err = p.ValidationObtain(); err != nil {
+		return
+	}
+	if p.CertificateId <= 0 {
+		return fmt.Errorf("错误：certificate_id无效！")
+	}
 	return
 }
 
-// 创建证书参数检查
+// 注销证书参数检查，账号丢失时可以不指定acme_user_id，使用证书私钥注销
 func (p *Param) ValidationRevoke() (err error) {
+	if p.AcmeUserId < 0 {
+		return fmt.Errorf("错误：acme_user_id无效！")
+	}
+	if p.CertificateId <= 0 {
+		return fmt.Errorf("错误：certificate_id无效！")
+	}
+	if _, err = ParseRevocationReason(p.Reason); err != nil {
+		return fmt.Errorf("错误：%s", err)
+	}
 	return
 }
 
@@ -0,0 +1,36 @@
+package letsencrypt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 注销原因，参考RFC 5280 CRLReason
+const (
+	RevocationReasonUnspecified          uint = 0
+	RevocationReasonKeyCompromise        uint = 1
+	RevocationReasonSuperseded           uint = 4
+	RevocationReasonCessationOfOperation uint = 5
+)
+
+// 注销原因名称
+var RevocationReasons = map[string]uint{
+	"unspecified":          RevocationReasonUnspecified,
+	"keyCompromise":        RevocationReasonKeyCompromise,
+	"superseded":           RevocationReasonSuperseded,
+	"cessationOfOperation": RevocationReasonCessationOfOperation,
+}
+
+// 解析注销原因，为空时为unspecified
+func ParseRevocationReason(name string) (uint, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return RevocationReasonUnspecified, nil
+	}
+	reason, ok := RevocationReasons[name]
+	if !ok {
+		return 0, fmt.Errorf("不支持的注销原因：%s，可选：unspecified、keyCompromise、superseded、cessationOfOperation", name)
+	}
+
+	return reason, nil
+}
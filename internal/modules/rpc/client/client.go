@@ -23,6 +23,12 @@ var (
 	errUnavailable = errors.New("无法连接远程服务器")
 )
 
+// 部署、删除challenge验证内容超时时间
+const challengeTimeout = 30 * time.Second
+
+// 证书部署的检查、重载命令默认超时时间(秒)，与节点上的默认值一致
+const defaultCertificateCommandTimeout = 60
+
 func generateTaskUniqueKey(ip string, port int, id int64) string {
 	return fmt.Sprintf("%s:%d:%d", ip, port, id)
 }
@@ -81,3 +87,67 @@ func parseGRPCError(err error) (string, error) {
 	}
 	return "", err
 }
+
+// 在节点上部署challenge验证内容
+func PresentChallenge(ip string, port int, req *pb.ChallengeRequest) error {
+	return execChallenge(ip, port, req, false)
+}
+
+// 删除节点上的challenge验证内容
+func CleanUpChallenge(ip string, port int, req *pb.ChallengeRequest) error {
+	return execChallenge(ip, port, req, true)
+}
+
+func execChallenge(ip string, port int, req *pb.ChallengeRequest, cleanUp bool) error {
+	addr := fmt.Sprintf("%s:%d", ip, port)
+	c, err := grpcpool.Pool.Get(addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), challengeTimeout)
+	defer cancel()
+
+	var resp *pb.ChallengeResponse
+	if cleanUp {
+		resp, err = c.CleanUpChallenge(ctx, req)
+	} else {
+		resp, err = c.PresentChallenge(ctx, req)
+	}
+	if err != nil {
+		_, err = parseGRPCError(err)
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+
+	return nil
+}
+
+// 在节点上部署证书文件，返回命令输出和节点是否已恢复原文件
+func DeployCertificate(ip string, port int, req *pb.CertificateRequest) (output string, rolledBack bool, err error) {
+	addr := fmt.Sprintf("%s:%d", ip, port)
+	c, err := grpcpool.Pool.Get(addr)
+	if err != nil {
+		return "", false, err
+	}
+	// 检查命令、重载命令各自有超时时间，另外预留写文件的时间
+	commandTimeout := req.Timeout
+	if commandTimeout <= 0 || commandTimeout > 86400 {
+		commandTimeout = defaultCertificateCommandTimeout
+	}
+	timeout := time.Duration(commandTimeout)*2*time.Second + challengeTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := c.DeployCertificate(ctx, req)
+	if err != nil {
+		_, err = parseGRPCError(err)
+		return "", false, err
+	}
+	if resp.Error != "" {
+		return resp.Output, resp.RolledBack, errors.New(resp.Error)
+	}
+
+	return resp.Output, false, nil
+}
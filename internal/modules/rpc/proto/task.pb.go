@@ -10,6 +10,11 @@ It is generated from these files:
 It has these top-level messages:
 	TaskRequest
 	TaskResponse
+	ChallengeRequest
+	ChallengeResponse
+	CertificateFile
+	CertificateRequest
+	CertificateResponse
 */
 package rpc
 
@@ -89,9 +94,198 @@ func (m *TaskResponse) GetError() string {
 	return ""
 }
 
+type ChallengeRequest struct {
+	Type    string `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
+	Domain  string `protobuf:"bytes,2,opt,name=domain" json:"domain,omitempty"`
+	Token   string `protobuf:"bytes,3,opt,name=token" json:"token,omitempty"`
+	KeyAuth string `protobuf:"bytes,4,opt,name=key_auth,json=keyAuth" json:"key_auth,omitempty"`
+	Webroot string `protobuf:"bytes,5,opt,name=webroot" json:"webroot,omitempty"`
+}
+
+func (m *ChallengeRequest) Reset()                    { *m = ChallengeRequest{} }
+func (m *ChallengeRequest) String() string            { return proto.CompactTextString(m) }
+func (*ChallengeRequest) ProtoMessage()               {}
+func (*ChallengeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *ChallengeRequest) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *ChallengeRequest) GetDomain() string {
+	if m != nil {
+		return m.Domain
+	}
+	return ""
+}
+
+func (m *ChallengeRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *ChallengeRequest) GetKeyAuth() string {
+	if m != nil {
+		return m.KeyAuth
+	}
+	return ""
+}
+
+func (m *ChallengeRequest) GetWebroot() string {
+	if m != nil {
+		return m.Webroot
+	}
+	return ""
+}
+
+type ChallengeResponse struct {
+	Error string `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *ChallengeResponse) Reset()                    { *m = ChallengeResponse{} }
+func (m *ChallengeResponse) String() string            { return proto.CompactTextString(m) }
+func (*ChallengeResponse) ProtoMessage()               {}
+func (*ChallengeResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *ChallengeResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type CertificateFile struct {
+	Path    string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Content []byte `protobuf:"bytes,2,opt,name=content" json:"content,omitempty"`
+	Mode    uint32 `protobuf:"varint,3,opt,name=mode" json:"mode,omitempty"`
+	Owner   string `protobuf:"bytes,4,opt,name=owner" json:"owner,omitempty"`
+}
+
+func (m *CertificateFile) Reset()                    { *m = CertificateFile{} }
+func (m *CertificateFile) String() string            { return proto.CompactTextString(m) }
+func (*CertificateFile) ProtoMessage()               {}
+func (*CertificateFile) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *CertificateFile) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *CertificateFile) GetContent() []byte {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
+func (m *CertificateFile) GetMode() uint32 {
+	if m != nil {
+		return m.Mode
+	}
+	return 0
+}
+
+func (m *CertificateFile) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type CertificateRequest struct {
+	Files           []*CertificateFile `protobuf:"bytes,1,rep,name=files" json:"files,omitempty"`
+	ValidateCommand string             `protobuf:"bytes,2,opt,name=validate_command,json=validateCommand" json:"validate_command,omitempty"`
+	ReloadCommand   string             `protobuf:"bytes,3,opt,name=reload_command,json=reloadCommand" json:"reload_command,omitempty"`
+	Timeout         int32              `protobuf:"varint,4,opt,name=timeout" json:"timeout,omitempty"`
+	Id              int64              `protobuf:"varint,5,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *CertificateRequest) Reset()                    { *m = CertificateRequest{} }
+func (m *CertificateRequest) String() string            { return proto.CompactTextString(m) }
+func (*CertificateRequest) ProtoMessage()               {}
+func (*CertificateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *CertificateRequest) GetFiles() []*CertificateFile {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+func (m *CertificateRequest) GetValidateCommand() string {
+	if m != nil {
+		return m.ValidateCommand
+	}
+	return ""
+}
+
+func (m *CertificateRequest) GetReloadCommand() string {
+	if m != nil {
+		return m.ReloadCommand
+	}
+	return ""
+}
+
+func (m *CertificateRequest) GetTimeout() int32 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *CertificateRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type CertificateResponse struct {
+	Output     string `protobuf:"bytes,1,opt,name=output" json:"output,omitempty"`
+	Error      string `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+	RolledBack bool   `protobuf:"varint,3,opt,name=rolled_back,json=rolledBack" json:"rolled_back,omitempty"`
+}
+
+func (m *CertificateResponse) Reset()                    { *m = CertificateResponse{} }
+func (m *CertificateResponse) String() string            { return proto.CompactTextString(m) }
+func (*CertificateResponse) ProtoMessage()               {}
+func (*CertificateResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *CertificateResponse) GetOutput() string {
+	if m != nil {
+		return m.Output
+	}
+	return ""
+}
+
+func (m *CertificateResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *CertificateResponse) GetRolledBack() bool {
+	if m != nil {
+		return m.RolledBack
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*TaskRequest)(nil), "rpc.TaskRequest")
 	proto.RegisterType((*TaskResponse)(nil), "rpc.TaskResponse")
+	proto.RegisterType((*ChallengeRequest)(nil), "rpc.ChallengeRequest")
+	proto.RegisterType((*ChallengeResponse)(nil), "rpc.ChallengeResponse")
+	proto.RegisterType((*CertificateFile)(nil), "rpc.CertificateFile")
+	proto.RegisterType((*CertificateRequest)(nil), "rpc.CertificateRequest")
+	proto.RegisterType((*CertificateResponse)(nil), "rpc.CertificateResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -106,6 +300,9 @@ const _ = grpc.SupportPackageIsVersion4
 
 type TaskClient interface {
 	Run(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskResponse, error)
+	PresentChallenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*ChallengeResponse, error)
+	CleanUpChallenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*ChallengeResponse, error)
+	DeployCertificate(ctx context.Context, in *CertificateRequest, opts ...grpc.CallOption) (*CertificateResponse, error)
 }
 
 type taskClient struct {
@@ -125,10 +322,40 @@ func (c *taskClient) Run(ctx context.Context, in *TaskRequest, opts ...grpc.Call
 	return out, nil
 }
 
+func (c *taskClient) PresentChallenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*ChallengeResponse, error) {
+	out := new(ChallengeResponse)
+	err := grpc.Invoke(ctx, "/rpc.Task/PresentChallenge", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskClient) CleanUpChallenge(ctx context.Context, in *ChallengeRequest, opts ...grpc.CallOption) (*ChallengeResponse, error) {
+	out := new(ChallengeResponse)
+	err := grpc.Invoke(ctx, "/rpc.Task/CleanUpChallenge", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskClient) DeployCertificate(ctx context.Context, in *CertificateRequest, opts ...grpc.CallOption) (*CertificateResponse, error) {
+	out := new(CertificateResponse)
+	err := grpc.Invoke(ctx, "/rpc.Task/DeployCertificate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Task service
 
 type TaskServer interface {
 	Run(context.Context, *TaskRequest) (*TaskResponse, error)
+	PresentChallenge(context.Context, *ChallengeRequest) (*ChallengeResponse, error)
+	CleanUpChallenge(context.Context, *ChallengeRequest) (*ChallengeResponse, error)
+	DeployCertificate(context.Context, *CertificateRequest) (*CertificateResponse, error)
 }
 
 func RegisterTaskServer(s *grpc.Server, srv TaskServer) {
@@ -153,6 +380,60 @@ func _Task_Run_Handler(srv interface{}, ctx context.Context, dec func(interface{
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Task_PresentChallenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServer).PresentChallenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Task/PresentChallenge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServer).PresentChallenge(ctx, req.(*ChallengeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Task_CleanUpChallenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChallengeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServer).CleanUpChallenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Task/CleanUpChallenge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServer).CleanUpChallenge(ctx, req.(*ChallengeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Task_DeployCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServer).DeployCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Task/DeployCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServer).DeployCertificate(ctx, req.(*CertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Task_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rpc.Task",
 	HandlerType: (*TaskServer)(nil),
@@ -161,6 +442,18 @@ var _Task_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Run",
 			Handler:    _Task_Run_Handler,
 		},
+		{
+			MethodName: "PresentChallenge",
+			Handler:    _Task_PresentChallenge_Handler,
+		},
+		{
+			MethodName: "CleanUpChallenge",
+			Handler:    _Task_CleanUpChallenge_Handler,
+		},
+		{
+			MethodName: "DeployCertificate",
+			Handler:    _Task_DeployCertificate_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "task.proto",
@@ -169,17 +462,35 @@ var _Task_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("task.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 184 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x8f, 0xcf, 0x8a, 0x83, 0x30,
-	0x10, 0xc6, 0x37, 0x46, 0x5d, 0x76, 0x76, 0x59, 0xda, 0xa1, 0x94, 0xd0, 0x93, 0x78, 0xf2, 0x50,
-	0x3c, 0xb4, 0x3d, 0xf6, 0x25, 0x1a, 0xfa, 0x02, 0x56, 0x73, 0x10, 0xd1, 0x49, 0x93, 0xc9, 0xfb,
-	0x17, 0xff, 0x81, 0xc7, 0xdf, 0x0c, 0xf3, 0xfd, 0xe6, 0x03, 0xe0, 0xca, 0x77, 0xa5, 0x75, 0xc4,
-	0x84, 0xd2, 0xd9, 0x3a, 0x7f, 0xc0, 0xef, 0xb3, 0xf2, 0x9d, 0x36, 0xef, 0x60, 0x3c, 0xa3, 0x82,
-	0xef, 0x9a, 0xfa, 0xbe, 0x1a, 0x1a, 0x15, 0x65, 0xa2, 0xf8, 0xd1, 0x2b, 0x8e, 0x1b, 0x6e, 0x7b,
-	0x43, 0x81, 0x95, 0xcc, 0x44, 0x91, 0xe8, 0x15, 0xf1, 0x1f, 0xa2, 0xb6, 0x51, 0x71, 0x26, 0x0a,
-	0xa9, 0xa3, 0xb6, 0xc9, 0xef, 0xf0, 0x37, 0x47, 0x7a, 0x4b, 0x83, 0x37, 0x78, 0x84, 0x94, 0x02,
-	0xdb, 0xc0, 0x4a, 0x4c, 0x91, 0x0b, 0xe1, 0x01, 0x12, 0xe3, 0x1c, 0xb9, 0xc5, 0x34, 0xc3, 0xe5,
-	0x06, 0xf1, 0x78, 0x8d, 0x67, 0x90, 0x3a, 0x0c, 0xb8, 0x2b, 0x9d, 0xad, 0xcb, 0xcd, 0x8b, 0xa7,
-	0xfd, 0x66, 0x32, 0x1b, 0xf2, 0xaf, 0x57, 0x3a, 0x55, 0xba, 0x7e, 0x02, 0x00, 0x00, 0xff, 0xff,
-	0xd7, 0x7f, 0x8a, 0x9d, 0xe0, 0x00, 0x00, 0x00,
+	// 479 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x93, 0x4d, 0x8f, 0xd3, 0x30,
+	0x10, 0x86, 0x49, 0x3f, 0xf6, 0x63, 0xba, 0x1f, 0xad, 0x59, 0x96, 0xb0, 0x17, 0xaa, 0x48, 0x48,
+	0x5d, 0x84, 0x7a, 0x58, 0xae, 0x5c, 0x20, 0x08, 0x71, 0x04, 0x0b, 0xce, 0x95, 0x9b, 0xcc, 0x52,
+	0x2b, 0x8e, 0x6d, 0x9c, 0x09, 0xab, 0xfe, 0x03, 0xf8, 0x4f, 0xfc, 0x38, 0x14, 0x3b, 0xa9, 0xb2,
+	0x5d, 0x4e, 0x70, 0xcb, 0x3b, 0x76, 0xdf, 0x79, 0x3c, 0xf3, 0x16, 0x80, 0x44, 0x55, 0x2c, 0xad,
+	0x33, 0x64, 0xd8, 0xd0, 0xd9, 0x2c, 0xf9, 0x0c, 0x93, 0x2f, 0xa2, 0x2a, 0x38, 0x7e, 0xaf, 0xb1,
+	0x22, 0x16, 0xc3, 0x61, 0x66, 0xca, 0x52, 0xe8, 0x3c, 0x1e, 0xcc, 0xa3, 0xc5, 0x31, 0xef, 0x64,
+	0x73, 0x42, 0xb2, 0x44, 0x53, 0x53, 0x3c, 0x9c, 0x47, 0x8b, 0x31, 0xef, 0x24, 0x3b, 0x83, 0x81,
+	0xcc, 0xe3, 0xd1, 0x3c, 0x5a, 0x0c, 0xf9, 0x40, 0xe6, 0xc9, 0x1b, 0x38, 0x09, 0x96, 0x95, 0x35,
+	0xba, 0x42, 0x76, 0x09, 0x07, 0xa6, 0x26, 0x5b, 0x53, 0x1c, 0x79, 0xcb, 0x56, 0xb1, 0x0b, 0x18,
+	0xa3, 0x73, 0xc6, 0xb5, 0x9d, 0x82, 0x48, 0x7e, 0x45, 0x30, 0x4d, 0x37, 0x42, 0x29, 0xd4, 0xdf,
+	0xb0, 0xc3, 0x62, 0x30, 0xa2, 0xad, 0xc5, 0xd6, 0xc0, 0x7f, 0x37, 0xb6, 0xb9, 0x29, 0x85, 0xd4,
+	0xed, 0xef, 0x5b, 0xd5, 0xd8, 0x92, 0x29, 0x50, 0x7b, 0xcc, 0x63, 0x1e, 0x04, 0x7b, 0x06, 0x47,
+	0x05, 0x6e, 0x57, 0xa2, 0xa6, 0x8d, 0x47, 0x3d, 0xe6, 0x87, 0x05, 0x6e, 0xdf, 0xd6, 0xb4, 0x69,
+	0x5e, 0x76, 0x87, 0x6b, 0x67, 0x0c, 0xc5, 0xe3, 0x70, 0xd2, 0xca, 0xe4, 0x1a, 0x66, 0x3d, 0x94,
+	0xf6, 0x39, 0x3b, 0xec, 0xa8, 0x8f, 0x2d, 0xe1, 0x3c, 0x45, 0x47, 0xf2, 0x56, 0x66, 0x82, 0xf0,
+	0x83, 0x54, 0xd8, 0x40, 0x5b, 0x41, 0x9b, 0x0e, 0xda, 0x8a, 0xd0, 0x2b, 0x33, 0x9a, 0x50, 0x93,
+	0xa7, 0x3e, 0xe1, 0x9d, 0x6c, 0x6e, 0x97, 0x26, 0x47, 0x4f, 0x7d, 0xca, 0xfd, 0x77, 0xd3, 0xca,
+	0xdc, 0x69, 0x74, 0x2d, 0x71, 0x10, 0xc9, 0xef, 0x08, 0x58, 0xaf, 0x57, 0x37, 0xa3, 0x97, 0x30,
+	0xbe, 0x95, 0x0a, 0xab, 0x38, 0x9a, 0x0f, 0x17, 0x93, 0x9b, 0x8b, 0xa5, 0xb3, 0xd9, 0x72, 0x8f,
+	0x89, 0x87, 0x2b, 0xec, 0x1a, 0xa6, 0x3f, 0x84, 0x92, 0xb9, 0x20, 0x5c, 0xdd, 0xdf, 0xf7, 0x79,
+	0x57, 0x4f, 0xdb, 0xbd, 0xbf, 0x80, 0x33, 0x87, 0xca, 0x88, 0x7c, 0x77, 0x31, 0xcc, 0xf5, 0x34,
+	0x54, 0xd3, 0x87, 0xf1, 0x18, 0xfd, 0x2d, 0x1e, 0xe3, 0x5d, 0x3c, 0x72, 0x78, 0x7c, 0x8f, 0xfe,
+	0x5f, 0x52, 0xc2, 0x9e, 0xc3, 0xc4, 0x19, 0xa5, 0x30, 0x5f, 0xad, 0x45, 0x56, 0x78, 0xa4, 0x23,
+	0x0e, 0xa1, 0xf4, 0x4e, 0x64, 0xc5, 0xcd, 0xcf, 0x01, 0x8c, 0x9a, 0x14, 0xb2, 0x57, 0x30, 0xe4,
+	0xb5, 0x66, 0x53, 0x3f, 0x8e, 0x5e, 0xd4, 0xaf, 0x66, 0xbd, 0x4a, 0x60, 0x48, 0x1e, 0xb1, 0x14,
+	0xa6, 0x9f, 0x1c, 0x56, 0xa8, 0x69, 0xb7, 0x78, 0xf6, 0x24, 0x4c, 0x72, 0x2f, 0x93, 0x57, 0x97,
+	0xfb, 0xe5, 0xbe, 0x49, 0xaa, 0x50, 0xe8, 0xaf, 0xf6, 0x3f, 0x4c, 0x3e, 0xc2, 0xec, 0x3d, 0x5a,
+	0x65, 0xb6, 0xbd, 0x61, 0xb1, 0xa7, 0xfb, 0x4b, 0xed, 0x7c, 0xe2, 0x87, 0x07, 0x9d, 0xd3, 0xfa,
+	0xc0, 0xff, 0xdd, 0x5f, 0xff, 0x19, 0x00, 0x3c, 0x23, 0xbe, 0x28, 0xfc, 0x03, 0x00, 0x00,
 }
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/ouqiang/gocron/internal/modules/rpc/proto"
+	"github.com/ouqiang/gocron/internal/modules/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// 新文件默认权限
+	defaultCertificateFileMode os.FileMode = 0600
+	// 检查、重载命令默认超时时间
+	defaultCertificateCommandTimeout = 60
+)
+
+// 同一节点上的证书部署串行执行, 避免同时修改同一个文件
+var certificateLock sync.Mutex
+
+// 部署前的文件, 失败时恢复
+type certificateFileBackup struct {
+	path    string
+	exists  bool
+	content []byte
+	mode    os.FileMode
+	uid     int
+	gid     int
+}
+
+// DeployCertificate 写入证书文件, 执行检查命令、重载命令, 任一步骤失败时恢复原文件
+func (s Server) DeployCertificate(ctx context.Context, req *pb.CertificateRequest) (*pb.CertificateResponse, error) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(err)
+		}
+	}()
+	certificateLock.Lock()
+	defer certificateLock.Unlock()
+
+	log.Infof("deploy certificate start: [id: %d files: %d]", req.Id, len(req.Files))
+	resp := new(pb.CertificateResponse)
+	output, rolledBack, err := deployCertificate(ctx, req)
+	resp.Output = output
+	resp.RolledBack = rolledBack
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	log.Infof("deploy certificate end: [id: %d rolled_back: %t err: %s]", req.Id, resp.RolledBack, resp.Error)
+
+	return resp, nil
+}
+
+func deployCertificate(ctx context.Context, req *pb.CertificateRequest) (output string, rolledBack bool, err error) {
+	if err = checkCertificateFiles(req.Files); err != nil {
+		return "", false, err
+	}
+	backups := make([]*certificateFileBackup, 0, len(req.Files))
+	for _, file := range req.Files {
+		backup, err := backupCertificateFile(file.Path)
+		if err != nil {
+			return "", false, err
+		}
+		backups = append(backups, backup)
+	}
+
+	restore := func(cause error) (string, bool, error) {
+		if restoreErr := restoreCertificateFiles(backups); restoreErr != nil {
+			return output, false, fmt.Errorf("%s, restore files failed: %s", cause, restoreErr)
+		}
+		return output, true, cause
+	}
+	for i, file := range req.Files {
+		if err = writeCertificateFile(file, backups[i]); err != nil {
+			return restore(err)
+		}
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > 86400 {
+		timeout = defaultCertificateCommandTimeout
+	}
+	for _, command := range []string{req.ValidateCommand, req.ReloadCommand} {
+		command = strings.TrimSpace(command)
+		if command == "" {
+			continue
+		}
+		result, err := execCertificateCommand(ctx, command, timeout)
+		output += result
+		if err != nil {
+			return restore(fmt.Errorf("command [%s] failed: %s", command, err))
+		}
+	}
+
+	return output, false, nil
+}
+
+func execCertificateCommand(ctx context.Context, command string, timeout int32) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	log.Infof("deploy certificate exec: [cmd: %s]", command)
+
+	return utils.ExecShell(ctx, command)
+}
+
+// 文件路径必须为绝对路径且不能重复
+func checkCertificateFiles(files []*pb.CertificateFile) error {
+	if len(files) == 0 {
+		return errors.New("no certificate files")
+	}
+	paths := make(map[string]bool, len(files))
+	for _, file := range files {
+		if file.Path == "" || !filepath.IsAbs(file.Path) {
+			return fmt.Errorf("certificate file path must be absolute: %s", file.Path)
+		}
+		path := filepath.Clean(file.Path)
+		if paths[path] {
+			return fmt.Errorf("duplicate certificate file path: %s", path)
+		}
+		paths[path] = true
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return fmt.Errorf("certificate file path is a directory: %s", path)
+		}
+		if file.Mode > 0777 {
+			return fmt.Errorf("invalid file mode %o: %s", file.Mode, path)
+		}
+	}
+
+	return nil
+}
+
+func backupCertificateFile(path string) (*certificateFileBackup, error) {
+	backup := &certificateFileBackup{path: filepath.Clean(path), uid: -1, gid: -1}
+	info, err := os.Stat(backup.path)
+	if os.IsNotExist(err) {
+		return backup, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if backup.content, err = ioutil.ReadFile(backup.path); err != nil {
+		return nil, err
+	}
+	backup.exists = true
+	backup.mode = info.Mode().Perm()
+	backup.uid, backup.gid = fileOwner(info)
+
+	return backup, nil
+}
+
+// 写入证书文件, 未指定权限、所有者时保留原文件的权限、所有者
+func writeCertificateFile(file *pb.CertificateFile, backup *certificateFileBackup) error {
+	mode := os.FileMode(file.Mode)
+	if mode == 0 {
+		mode = defaultCertificateFileMode
+		if backup.exists {
+			mode = backup.mode
+		}
+	}
+	uid, gid := backup.uid, backup.gid
+	if file.Owner != "" {
+		var err error
+		if uid, gid, err = lookupOwner(file.Owner); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(backup.path), 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(backup.path, file.Content, mode, uid, gid)
+}
+
+// 恢复部署前的文件, 部署前不存在的文件删除
+func restoreCertificateFiles(backups []*certificateFileBackup) error {
+	var errs []string
+	for _, backup := range backups {
+		var err error
+		if backup.exists {
+			err = writeFileAtomic(backup.path, backup.content, backup.mode, backup.uid, backup.gid)
+		} else if err = os.Remove(backup.path); os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// 写入同目录下的临时文件后重命名, 读取方不会读到写了一半的文件
+// uid、gid为-1时不修改所有者
+func writeFileAtomic(path string, content []byte, mode os.FileMode, uid, gid int) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	// 与当前用户相同时不需要修改, 非root用户修改所有者会失败
+	if uid == os.Getuid() {
+		uid = -1
+	}
+	if gid == os.Getgid() {
+		gid = -1
+	}
+	if uid >= 0 || gid >= 0 {
+		if err = os.Chown(tmp.Name(), uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// 解析所有者, 格式: user或user:group, 支持用户名和数字ID
+func lookupOwner(owner string) (uid, gid int, err error) {
+	parts := strings.SplitN(owner, ":", 2)
+	uid, gid = -1, -1
+	if parts[0] != "" {
+		u, err := user.Lookup(parts[0])
+		if err != nil {
+			if u, err = user.LookupId(parts[0]); err != nil {
+				return -1, -1, fmt.Errorf("unknown user: %s", parts[0])
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return -1, -1, fmt.Errorf("unsupported user id: %s", u.Uid)
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			if g, err = user.LookupGroupId(parts[1]); err != nil {
+				return -1, -1, fmt.Errorf("unknown group: %s", parts[1])
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return -1, -1, fmt.Errorf("unsupported group id: %s", g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,17 @@
+// +build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// 文件的所有者
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+
+	return -1, -1
+}
@@ -0,0 +1,10 @@
+// +build windows
+
+package server
+
+import "os"
+
+// windows不支持修改所有者
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	return -1, -1
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v3/challenge/tlsalpn01"
+	pb "github.com/ouqiang/gocron/internal/modules/rpc/proto"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+
+	httpChallengePath = "/.well-known/acme-challenge/"
+)
+
+var (
+	// http-01验证内容, key: token, value: key authorization
+	httpChallenges sync.Map
+
+	// 是否开启内置的http-01验证服务
+	httpChallengeEnabled bool
+
+	// tls-alpn-01验证证书, key: 域名, value: *tls.Certificate
+	tlsALPNChallenges sync.Map
+
+	// 是否开启内置的tls-alpn-01验证服务
+	tlsALPNChallengeEnabled bool
+)
+
+func (s Server) PresentChallenge(ctx context.Context, req *pb.ChallengeRequest) (*pb.ChallengeResponse, error) {
+	log.Infof("present challenge: [type: %s domain: %s token: %s]", req.Type, req.Domain, req.Token)
+	err := presentChallenge(req)
+	resp := new(pb.ChallengeResponse)
+	if err != nil {
+		log.Errorf("present challenge: [domain: %s err: %s]", req.Domain, err)
+		resp.Error = err.Error()
+	}
+
+	return resp, nil
+}
+
+func (s Server) CleanUpChallenge(ctx context.Context, req *pb.ChallengeRequest) (*pb.ChallengeResponse, error) {
+	log.Infof("clean up challenge: [type: %s domain: %s token: %s]", req.Type, req.Domain, req.Token)
+	err := cleanUpChallenge(req)
+	resp := new(pb.ChallengeResponse)
+	if err != nil {
+		log.Errorf("clean up challenge: [domain: %s err: %s]", req.Domain, err)
+		resp.Error = err.Error()
+	}
+
+	return resp, nil
+}
+
+func presentChallenge(req *pb.ChallengeRequest) error {
+	switch req.Type {
+	case ChallengeHTTP01:
+		return presentHTTPChallenge(req)
+	case ChallengeTLSALPN01:
+		return presentTLSALPNChallenge(req)
+	}
+
+	return fmt.Errorf("unsupported challenge type: %s", req.Type)
+}
+
+func cleanUpChallenge(req *pb.ChallengeRequest) error {
+	switch req.Type {
+	case ChallengeHTTP01:
+		return cleanUpHTTPChallenge(req)
+	case ChallengeTLSALPN01:
+		tlsALPNChallenges.Delete(strings.ToLower(req.Domain))
+		return nil
+	}
+
+	return fmt.Errorf("unsupported challenge type: %s", req.Type)
+}
+
+func presentHTTPChallenge(req *pb.ChallengeRequest) error {
+	if err := checkToken(req.Token); err != nil {
+		return err
+	}
+	if req.Webroot != "" {
+		dir := filepath.Join(req.Webroot, httpChallengePath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, req.Token), []byte(req.KeyAuth), 0644)
+	}
+	if !httpChallengeEnabled {
+		return errors.New("http challenge server is not enabled, start gocron-node with -http-challenge-addr or set a webroot")
+	}
+	httpChallenges.Store(req.Token, req.KeyAuth)
+
+	return nil
+}
+
+func cleanUpHTTPChallenge(req *pb.ChallengeRequest) error {
+	if err := checkToken(req.Token); err != nil {
+		return err
+	}
+	if req.Webroot != "" {
+		err := os.Remove(filepath.Join(req.Webroot, httpChallengePath, req.Token))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	httpChallenges.Delete(req.Token)
+
+	return nil
+}
+
+// token为base64url编码, 不能包含路径分隔符
+func checkToken(token string) error {
+	if token == "" || strings.ContainsAny(token, `/\.`) {
+		return fmt.Errorf("invalid challenge token: %s", token)
+	}
+
+	return nil
+}
+
+func presentTLSALPNChallenge(req *pb.ChallengeRequest) error {
+	if !tlsALPNChallengeEnabled {
+		return errors.New("tls-alpn challenge server is not enabled, start gocron-node with -tls-alpn-challenge-addr")
+	}
+	cert, err := tlsalpn01.ChallengeCert(req.Domain, req.KeyAuth)
+	if err != nil {
+		return err
+	}
+	tlsALPNChallenges.Store(strings.ToLower(req.Domain), cert)
+
+	return nil
+}
+
+// StartHTTPChallenge 启动内置的http-01验证服务, 80端口需要转发到此地址
+func StartHTTPChallenge(addr string) {
+	httpChallengeEnabled = true
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpChallengePath, func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, httpChallengePath)
+		keyAuth, ok := httpChallenges.Load(token)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth.(string)))
+		log.Infof("served key authentication: [host: %s token: %s]", r.Host, token)
+	})
+	log.Infof("http challenge server listen on %s", addr)
+
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// StartTLSALPNChallenge 启动内置的tls-alpn-01验证服务, 443端口需要转发到此地址
+func StartTLSALPNChallenge(addr string) {
+	tlsALPNChallengeEnabled = true
+	tlsConfig := &tls.Config{
+		NextProtos: []string{tlsalpn01.ACMETLS1Protocol},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, ok := tlsALPNChallenges.Load(strings.ToLower(hello.ServerName))
+			if !ok {
+				return nil, fmt.Errorf("no tls-alpn challenge for %s", hello.ServerName)
+			}
+			return cert.(*tls.Certificate), nil
+		},
+	}
+	l, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("tls-alpn challenge server listen on %s", addr)
+
+	go serveTLSALPNChallenge(l)
+}
+
+// tls-alpn-01验证的握手超时时间
+const tlsALPNHandshakeTimeout = 10 * time.Second
+
+// 与net/http.Server.Serve一致, Accept临时错误(如: 文件描述符不足)时等待后重试, 其他错误时退出
+func serveTLSALPNChallenge(l net.Listener) {
+	defer l.Close()
+	var tempDelay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				log.Errorf("tls-alpn challenge accept error: %s; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			log.Errorf("tls-alpn challenge server stopped: %s", err)
+			return
+		}
+		tempDelay = 0
+		// 握手完成即验证完成, 不需要处理请求
+		go func(c net.Conn) {
+			defer c.Close()
+			// 端口对外开放, 设置超时避免空闲连接一直占用
+			if err := c.SetDeadline(time.Now().Add(tlsALPNHandshakeTimeout)); err != nil {
+				log.Warnf("tls-alpn challenge set deadline: %s", err)
+				return
+			}
+			if err := c.(*tls.Conn).Handshake(); err != nil {
+				log.Warnf("tls-alpn challenge handshake: %s", err)
+			}
+		}(conn)
+	}
+}
@@ -36,6 +36,22 @@ type Setting struct {
 
 	ConcurrencyQueue int
 	AuthSecret       string
+
+	// 证书到期检查的crontab表达式，为空时不检查
+	CertScanSpec string
+	// 证书OCSP查询的crontab表达式，为空时不查询
+	CertOcspSpec string
+	// 证书告警通知类型 0: 不通知 1: 邮件 2: slack 3: webhook
+	CertAlertNotifyType int8
+	// 证书告警通知接收者ID，与任务的通知接收者相同
+	CertAlertReceiverId string
+
+	// 加密私钥等敏感数据的主密钥，格式：<ID>:<base64编码的32字节密钥>，多个以逗号隔开
+	SecretKeys string
+	// 主密钥文件，每行一个密钥，未配置secret.keys时使用，都为空时使用conf/secret.key
+	SecretKeyFile string
+	// 加密使用的主密钥ID，为空时使用最后一个
+	SecretKeyId string
 }
 
 // 读取配置
@@ -70,6 +86,15 @@ func Read(filename string) (*Setting, error) {
 		s.AuthSecret = utils.RandAuthToken()
 	}
 
+	s.CertScanSpec = section.Key("cert.scan.spec").MustString("0 0 3 * * *")
+	s.CertOcspSpec = section.Key("cert.ocsp.spec").MustString("0 30 */6 * * *")
+	s.CertAlertNotifyType = int8(section.Key("cert.alert.notify_type").MustInt(0))
+	s.CertAlertReceiverId = section.Key("cert.alert.receiver_id").MustString("")
+
+	s.SecretKeys = section.Key("secret.keys").MustString("")
+	s.SecretKeyFile = section.Key("secret.key_file").MustString("")
+	s.SecretKeyId = section.Key("secret.key_id").MustString("")
+
 	s.EnableTLS = section.Key("enable_tls").MustBool(false)
 	s.CAFile = section.Key("ca_file").MustString("")
 	s.CertFile = section.Key("cert_file").MustString("")
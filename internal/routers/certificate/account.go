@@ -0,0 +1,104 @@
+package certificate
+
+import (
+	"fmt"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/letsencrypt"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+	"github.com/ouqiang/gocron/internal/modules/utils"
+	macaron "gopkg.in/macaron.v1"
+)
+
+// AccountStatus 查询ACME账号在CA的状态
+func AccountStatus(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	au, err := findAcmeUser(ctx)
+	if err != nil {
+		return json.CommonFailure("账号不存在", err)
+	}
+	status, err := letsencrypt.QueryAccount(au)
+	if err != nil {
+		return json.CommonFailure("查询账号状态失败-"+err.Error(), err)
+	}
+
+	return json.Success(utils.SuccessContent, status)
+}
+
+// AccountContact 更新ACME账号的联系邮箱
+func AccountContact(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	au, err := findAcmeUser(ctx)
+	if err != nil {
+		return json.CommonFailure("账号不存在", err)
+	}
+	email := ctx.QueryTrim("email")
+	exists, err := au.EmailExists(email, au.CaDirUrl, au.Id)
+	if err != nil {
+		return json.CommonFailure(utils.FailureContent, err)
+	}
+	if exists {
+		return json.CommonFailure("邮箱已存在")
+	}
+	if err = letsencrypt.UpdateAccountContact(au, email); err != nil {
+		return json.CommonFailure("更新联系邮箱失败-"+err.Error(), err)
+	}
+
+	return json.Success("更新成功", nil)
+}
+
+// AccountKeyRollover 轮换ACME账号密钥，key_type为空时使用EC256
+func AccountKeyRollover(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	au, err := findAcmeUser(ctx)
+	if err != nil {
+		return json.CommonFailure("账号不存在", err)
+	}
+	if err = letsencrypt.RolloverAccountKey(au, ctx.QueryTrim("key_type")); err != nil {
+		return json.CommonFailure("轮换账号密钥失败-"+err.Error(), err)
+	}
+
+	return json.Success("轮换成功", nil)
+}
+
+// AccountDeactivate 停用ACME账号，停用后不能恢复
+func AccountDeactivate(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	au, err := findAcmeUser(ctx)
+	if err != nil {
+		return json.CommonFailure("账号不存在", err)
+	}
+	if err = letsencrypt.DeactivateAccount(au); err != nil {
+		return json.CommonFailure("停用账号失败-"+err.Error(), err)
+	}
+	logger.Infof("ACME账号已停用#账号id-%d#%s", au.Id, au.Email)
+
+	return json.Success("停用成功", nil)
+}
+
+// AccountResolve 通过账号私钥从CA恢复账号信息，用于账号信息丢失的账号
+func AccountResolve(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	au, err := findAcmeUser(ctx)
+	if err != nil {
+		return json.CommonFailure("账号不存在", err)
+	}
+	if err = letsencrypt.ResolveAccount(au); err != nil {
+		return json.CommonFailure("恢复账号失败-"+err.Error(), err)
+	}
+
+	return json.Success("恢复成功", nil)
+}
+
+func findAcmeUser(ctx *macaron.Context) (*models.AcmeUser, error) {
+	au := new(models.AcmeUser)
+	id := ctx.ParamsInt(":id")
+	if err := au.Find(id); err != nil {
+		return nil, err
+	}
+	if au.Id == 0 {
+		return nil, fmt.Errorf("账号不存在#ID-%d", id)
+	}
+
+	return au, nil
+}
@@ -0,0 +1,170 @@
+package certificate
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/letsencrypt"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+	"github.com/ouqiang/gocron/internal/modules/utils"
+	"github.com/ouqiang/gocron/internal/routers/user"
+	"github.com/ouqiang/gocron/internal/service"
+	macaron "gopkg.in/macaron.v1"
+)
+
+// Ocsp 下载证书的OCSP响应(DER格式)，用作nginx的ssl_stapling_file
+func Ocsp(ctx *macaron.Context) {
+	json := utils.JsonResponse{}
+	id := ctx.ParamsInt(":id")
+	certificateModel := new(models.Certificate)
+	err := certificateModel.Find(id)
+	if err != nil || certificateModel.Id == 0 {
+		logger.Errorf("获取证书详情失败#证书id-%d#%v", id, err)
+		_, _ = ctx.Write([]byte(json.CommonFailure("证书不存在")))
+		return
+	}
+	if !certificateModel.HasValidOcspResponse() {
+		_, _ = ctx.Write([]byte(json.CommonFailure("证书没有有效的OCSP响应")))
+		return
+	}
+
+	ctx.Resp.Header().Set("Content-Type", "application/ocsp-response")
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=certificate-%d.ocsp", id))
+	ctx.Resp.WriteHeader(http.StatusOK)
+	_, _ = ctx.Resp.Write(certificateModel.OcspResponse)
+}
+
+// Versions 证书的全部版本
+func Versions(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	id := ctx.ParamsInt(":id")
+	versionModel := new(models.CertificateVersion)
+	versions, err := versionModel.ListByCertificate(id)
+	if err != nil {
+		logger.Errorf("获取证书版本失败#证书id-%d#%v", id, err)
+		return json.CommonFailure("获取证书版本失败", err)
+	}
+
+	return json.Success(utils.SuccessContent, versions)
+}
+
+// VersionDiff 比较两个版本的元数据
+func VersionDiff(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	from := new(models.CertificateVersion)
+	to := new(models.CertificateVersion)
+	fromId := ctx.QueryInt("from")
+	toId := ctx.QueryInt("to")
+	if err := from.Find(fromId); err != nil || from.Id == 0 {
+		return json.CommonFailure("证书版本不存在", err)
+	}
+	if err := to.Find(toId); err != nil || to.Id == 0 {
+		return json.CommonFailure("证书版本不存在", err)
+	}
+	if from.CertificateId != to.CertificateId {
+		return json.CommonFailure("只能比较同一个证书的版本")
+	}
+
+	return json.Success(utils.SuccessContent, from.Diff(to))
+}
+
+// Rollback 证书回滚到指定版本，指定aliyun_slb_id、access_key_id时重新部署到SLB
+func Rollback(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	id := ctx.ParamsInt(":id")
+	versionId := ctx.QueryInt("version_id")
+	aliyunSLBId := ctx.QueryInt("aliyun_slb_id")
+	accessKeyId := ctx.QueryInt("access_key_id")
+	if versionId <= 0 {
+		return json.CommonFailure("请选择回滚的版本")
+	}
+	if aliyunSLBId > 0 && accessKeyId <= 0 {
+		return json.CommonFailure("部署到SLB需要选择AccessKey")
+	}
+
+	result, err := service.RollbackCertificate(id, versionId, aliyunSLBId, accessKeyId)
+	if err != nil {
+		logger.Errorf("证书回滚失败#证书id-%d#版本id-%d#%v", id, versionId, err)
+		return json.CommonFailure(result+err.Error(), err)
+	}
+
+	return json.Success(result, nil)
+}
+
+// Export 导出证书，format：cert、key、chain、fullchain、haproxy、pkcs12、jks、zip，包含私钥，只有管理员可以导出
+func Export(ctx *macaron.Context) {
+	json := utils.JsonResponse{}
+	if !user.IsAdmin(ctx) {
+		_, _ = ctx.Write([]byte(json.Failure(utils.UnauthorizedError, "您无权限访问")))
+		return
+	}
+	id := ctx.ParamsInt(":id")
+	format := ctx.QueryTrim("format")
+	certificateModel := new(models.Certificate)
+	err := certificateModel.Find(id)
+	if err != nil || certificateModel.Id == 0 {
+		logger.Errorf("获取证书详情失败#证书id-%d#%v", id, err)
+		_, _ = ctx.Write([]byte(json.CommonFailure("证书不存在")))
+		return
+	}
+	file, err := letsencrypt.ExportCertificate(*certificateModel, format, ctx.Query("password"))
+	if err != nil {
+		_, _ = ctx.Write([]byte(json.CommonFailure("导出失败-"+err.Error(), err)))
+		return
+	}
+	logger.Infof("导出证书#证书id-%d#格式-%s#用户-%s", id, format, user.Username(ctx))
+
+	ctx.Resp.Header().Set("Content-Type", file.ContentType)
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.Name))
+	ctx.Resp.WriteHeader(http.StatusOK)
+	_, _ = ctx.Resp.Write(file.Data)
+}
+
+// Import 导入其他CA签发的证书，id大于0时更新已导入的证书
+func Import(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	certPEM := ctx.Query("certificate")
+	keyPEM := ctx.Query("private_key")
+	if strings.TrimSpace(certPEM) == "" || strings.TrimSpace(keyPEM) == "" {
+		return json.CommonFailure("请输入证书和私钥")
+	}
+	aliyunSLBId := ctx.QueryInt("aliyun_slb_id")
+	accessKeyId := ctx.QueryInt("access_key_id")
+	if aliyunSLBId > 0 && accessKeyId <= 0 {
+		return json.CommonFailure("部署到SLB需要选择AccessKey")
+	}
+
+	result, err := service.ImportCertificate(ctx.QueryInt("id"), ctx.QueryInt("domain_config_id"),
+		certPEM, keyPEM, ctx.Query("chain"), aliyunSLBId, accessKeyId)
+	if err != nil {
+		return json.CommonFailure(result+err.Error(), err)
+	}
+
+	return json.Success(result, nil)
+}
+
+// ObtainForCSR 使用上传的CSR申请证书，私钥由用户保管，在后台运行，结果在任务日志中查看
+func ObtainForCSR(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	csrPEM := ctx.Query("csr")
+	if strings.TrimSpace(csrPEM) == "" {
+		return json.CommonFailure("请输入CSR")
+	}
+	p := &letsencrypt.Param{
+		AcmeUserId:     ctx.QueryInt("acme_user_id"),
+		AccessKeyId:    ctx.QueryInt("access_key_id"),
+		AliyunSLBId:    ctx.QueryInt("aliyun_slb_id"),
+		CertificateId:  ctx.QueryInt("id"),
+		DoaminConfigId: ctx.QueryInt("domain_config_id"),
+		Csr:            csrPEM,
+	}
+
+	taskId, err := service.ObtainCertificateForCSR(p)
+	if err != nil {
+		return json.CommonFailure(err.Error(), err)
+	}
+
+	return json.Success(fmt.Sprintf("证书申请已开始运行#任务ID-%d, 请到任务日志中查看结果", taskId), map[string]int{"task_id": taskId})
+}
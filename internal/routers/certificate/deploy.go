@@ -0,0 +1,207 @@
+package certificate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-macaron/binding"
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/deploy"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+	"github.com/ouqiang/gocron/internal/modules/utils"
+	"github.com/ouqiang/gocron/internal/routers/base"
+	"github.com/ouqiang/gocron/internal/service"
+	macaron "gopkg.in/macaron.v1"
+)
+
+// DeployTypes 支持的部署类型
+func DeployTypes(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+
+	return json.Success(utils.SuccessContent, deploy.Types())
+}
+
+// DeployTargetIndex 部署目标列表
+func DeployTargetIndex(ctx *macaron.Context) string {
+	targetModel := new(models.DeployTarget)
+	params := models.CommonMap{}
+	params["Id"] = ctx.QueryInt("id")
+	params["Name"] = ctx.QueryTrim("name")
+	params["Type"] = ctx.QueryTrim("type")
+	base.ParsePageAndPageSize(ctx, params)
+	total, err := targetModel.Total(params)
+	if err != nil {
+		logger.Error(err)
+	}
+	targets, err := targetModel.List(params)
+	if err != nil {
+		logger.Error(err)
+	}
+
+	json := utils.JsonResponse{}
+
+	return json.Success(utils.SuccessContent, map[string]interface{}{
+		"total": total,
+		"data":  targets,
+	})
+}
+
+// DeployTargetDetail 部署目标详情
+func DeployTargetDetail(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	targetModel := new(models.DeployTarget)
+	id := ctx.ParamsInt(":id")
+	err := targetModel.Find(id)
+	if err != nil || targetModel.Id == 0 {
+		logger.Errorf("获取部署目标详情失败#部署目标id-%d", id)
+		return json.Success(utils.SuccessContent, nil)
+	}
+
+	return json.Success(utils.SuccessContent, targetModel)
+}
+
+type DeployTargetForm struct {
+	Id          int
+	Name        string `binding:"Required;MaxSize(64)"`
+	Type        string `binding:"Required;MaxSize(32)"`
+	Config      string `binding:"Required"`
+	AccessKeyId int
+	Status      models.Status `binding:"In(0,1)"`
+	Remark      string
+}
+
+// Error 表单验证错误处理
+func (f DeployTargetForm) Error(ctx *macaron.Context, errs binding.Errors) {
+	if len(errs) == 0 {
+		return
+	}
+	json := utils.JsonResponse{}
+	content := json.CommonFailure("表单验证失败, 请检测输入")
+	_, _ = ctx.Write([]byte(content))
+}
+
+// DeployTargetStore 保存、修改部署目标，保存前校验部署类型的配置
+func DeployTargetStore(ctx *macaron.Context, form DeployTargetForm) string {
+	json := utils.JsonResponse{}
+	target := new(deploy.Target)
+	nameExist, err := target.NameExists(form.Name, form.Id)
+	if err != nil {
+		return json.CommonFailure("操作失败", err)
+	}
+	if nameExist {
+		return json.CommonFailure("部署目标名称已存在")
+	}
+
+	target.Name = strings.TrimSpace(form.Name)
+	target.Type = strings.TrimSpace(form.Type)
+	target.Config = strings.TrimSpace(form.Config)
+	target.AccessKeyId = form.AccessKeyId
+	target.Status = form.Status
+	target.Remark = strings.TrimSpace(form.Remark)
+	if target.AccessKeyId > 0 {
+		if err = target.AccessKey.Find(target.AccessKeyId); err != nil || target.AccessKey.Id == 0 {
+			return json.CommonFailure("AccessKey不存在", err)
+		}
+	}
+	if err = deploy.Validate(target); err != nil {
+		return json.CommonFailure("部署配置错误-" + err.Error())
+	}
+
+	if form.Id > 0 {
+		_, err = target.UpdateBean(form.Id)
+	} else {
+		_, err = target.Create()
+	}
+	if err != nil {
+		return json.CommonFailure("保存失败", err)
+	}
+
+	return json.Success("保存成功", nil)
+}
+
+// DeployTargetRemove 删除部署目标
+func DeployTargetRemove(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	id, err := strconv.Atoi(ctx.Params(":id"))
+	if err != nil {
+		return json.CommonFailure("参数错误", err)
+	}
+	targetModel := new(models.DeployTarget)
+	if _, err = targetModel.Delete(id); err != nil {
+		return json.CommonFailure(utils.FailureContent, err)
+	}
+
+	return json.Success(utils.SuccessContent, nil)
+}
+
+// DeployBindings 证书关联的部署目标及最近一次部署结果
+func DeployBindings(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	bindingModel := new(models.CertificateDeployTarget)
+	bindings, err := bindingModel.ListByCertificate(ctx.ParamsInt(":id"))
+	if err != nil {
+		return json.CommonFailure(utils.FailureContent, err)
+	}
+
+	return json.Success(utils.SuccessContent, bindings)
+}
+
+// DeployBind 设置证书关联的部署目标，target_ids以逗号隔开
+func DeployBind(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	id := ctx.ParamsInt(":id")
+	certificateModel := new(models.Certificate)
+	if err := certificateModel.Find(id); err != nil || certificateModel.Id == 0 {
+		return json.CommonFailure("证书不存在", err)
+	}
+	targetIds := make([]int, 0)
+	for _, value := range strings.Split(ctx.QueryTrim("target_ids"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		targetId, err := strconv.Atoi(value)
+		if err != nil {
+			return json.CommonFailure("参数错误", err)
+		}
+		targetModel := new(models.DeployTarget)
+		if err = targetModel.Find(targetId); err != nil || targetModel.Id == 0 {
+			return json.CommonFailure("部署目标不存在#ID-"+value, err)
+		}
+		targetIds = append(targetIds, targetId)
+	}
+	bindingModel := new(models.CertificateDeployTarget)
+	if err := bindingModel.Bind(id, targetIds); err != nil {
+		return json.CommonFailure(utils.FailureContent, err)
+	}
+
+	return json.Success(utils.SuccessContent, nil)
+}
+
+// Deploy 证书部署到关联的部署目标
+func Deploy(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	id := ctx.ParamsInt(":id")
+	certificateModel := new(models.Certificate)
+	if err := certificateModel.Find(id); err != nil || certificateModel.Id == 0 {
+		return json.CommonFailure("证书不存在", err)
+	}
+	result, err := service.DeployCertificate(*certificateModel)
+	if err != nil {
+		logger.Errorf("证书部署失败#证书id-%d#%v", id, err)
+		return json.CommonFailure(result+err.Error(), err)
+	}
+
+	return json.Success(result, nil)
+}
+
+// DeployRollback 部署目标恢复到最近一次部署前的状态
+func DeployRollback(ctx *macaron.Context) string {
+	json := utils.JsonResponse{}
+	result, err := service.RollbackDeployment(ctx.ParamsInt(":id"), ctx.QueryInt("target_id"))
+	if err != nil {
+		return json.CommonFailure(err.Error(), err)
+	}
+
+	return json.Success(result, nil)
+}
@@ -2,6 +2,7 @@ package manage
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/ouqiang/gocron/internal/models"
 	"github.com/ouqiang/gocron/internal/modules/logger"
@@ -128,3 +129,14 @@ func UpdateWebHook(ctx *macaron.Context) string {
 }
 
 // endregion
+
+// RotateSecret 使用当前主密钥重新加密私钥等敏感数据，轮换主密钥或升级后加密已有的明文数据
+func RotateSecret(ctx *macaron.Context) string {
+	jsonResp := utils.JsonResponse{}
+	total, err := models.RotateSecrets()
+	if err != nil {
+		return jsonResp.CommonFailure("重新加密失败-"+err.Error(), err)
+	}
+
+	return jsonResp.Success(fmt.Sprintf("重新加密成功, 共%d条记录", total), nil)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/ouqiang/gocron/internal/modules/app"
 	"github.com/ouqiang/gocron/internal/modules/logger"
 	"github.com/ouqiang/gocron/internal/modules/utils"
+	"github.com/ouqiang/gocron/internal/routers/certificate"
 	"github.com/ouqiang/gocron/internal/routers/host"
 	"github.com/ouqiang/gocron/internal/routers/install"
 	"github.com/ouqiang/gocron/internal/routers/loginlog"
@@ -104,6 +105,37 @@ func Register(m *macaron.Macaron) {
 		m.Post("/remove/:id", host.Remove)
 	})
 
+	// 证书部署目标
+	m.Group("/deploy", func() {
+		m.Get("/types", certificate.DeployTypes)
+		m.Get("/target", certificate.DeployTargetIndex)
+		m.Get("/target/:id", certificate.DeployTargetDetail)
+		m.Post("/target/store", binding.Bind(certificate.DeployTargetForm{}), certificate.DeployTargetStore)
+		m.Post("/target/remove/:id", certificate.DeployTargetRemove)
+	})
+
+	// 证书
+	m.Group("/certificate", func() {
+		m.Get("/ocsp/:id", certificate.Ocsp)
+		m.Get("/versions/:id", certificate.Versions)
+		m.Get("/version/diff", certificate.VersionDiff)
+		m.Post("/rollback/:id", certificate.Rollback)
+		m.Post("/export/:id", certificate.Export)
+		m.Post("/import", certificate.Import)
+		m.Post("/csr", certificate.ObtainForCSR)
+		m.Get("/deploy/targets/:id", certificate.DeployBindings)
+		m.Post("/deploy/bind/:id", certificate.DeployBind)
+		m.Post("/deploy/:id", certificate.Deploy)
+		m.Post("/deploy/rollback/:id", certificate.DeployRollback)
+		m.Group("/account", func() {
+			m.Get("/status/:id", certificate.AccountStatus)
+			m.Post("/contact/:id", certificate.AccountContact)
+			m.Post("/rollover/:id", certificate.AccountKeyRollover)
+			m.Post("/deactivate/:id", certificate.AccountDeactivate)
+			m.Post("/resolve/:id", certificate.AccountResolve)
+		})
+	})
+
 	// 管理
 	m.Group("/system", func() {
 		m.Group("/slack", func() {
@@ -123,6 +155,7 @@ func Register(m *macaron.Macaron) {
 			m.Post("/update", manage.UpdateWebHook)
 		})
 		m.Get("/login-log", loginlog.Index)
+		m.Post("/secret/rotate", manage.RotateSecret)
 	})
 
 	// API
@@ -1,8 +1,10 @@
 package task
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/ouqiang/goutil"
 
@@ -24,8 +26,8 @@ type TaskForm struct {
 	DependencyTaskId string
 	Name             string `binding:"Required;MaxSize(32)"`
 	Spec             string
-	Protocol         models.TaskProtocol   `binding:"In(1,2)"`
-	Command          string                `binding:"Required;MaxSize(256)"`
+	Protocol         models.TaskProtocol   `binding:"In(1,2,3,4,5)"`
+	Command          string                `binding:"Required"`
 	HttpMethod       models.TaskHTTPMethod `binding:"In(1,2)"`
 	Timeout          int                   `binding:"Range(0,86400)"`
 	Multi            int8                  `binding:"In(1,2)"`
@@ -103,6 +105,10 @@ func Store(ctx *macaron.Context, form TaskForm) string {
 	if form.Protocol == models.TaskRPC && form.HostId == "" {
 		return json.CommonFailure("请选择主机名")
 	}
+	// 证书任务的参数不限制长度
+	if !form.Protocol.IsCertificate() && utf8.RuneCountInString(strings.TrimSpace(form.Command)) > models.TaskCommandMaxSize {
+		return json.CommonFailure(fmt.Sprintf("命令长度不能超过%d个字符", models.TaskCommandMaxSize))
+	}
 
 	taskModel.Name = form.Name
 	taskModel.Protocol = form.Protocol
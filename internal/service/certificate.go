@@ -0,0 +1,252 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/app"
+	"github.com/ouqiang/gocron/internal/modules/letsencrypt"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+	"github.com/ouqiang/gocron/internal/modules/notify"
+	"github.com/ouqiang/goutil"
+)
+
+// 证书到期检查、OCSP查询在调度器中的名称
+const (
+	certificateScanCronName = "certificate-scan"
+	certificateOcspCronName = "certificate-ocsp"
+)
+
+// 续期失败后重试的最长间隔
+const renewMaxBackoff = 24 * time.Hour
+
+// 证书到期检查
+// 证书到达续期时间(CA通过ARI建议的时间或域名配置的续期天数)时运行对应的续期任务，没有续期任务、导入的证书或证书已过期时发送告警
+type CertificateScanner struct{}
+
+var ServiceCertificateScanner CertificateScanner
+
+// 添加到调度器，按配置的crontab表达式定时检查
+func (scanner CertificateScanner) Initialize() {
+	scanner.addFunc("证书到期检查", app.Setting.CertScanSpec, scanner.Scan, certificateScanCronName)
+	scanner.addFunc("证书OCSP查询", app.Setting.CertOcspSpec, scanner.CheckOcsp, certificateOcspCronName)
+}
+
+func (scanner CertificateScanner) addFunc(name, spec string, cmd func(), cronName string) {
+	if spec == "" {
+		logger.Infof("未配置%s", name)
+		return
+	}
+	err := goutil.PanicToError(func() {
+		serviceCron.AddFunc(spec, cmd, cronName)
+	})
+	if err != nil {
+		logger.Errorf("添加%s到调度器失败#%s", name, err)
+	}
+}
+
+// 检查所有已签发的证书
+func (scanner CertificateScanner) Scan() {
+	certificateModel := new(models.Certificate)
+	certificates, err := certificateModel.IssuedList()
+	if err != nil {
+		logger.Error("证书到期检查#获取证书列表失败#", err)
+		return
+	}
+	renewTasks, err := scanner.renewTasks()
+	if err != nil {
+		logger.Error("证书到期检查#获取续期任务失败#", err)
+		return
+	}
+	configs := make(map[int]*models.DomainConfig)
+	for i := range certificates {
+		certificate := &certificates[i]
+		config, ok := configs[certificate.DomainConfigId]
+		if !ok {
+			config = new(models.DomainConfig)
+			if err = config.Find(certificate.DomainConfigId); err != nil {
+				logger.Errorf("证书到期检查#查询域名配置失败#证书ID-%d#%s", certificate.Id, err)
+				continue
+			}
+			configs[certificate.DomainConfigId] = config
+		}
+		scanner.check(certificate, config, renewTasks[certificate.Id])
+	}
+}
+
+func (scanner CertificateScanner) check(certificate *models.Certificate, config *models.DomainConfig, renewTask *models.Task) {
+	// 升级前保存的证书没有解析有效期
+	if certificate.NotAfter.IsZero() {
+		// 到期检查查询的证书不包含私钥，保存前查询完整的证书
+		full := new(models.Certificate)
+		if err := full.Find(certificate.Id); err != nil {
+			logger.Errorf("证书到期检查#查询证书失败#证书ID-%d#%s", certificate.Id, err)
+			return
+		}
+		if _, err := full.UpdateBean(full.Id); err != nil {
+			sendCertificateAlert(fmt.Sprintf("证书解析失败#证书ID-%d#域名-%s#%s", certificate.Id, certificate.Domain, err))
+			return
+		}
+		*certificate = *full
+	}
+
+	// 查询CA建议的续期时间需要申请证书的账号，没有记录账号时使用续期任务的账号
+	au := new(models.AcmeUser)
+	acmeUserId := certificate.AcmeUserId
+	if acmeUserId == 0 && renewTask != nil {
+		if p, err := letsencrypt.CreateRenewParam(renewTask.Command); err == nil {
+			acmeUserId = p.AcmeUserId
+		}
+	}
+	if acmeUserId > 0 {
+		if err := au.Find(acmeUserId); err != nil {
+			logger.Errorf("证书到期检查#查询ACME账号失败#证书ID-%d#%s", certificate.Id, err)
+		}
+	}
+	renewal, err := letsencrypt.ScheduleRenewal(au, *config, certificate)
+	if err != nil {
+		sendCertificateAlert(fmt.Sprintf("计算证书续期时间失败#证书ID-%d#域名-%s#%s", certificate.Id, certificate.Domain, err))
+		return
+	}
+	if !renewal {
+		return
+	}
+
+	daysLeft := certificate.DaysLeft()
+	if certificate.NotAfter.Before(time.Now()) {
+		certificate.Status = models.CertificateStatusExpired
+		if _, err = certificate.UpdateStatus(certificate.Id); err != nil {
+			logger.Errorf("证书到期检查#更新证书状态失败#证书ID-%d#%s", certificate.Id, err)
+		}
+		sendCertificateAlert(fmt.Sprintf("证书已过期#证书ID-%d#域名-%s#过期时间-%s",
+			certificate.Id, certificate.Domains, certificate.NotAfter.Format(time.RFC3339)))
+	}
+	if certificate.IsExternal() {
+		sendCertificateAlert(fmt.Sprintf("导入的证书已到续期时间，请重新签发后导入#证书ID-%d#域名-%s#剩余%d天",
+			certificate.Id, certificate.Domains, daysLeft))
+		return
+	}
+	if renewTask == nil {
+		sendCertificateAlert(fmt.Sprintf("证书已到续期时间且没有续期任务#证书ID-%d#域名-%s#剩余%d天",
+			certificate.Id, certificate.Domains, daysLeft))
+		return
+	}
+
+	// 上次运行续期任务后证书仍未替换，说明续期失败，按失败次数延长下次运行的间隔
+	if certificate.RenewAttempts > 0 {
+		next := certificate.RenewAttemptAt.Add(renewBackoff(certificate.RenewAttempts))
+		if time.Now().Before(next) {
+			logger.Infof("证书到期检查#续期已失败%d次，%s后重试#证书ID-%d#任务ID-%d",
+				certificate.RenewAttempts, next.Format(time.RFC3339), certificate.Id, renewTask.Id)
+			return
+		}
+	}
+	certificate.RenewAttempts++
+	certificate.RenewAttemptAt = time.Now()
+	if _, err = certificate.UpdateRenewAttempt(certificate.Id); err != nil {
+		logger.Errorf("证书到期检查#保存续期记录失败#证书ID-%d#%s", certificate.Id, err)
+	}
+
+	logger.Infof("证书到期检查#证书已到续期时间，剩余%d天，运行续期任务#证书ID-%d#任务ID-%d", daysLeft, certificate.Id, renewTask.Id)
+	renewTask.Spec = fmt.Sprintf("证书到期检查(证书ID-%d)", certificate.Id)
+	ServiceTask.Run(*renewTask)
+}
+
+// 续期失败后的重试间隔，从1小时开始每次翻倍，最长1天
+func renewBackoff(attempts int) time.Duration {
+	backoff := time.Hour
+	for i := 1; i < attempts && backoff < renewMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > renewMaxBackoff {
+		backoff = renewMaxBackoff
+	}
+
+	return backoff
+}
+
+// 查询所有未过期证书的OCSP状态并保存OCSP响应，证书被注销或OCSP服务器无法访问时发送告警
+// 查询失败时保留上次的OCSP响应，在有效期内仍可用于stapling
+func (scanner CertificateScanner) CheckOcsp() {
+	certificateModel := new(models.Certificate)
+	certificates, err := certificateModel.IssuedList()
+	if err != nil {
+		logger.Error("证书OCSP查询#获取证书列表失败#", err)
+		return
+	}
+	for i := range certificates {
+		certificate := &certificates[i]
+		if !certificate.NotAfter.IsZero() && certificate.NotAfter.Before(time.Now()) {
+			continue
+		}
+		scanner.checkOcsp(certificate)
+	}
+}
+
+func (scanner CertificateScanner) checkOcsp(certificate *models.Certificate) {
+	result, err := letsencrypt.FetchOCSP(*certificate)
+	if err != nil {
+		sendCertificateAlert(fmt.Sprintf("证书OCSP查询失败#证书ID-%d#域名-%s#%s", certificate.Id, certificate.Domains, err))
+		return
+	}
+	certificate.OcspStatus = result.Status
+	certificate.OcspResponse = result.Raw
+	certificate.OcspThisUpdate = result.ThisUpdate
+	certificate.OcspNextUpdate = result.NextUpdate
+	certificate.OcspCheckedAt = time.Now()
+	if _, err = certificate.UpdateOcsp(certificate.Id); err != nil {
+		logger.Errorf("证书OCSP查询#保存OCSP响应失败#证书ID-%d#%s", certificate.Id, err)
+	}
+	if result.Status == letsencrypt.OCSPStatusRevoked {
+		// 在其他地方注销的证书不能再部署
+		certificate.Status = models.CertificateStatusRevoked
+		certificate.RevokedAt = result.RevokedAt
+		certificate.RevocationReason = result.RevocationReason
+		if _, err = certificate.UpdateStatus(certificate.Id); err != nil {
+			logger.Errorf("证书OCSP查询#更新证书状态失败#证书ID-%d#%s", certificate.Id, err)
+		}
+		sendCertificateAlert(fmt.Sprintf("证书已被注销#证书ID-%d#域名-%s#注销时间-%s#注销原因-%d",
+			certificate.Id, certificate.Domains, result.RevokedAt.Format(time.RFC3339), result.RevocationReason))
+	}
+}
+
+// 续期任务，key为证书ID
+func (scanner CertificateScanner) renewTasks() (map[int]*models.Task, error) {
+	taskModel := new(models.Task)
+	tasks, err := taskModel.ActiveListByProtocol(models.TaskCertificateRenew)
+	if err != nil {
+		return nil, err
+	}
+	renewTasks := make(map[int]*models.Task)
+	for i := range tasks {
+		p, err := letsencrypt.CreateRenewParam(tasks[i].Command)
+		if err != nil || p.CertificateId <= 0 {
+			continue
+		}
+		renewTasks[p.CertificateId] = &tasks[i]
+	}
+
+	return renewTasks, nil
+}
+
+// 发送证书告警，未配置告警通知时只记录日志
+func sendCertificateAlert(content string) {
+	logger.Warn("证书告警#", content)
+	notifyType := app.Setting.CertAlertNotifyType
+	if notifyType == 0 {
+		return
+	}
+	if notifyType != 3 && app.Setting.CertAlertReceiverId == "" {
+		return
+	}
+	msg := notify.Message{
+		"task_type":        notifyType,
+		"task_receiver_id": app.Setting.CertAlertReceiverId,
+		"name":             "证书告警",
+		"output":           content,
+		"status":           "告警",
+		"task_id":          0,
+	}
+	notify.Push(msg)
+}
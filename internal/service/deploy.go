@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/deploy"
+	"github.com/ouqiang/gocron/internal/modules/letsencrypt"
+	"github.com/ouqiang/gocron/internal/modules/logger"
+)
+
+// 任务参数指定了SLB时部署主证书，再部署每个证书关联的部署目标
+// 部署目标之间互不影响，返回全部部署目标的输出和错误
+func deployCertificates(p *letsencrypt.Param, ak models.AccessKey, certificates []*models.Certificate) (string, error) {
+	result, err := deployCertificate2AliyunSLB(p, ak, *certificates[0])
+	errs := []error{err}
+	for _, certificate := range certificates {
+		output, err := DeployCertificate(*certificate)
+		result += output
+		errs = append(errs, err)
+	}
+
+	return result, joinDeployErrors(errs)
+}
+
+// 证书部署到任务参数指定的阿里云SLB，未指定SLB时跳过，失败时也返回已部署部分的输出
+func deployCertificate2AliyunSLB(p *letsencrypt.Param, ak models.AccessKey, certificate models.Certificate) (result string, err error) {
+	if p.AliyunSLBId <= 0 {
+		return "", nil
+	}
+	aslb := new(models.AliyunSLB)
+	if err = aslb.Find(p.AliyunSLBId); err != nil || aslb.Id == 0 {
+		return "", notFoundError("SLB配置", p.AliyunSLBId, err)
+	}
+	target, err := deploy.NewAliyunSLBTarget(*aslb, ak)
+	if err != nil {
+		return "", err
+	}
+	deployResult, err := deploy.Run(target, certificate)
+	if deployResult != nil && deployResult.Output != "" {
+		result = deployResult.Output + "\n"
+	}
+	if err != nil {
+		return result, fmt.Errorf("证书部署到SLB失败#SLB-%s#端口-%d#%s", aslb.LoadBalancerId, aslb.ListenerPort, err)
+	}
+
+	return result, nil
+}
+
+// 证书部署到指定的阿里云SLB，用于回滚、导入证书后部署
+func deployCertificateByAliyunSLBId(certificate models.Certificate, aliyunSLBId, accessKeyId int) (string, error) {
+	if aliyunSLBId <= 0 {
+		return "", nil
+	}
+	ak := new(models.AccessKey)
+	if err := ak.Find(accessKeyId); err != nil || ak.Id == 0 {
+		return "", notFoundError("AccessKey", accessKeyId, err)
+	}
+	p := &letsencrypt.Param{AliyunSLBId: aliyunSLBId, AccessKeyId: accessKeyId}
+
+	return deployCertificate2AliyunSLB(p, *ak, certificate)
+}
+
+// 证书部署到关联的全部启用的部署目标，部署目标之间互不影响，返回全部部署目标的错误
+func DeployCertificate(certificate models.Certificate) (string, error) {
+	bindingModel := new(models.CertificateDeployTarget)
+	bindings, err := bindingModel.ListByCertificate(certificate.Id)
+	if err != nil {
+		return "", fmt.Errorf("查询证书的部署目标失败#证书ID-%d#%s", certificate.Id, err)
+	}
+	result := ""
+	errs := make([]error, 0, len(bindings))
+	for i := range bindings {
+		output, err := deployCertificateToTarget(certificate, &bindings[i])
+		result += output
+		errs = append(errs, err)
+	}
+
+	return result, joinDeployErrors(errs)
+}
+
+// 合并多个部署目标的错误，每个错误一行，全部成功时返回nil
+func joinDeployErrors(errs []error) error {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+// 部署到单个部署目标，记录部署结果，部署目标已禁用时跳过
+func deployCertificateToTarget(certificate models.Certificate, binding *models.CertificateDeployTarget) (string, error) {
+	target, err := loadDeployTarget(binding.DeployTargetId)
+	if err != nil {
+		return "", err
+	}
+	if !target.IsEnabled() {
+		return fmt.Sprintf("部署目标已禁用，跳过#%s\n", target.Name), nil
+	}
+
+	deployResult, err := deploy.Run(target, certificate)
+	binding.DeployedAt = time.Now()
+	binding.Fingerprint = certificate.Fingerprint
+	binding.Status = models.DeployStatusSuccess
+	if deployResult != nil {
+		binding.Result = deployResult.Output
+		// 部署后才有回滚需要的状态，验证失败已回滚时保留上一次的状态
+		if _, ok := err.(*deploy.RolledBackError); !ok {
+			binding.RollbackState = deployResult.State
+		}
+	}
+	if err != nil {
+		binding.Status = models.DeployStatusFailed
+		if _, ok := err.(*deploy.RolledBackError); ok {
+			binding.Status = models.DeployStatusRolledBack
+		}
+		binding.Result = strings.TrimSpace(binding.Result + "\n" + err.Error())
+	}
+	if _, updateErr := binding.UpdateResult(binding.Id); updateErr != nil {
+		logger.Errorf("保存部署结果失败#证书ID-%d#部署目标-%s#%s", certificate.Id, target.Name, updateErr)
+	}
+	if err != nil {
+		// 包含部署过程的输出，如：每个加速域名的部署结果
+		return "", fmt.Errorf("证书部署失败#证书ID-%d#部署目标-%s#%s", certificate.Id, target.Name, binding.Result)
+	}
+
+	return fmt.Sprintf("证书部署成功#证书ID-%d#部署目标-%s#%s\n", certificate.Id, target.Name, deployResult.Output), nil
+}
+
+// 部署目标恢复到最近一次部署前的状态
+func RollbackDeployment(certificateId, targetId int) (string, error) {
+	binding := new(models.CertificateDeployTarget)
+	if err := binding.Find(certificateId, targetId); err != nil || binding.Id == 0 {
+		return "", notFoundError("证书的部署目标", targetId, err)
+	}
+	target, err := loadDeployTarget(targetId)
+	if err != nil {
+		return "", err
+	}
+	if err = deploy.Rollback(target, binding.RollbackState); err != nil {
+		return "", fmt.Errorf("部署回滚失败#部署目标-%s#%s", target.Name, err)
+	}
+	binding.Status = models.DeployStatusRolledBack
+	binding.RollbackState = ""
+	binding.Result = fmt.Sprintf("手动回滚#%s", time.Now().Format(models.DefaultTimeFormat))
+	if _, err = binding.UpdateResult(binding.Id); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("部署回滚成功#部署目标-%s", target.Name), nil
+}
+
+// 加载部署目标及其使用的AccessKey
+func loadDeployTarget(id int) (*deploy.Target, error) {
+	target := new(deploy.Target)
+	if err := target.Find(id); err != nil || target.Id == 0 {
+		return nil, notFoundError("部署目标", id, err)
+	}
+	if target.AccessKeyId > 0 {
+		if err := target.AccessKey.Find(target.AccessKeyId); err != nil || target.AccessKey.Id == 0 {
+			return nil, notFoundError("AccessKey", target.AccessKeyId, err)
+		}
+	}
+
+	return target, nil
+}
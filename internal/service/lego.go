@@ -1,24 +1,312 @@
 package service
 
-import "github.com/ouqiang/gocron/internal/models"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ouqiang/gocron/internal/models"
+	"github.com/ouqiang/gocron/internal/modules/letsencrypt"
+)
 
 // 证书申请执行任务
 type CertificateObtainHandler struct{}
 
 func (h *CertificateObtainHandler) Run(taskModel models.Task, taskUniqueId int64) (result string, err error) {
-	return
+	defer func() {
+		result = certificateTaskResult(result, err)
+	}()
+	p, err := letsencrypt.CreateObtainParam(taskModel.Command)
+	if err != nil {
+		return "", err
+	}
+	if err = p.ValidationObtain(); err != nil {
+		return "", err
+	}
+
+	return obtainCertificate(p, p.Csr)
+}
+
+// 使用上传的CSR申请证书，私钥由用户保管，申请的证书不保存私钥
+// 参数检查通过后保存为停用的证书申请任务并在后台运行一次，结果记录在该任务的日志中
+func ObtainCertificateForCSR(p *letsencrypt.Param) (taskId int, err error) {
+	if strings.TrimSpace(p.Csr) == "" {
+		return 0, fmt.Errorf("错误：csr无效！")
+	}
+	if err = p.ValidationObtain(); err != nil {
+		return 0, err
+	}
+	command, err := p.Command()
+	if err != nil {
+		return 0, err
+	}
+	// 任务不加入调度，保存后任务日志可以关联到任务，也可以随任务删除
+	taskModel := &models.Task{
+		Name:             fmt.Sprintf("CSR申请证书-%s", time.Now().Format("20060102150405")),
+		Level:            models.TaskLevelParent,
+		DependencyStatus: models.TaskDependencyStatusStrong,
+		Spec:             "0 0 0 1 1 *",
+		Protocol:         models.TaskCertificateObtain,
+		Command:          command,
+		HttpMethod:       models.TaskHTTPMethodGet,
+		Multi:            1,
+		Remark:           "使用CSR申请证书时创建，只运行一次",
+		Status:           models.Disabled,
+	}
+	if taskId, err = taskModel.Create(); err != nil {
+		return 0, fmt.Errorf("保存证书申请任务失败：%s", err)
+	}
+	ServiceTask.Run(*taskModel)
+
+	return taskId, nil
+}
+
+// 申请证书并保存，指定了SLB时部署主证书，再部署到每个证书关联的部署目标
+func obtainCertificate(p *letsencrypt.Param, csrPEM string) (result string, err error) {
+	au, config, ak, err := loadCertificateParam(p)
+	if err != nil {
+		return "", err
+	}
+
+	certificates, err := letsencrypt.ObtainCertificate(au, *config, *ak, csrPEM)
+	if err != nil {
+		return "", err
+	}
+	for i, certificate := range certificates {
+		// 主证书保存到参数指定的证书，未指定证书的主证书和另一种私钥类型的证书保存到该域名配置已有的同类型证书，没有时新增
+		id := p.CertificateId
+		if i > 0 || id <= 0 {
+			if id, err = findDomainConfigCertificate(config.Id, certificate); err != nil {
+				return result, err
+			}
+		}
+		if err = saveCertificate(certificate, id); err != nil {
+			return result, fmt.Errorf("证书申请成功，保存证书失败：%s", err)
+		}
+		result += fmt.Sprintf("证书申请成功#证书ID-%d#域名-%s#私钥类型-%s\n", certificate.Id, certificate.Domain, certificate.KeyType)
+	}
+
+	output, err := deployCertificates(p, *ak, certificates)
+	return result + output, err
 }
 
 // 证书续期执行任务
 type CertificateRenewHandler struct{}
 
 func (h *CertificateRenewHandler) Run(taskModel models.Task, taskUniqueId int64) (result string, err error) {
-	return
+	defer func() {
+		result = certificateTaskResult(result, err)
+	}()
+	p, err := letsencrypt.CreateRenewParam(taskModel.Command)
+	if err != nil {
+		return "", err
+	}
+	if err = p.ValidationRenew(); err != nil {
+		return "", err
+	}
+	au, config, ak, err := loadCertificateParam(p)
+	if err != nil {
+		return "", err
+	}
+	oldCertificate := new(models.Certificate)
+	if err = oldCertificate.Find(p.CertificateId); err != nil || oldCertificate.Id == 0 {
+		return "", notFoundError("证书", p.CertificateId, err)
+	}
+	if oldCertificate.IsExternal() {
+		return "", fmt.Errorf("导入的证书不能通过ACME续期，请重新导入#证书ID-%d", oldCertificate.Id)
+	}
+
+	certificate, err := letsencrypt.RenewCertificate(au, *config, *ak, *oldCertificate)
+	if err != nil {
+		return "", err
+	}
+	if err = saveCertificate(certificate, oldCertificate.Id); err != nil {
+		return "", fmt.Errorf("证书续期成功，保存证书失败：%s", err)
+	}
+	result = fmt.Sprintf("证书续期成功#证书ID-%d#域名-%s#私钥类型-%s\n", certificate.Id, certificate.Domain, certificate.KeyType)
+	renewedCertificates := []*models.Certificate{certificate}
+
+	// 同时申请了RSA和ECDSA证书时，另一种私钥类型的证书一起续期
+	for _, keyType := range config.KeyTypes() {
+		if keyType == certificate.KeyType {
+			continue
+		}
+		partner := new(models.Certificate)
+		if err = partner.FindByDomainConfig(config.Id, keyType); err != nil {
+			return result, err
+		}
+		if partner.Id == 0 || partner.IsExternal() {
+			continue
+		}
+		renewed, err := letsencrypt.RenewCertificate(au, *config, *ak, *partner)
+		if e, ok := err.(*letsencrypt.Error); ok && e.Category == letsencrypt.ErrorCategoryNoRenewal {
+			result += fmt.Sprintf("证书未到续期时间#证书ID-%d#私钥类型-%s\n", partner.Id, partner.KeyType)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if err = saveCertificate(renewed, partner.Id); err != nil {
+			return result, fmt.Errorf("证书续期成功，保存证书失败：%s", err)
+		}
+		result += fmt.Sprintf("证书续期成功#证书ID-%d#域名-%s#私钥类型-%s\n", renewed.Id, renewed.Domain, renewed.KeyType)
+		renewedCertificates = append(renewedCertificates, renewed)
+	}
+
+	output, err := deployCertificates(p, *ak, renewedCertificates)
+	return result + output, err
 }
 
 // 证书注销执行任务
 type CertificateRevokeHandler struct{}
 
 func (h *CertificateRevokeHandler) Run(taskModel models.Task, taskUniqueId int64) (result string, err error) {
-	return
+	defer func() {
+		result = certificateTaskResult(result, err)
+	}()
+	p, err := letsencrypt.CreateRevokeParam(taskModel.Command)
+	if err != nil {
+		return "", err
+	}
+	if err = p.ValidationRevoke(); err != nil {
+		return "", err
+	}
+	var au *models.AcmeUser
+	if p.AcmeUserId > 0 {
+		au = new(models.AcmeUser)
+		if err = au.Find(p.AcmeUserId); err != nil || au.Id == 0 {
+			return "", notFoundError("ACME账号", p.AcmeUserId, err)
+		}
+	}
+	certificate := new(models.Certificate)
+	if err = certificate.Find(p.CertificateId); err != nil || certificate.Id == 0 {
+		return "", notFoundError("证书", p.CertificateId, err)
+	}
+	if certificate.IsRevoked() {
+		return "", fmt.Errorf("证书已注销#证书ID-%d", certificate.Id)
+	}
+	reason, err := letsencrypt.ParseRevocationReason(p.Reason)
+	if err != nil {
+		return "", err
+	}
+
+	if err = letsencrypt.RevokeCertificate(au, p.CaDirUrl, *certificate, reason, p.UseCertificateKey); err != nil {
+		return "", err
+	}
+	certificate.Status = models.CertificateStatusRevoked
+	certificate.RevokedAt = time.Now()
+	certificate.RevocationReason = int(reason)
+	if _, err = certificate.UpdateStatus(certificate.Id); err != nil {
+		return "", fmt.Errorf("证书注销成功，更新证书状态失败：%s", err)
+	}
+
+	return fmt.Sprintf("证书注销成功#证书ID-%d#域名-%s#注销原因-%d", certificate.Id, certificate.Domain, reason), nil
+}
+
+// 保存证书并新增一个当前版本，id大于0时更新已有的证书，之前的版本保留用于回滚
+func saveCertificate(certificate *models.Certificate, id int) error {
+	return certificate.SaveVersion(id)
+}
+
+// 域名配置下与申请的证书私钥类型相同的证书ID，没有时返回0
+// 导入的证书不替换；使用CSR申请的证书没有私钥，与有私钥的证书不互相替换
+func findDomainConfigCertificate(domainConfigId int, certificate *models.Certificate) (int, error) {
+	existing := new(models.Certificate)
+	if err := existing.FindByDomainConfig(domainConfigId, certificate.KeyType); err != nil {
+		return 0, err
+	}
+	if existing.IsExternal() || existing.HasPrivateKey() != certificate.HasPrivateKey() {
+		return 0, nil
+	}
+
+	return existing.Id, nil
+}
+
+// 任务执行结果，失败时追加错误分类和ACME返回的错误详情
+func certificateTaskResult(result string, err error) string {
+	if err == nil {
+		return result
+	}
+	if e, ok := err.(*letsencrypt.Error); ok {
+		return result + fmt.Sprintf("错误分类: %s\n错误详情: %s\n", e.Category, e.Detail())
+	}
+
+	return result + fmt.Sprintf("错误详情: %s\n", err)
+}
+
+// 加载申请、续期证书需要的账号、域名配置、AccessKey
+func loadCertificateParam(p *letsencrypt.Param) (au *models.AcmeUser, config *models.DomainConfig, ak *models.AccessKey, err error) {
+	au = new(models.AcmeUser)
+	if err = au.Find(p.AcmeUserId); err != nil || au.Id == 0 {
+		return nil, nil, nil, notFoundError("ACME账号", p.AcmeUserId, err)
+	}
+	config = new(models.DomainConfig)
+	if err = config.Find(p.DoaminConfigId); err != nil || config.Id == 0 {
+		return nil, nil, nil, notFoundError("域名配置", p.DoaminConfigId, err)
+	}
+	ak = new(models.AccessKey)
+	if p.AccessKeyId > 0 {
+		if err = ak.Find(p.AccessKeyId); err != nil || ak.Id == 0 {
+			return nil, nil, nil, notFoundError("AccessKey", p.AccessKeyId, err)
+		}
+	}
+
+	return au, config, ak, nil
+}
+
+// 记录查询失败或不存在
+func notFoundError(name string, id int, err error) error {
+	if err != nil {
+		return fmt.Errorf("查询%s失败#ID-%d#%s", name, id, err)
+	}
+
+	return fmt.Errorf("%s不存在#ID-%d", name, id)
+}
+
+// 证书回滚到指定版本，指定了SLB时重新部署回滚后的证书，再部署到证书关联的部署目标
+func RollbackCertificate(certificateId, versionId, aliyunSLBId, accessKeyId int) (result string, err error) {
+	certificate := new(models.Certificate)
+	if err = certificate.Find(certificateId); err != nil || certificate.Id == 0 {
+		return "", notFoundError("证书", certificateId, err)
+	}
+	if err = certificate.RollbackVersion(versionId); err != nil {
+		return "", err
+	}
+	result = fmt.Sprintf("证书回滚成功#证书ID-%d#版本ID-%d#序列号-%s\n", certificate.Id, versionId, certificate.SerialNumber)
+	output, slbErr := deployCertificateByAliyunSLBId(*certificate, aliyunSLBId, accessKeyId)
+	result += output
+	output, err = DeployCertificate(*certificate)
+
+	return result + output, joinDeployErrors([]error{slbErr, err})
+}
+
+// 导入其他CA签发的证书，id大于0时作为已导入证书的新版本，指定了SLB时部署导入的证书，再部署到证书关联的部署目标
+func ImportCertificate(id, domainConfigId int, certPEM, keyPEM, chainPEM string, aliyunSLBId, accessKeyId int) (result string, err error) {
+	certificate, err := letsencrypt.ImportCertificate(certPEM, keyPEM, chainPEM)
+	if err != nil {
+		return "", err
+	}
+	if id > 0 {
+		old := new(models.Certificate)
+		if err = old.Find(id); err != nil || old.Id == 0 {
+			return "", notFoundError("证书", id, err)
+		}
+		if !old.IsExternal() {
+			return "", fmt.Errorf("ACME申请的证书不能导入新版本#证书ID-%d", id)
+		}
+		if domainConfigId <= 0 {
+			domainConfigId = old.DomainConfigId
+		}
+	}
+	certificate.DomainConfigId = domainConfigId
+	if err = saveCertificate(certificate, id); err != nil {
+		return "", fmt.Errorf("保存证书失败：%s", err)
+	}
+	result = fmt.Sprintf("证书导入成功#证书ID-%d#域名-%s#过期时间-%s\n", certificate.Id, certificate.Domains,
+		certificate.NotAfter.Format(models.DefaultTimeFormat))
+	output, slbErr := deployCertificateByAliyunSLBId(*certificate, aliyunSLBId, accessKeyId)
+	result += output
+	output, err = DeployCertificate(*certificate)
+
+	return result + output, joinDeployErrors([]error{slbErr, err})
 }
@@ -133,6 +133,8 @@ func (task Task) Initialize() {
 		page++
 	}
 	logger.Infof("定时任务初始化完成, 共%d个定时任务添加到调度器", taskNum)
+
+	ServiceCertificateScanner.Initialize()
 }
 
 // 批量添加任务
@@ -335,15 +337,24 @@ func createJob(taskModel models.Task) cron.FuncJob {
 		concurrencyQueue.Add()
 		defer concurrencyQueue.Done()
 
-		logger.Infof("开始执行任务#%s#命令-%s", taskModel.Name, taskModel.Command)
+		logger.Infof("开始执行任务#%s", jobDescription(taskModel))
 		taskResult := execJob(handler, taskModel, taskLogId)
-		logger.Infof("任务完成#%s#命令-%s", taskModel.Name, taskModel.Command)
+		logger.Infof("任务完成#%s", jobDescription(taskModel))
 		afterExecJob(taskModel, taskResult, taskLogId)
 	}
 
 	return taskFunc
 }
 
+// 日志中的任务信息，证书任务的命令是json格式的参数，只记录任务ID和名称
+func jobDescription(taskModel models.Task) string {
+	if taskModel.Protocol.IsCertificate() {
+		return fmt.Sprintf("任务ID-%d#%s", taskModel.Id, taskModel.Name)
+	}
+
+	return fmt.Sprintf("%s#命令-%s", taskModel.Name, taskModel.Command)
+}
+
 func createHandler(taskModel models.Task) Handler {
 	var handler Handler = nil
 	switch taskModel.Protocol {
@@ -373,7 +384,9 @@ func beforeExecJob(taskModel models.Task) (taskLogId int64) {
 		logger.Error("任务开始执行#写入任务日志失败-", err)
 		return
 	}
-	logger.Debugf("任务命令-%s", taskModel.Command)
+	if !taskModel.Protocol.IsCertificate() {
+		logger.Debugf("任务命令-%s", taskModel.Command)
+	}
 
 	return taskLogId
 }